@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// webAccessLog logs every request of the http server
+var webAccessLog bool
+
+// accessLogWriter records the status and size of a response
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps /stream working through the log
+func (w *accessLogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logAccess logs a line per request once it is served. Only the path is
+// logged, as the query may hold credentials.
+func logAccess(h http.Handler) http.Handler {
+	if !webAccessLog {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		aw := &accessLogWriter{ResponseWriter: w}
+		defer func() {
+			status := aw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			log.Printf("msg=access method=%s path=%q status=%d bytes=%d duration=%s remote=%s",
+				r.Method, r.URL.Path, status, aw.bytes, time.Since(start), r.RemoteAddr)
+		}()
+		h.ServeHTTP(aw, r)
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// targetAddr is a parsed --tile38-addr entry. Entries are either host:port or
+// a URI: tile38://[:password@]host[:port], redis:// alike, rediss:// for TLS,
+// http:// and https:// for the HTTP transport, ws:// and wss:// for
+// WebSockets, or unix:///path/to/socket.
+type targetAddr struct {
+	Addr     string // host:port, unix:///path, http[s]://host:port or ws[s]://host:port
+	TLS      bool
+	Password string
+	uri      *url.URL // nil for host:port entries
+}
+
+// parseTargetAddr parses a --tile38-addr entry. The port of a URI defaults
+// to 9851.
+func parseTargetAddr(raw string) (targetAddr, error) {
+	if !strings.Contains(raw, "://") {
+		if _, _, err := net.SplitHostPort(raw); err != nil {
+			return targetAddr{}, err
+		}
+		return targetAddr{Addr: raw}, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		// The error quotes the URI, password included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return targetAddr{}, fmt.Errorf("invalid URI: %v", err)
+	}
+	ta := targetAddr{uri: u}
+	if u.User != nil {
+		ta.Password, _ = u.User.Password()
+	}
+	switch u.Scheme {
+	case "tile38", "redis", "rediss", "http", "https", "ws", "wss":
+		if u.Hostname() == "" {
+			return targetAddr{}, fmt.Errorf("%s: missing host", u.Redacted())
+		}
+		if u.Path != "" && u.Path != "/" {
+			return targetAddr{}, fmt.Errorf("%s: unexpected path", u.Redacted())
+		}
+		port := u.Port()
+		if port == "" {
+			port = "9851"
+		}
+		ta.Addr = net.JoinHostPort(u.Hostname(), port)
+		switch u.Scheme {
+		case "http", "https", "ws", "wss":
+			ta.Addr = u.Scheme + "://" + ta.Addr
+		}
+		ta.TLS = u.Scheme == "rediss" || u.Scheme == "https" || u.Scheme == "wss"
+	case "unix":
+		if u.Host != "" || u.Path == "" {
+			return targetAddr{}, fmt.Errorf("%s: expected unix:///path/to/socket", u.Redacted())
+		}
+		ta.Addr = "unix://" + u.Path
+	default:
+		return targetAddr{}, fmt.Errorf("%s: unsupported scheme %q", u.Redacted(), u.Scheme)
+	}
+	return ta, nil
+}
+
+// String returns the entry for logging, with the password redacted
+func (ta targetAddr) String() string {
+	if strings.HasPrefix(ta.Addr, "unix://") {
+		return describeAddr(ta.Addr)
+	}
+	if ta.uri != nil {
+		return ta.uri.Redacted()
+	}
+	return ta.Addr
+}
+
+// newTarget creates the target of the entry. A password in the URI takes
+// precedence over the passed passwords, as TILE38_AUTH does. The scheme of a
+// URI decides whether TLS is used, with the configured TLS settings, while
+// host:port entries follow --tile38-tls.
+func (ta targetAddr) newTarget(creds *credentials) *target {
+	if ta.Password != "" {
+		creds = creds.withPassword(ta.Password)
+	}
+	t := newTarget(ta.Addr, creds)
+	switch {
+	case ta.uri == nil:
+	case !ta.TLS:
+		t.tls = nil
+	case t.tls == nil:
+		t.tls = &tls.Config{}
+	}
+	return t
+}
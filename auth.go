@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+// credentials are the candidate AUTH passwords of Tile38 servers, tried in
+// order. During a password rotation both the old and the new password may be
+// given. Passwords read from a file are read again when none is accepted.
+type credentials struct {
+	static []string
+	file   string
+
+	mu       sync.Mutex
+	fromFile []string
+}
+
+// stringList is a flag that may be given multiple times
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// newCredentials returns the credentials of the passed passwords, followed
+// by the ones in the file, if any
+func newCredentials(passwords []string, file string) (*credentials, error) {
+	c := &credentials{file: file}
+	for _, p := range passwords {
+		if p != "" {
+			c.static = append(c.static, p)
+		}
+	}
+	if file != "" {
+		if _, err := c.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// withPassword returns credentials trying the password instead of the
+// static passwords, followed by the ones in the file
+func (c *credentials) withPassword(password string) *credentials {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &credentials{static: []string{password}, file: c.file,
+		fromFile: c.fromFile}
+}
+
+// candidates returns the passwords to try, in order
+func (c *credentials) candidates() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(append([]string(nil), c.static...), c.fromFile...)
+}
+
+// reload reads the passwords file again, one password per line, returning
+// whether its passwords changed
+func (c *credentials) reload() (bool, error) {
+	if c.file == "" {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		return false, err
+	}
+	var list []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			list = append(list, line)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := strings.Join(list, "\n") != strings.Join(c.fromFile, "\n")
+	c.fromFile = list
+	return changed, nil
+}
+
+// authenticate tries the candidate passwords on a new connection until one is
+// accepted. When none is, the passwords file is read again and its new
+// passwords are tried.
+func (c *credentials) authenticate(conn redis.Conn, addr string) error {
+	cands := c.candidates()
+	if len(cands) == 0 {
+		return nil
+	}
+	err := tryPasswords(conn, addr, cands)
+	if err == nil {
+		return nil
+	}
+	if changed, rerr := c.reload(); rerr != nil {
+		log.Printf("auth file: %v", rerr)
+	} else if changed {
+		log.Printf("auth file %s changed, retrying %s", c.file, addr)
+		return tryPasswords(conn, addr, c.candidates())
+	}
+	return err
+}
+
+// tryPasswords sends AUTH with each password until one is accepted. Only the
+// index of the accepted password is logged, never the password.
+func tryPasswords(conn redis.Conn, addr string, passwords []string) error {
+	err := errors.New("no password")
+	for i, pw := range passwords {
+		if err = auth(conn, pw); err == nil {
+			debugf("msg=\"auth accepted\" target=%s credential=%d", addr, i)
+			return nil
+		}
+	}
+	return err
+}
+
+// auth sends AUTH with the password. AUTH is the first command of a
+// connection, which still speaks RESP, so a simple OK reply is accepted along
+// with a JSON one.
+func auth(conn redis.Conn, password string) error {
+	out, err := redis.String(conn.Do("AUTH", password))
+	if err != nil {
+		return asAuthError(err)
+	}
+	if out != "OK" && !gjson.Get(out, "ok").Bool() {
+		return asAuthError(errors.New(gjson.Get(out, "err").String()))
+	}
+	return nil
+}
+
+// authError is the error of a command rejected because of an invalid or
+// missing password
+type authError struct{ msg string }
+
+func (e *authError) Error() string { return "auth: " + e.msg }
+
+// asAuthError returns an authError for the errors of invalid or missing
+// passwords, and other errors as is
+func asAuthError(err error) error {
+	switch msg := strings.TrimPrefix(err.Error(), "ERR "); msg {
+	case "invalid password", "authentication required":
+		return &authError{msg}
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAuthBeforeOutput(t *testing.T) {
+	defer func(n int) { poolOpts.MaxIdle = n }(poolOpts.MaxIdle)
+	poolOpts.MaxIdle = 1
+
+	f := newFakeTile38(t)
+	f.setPassword("secret")
+	// The old password of a rotation is tried first, and rejected
+	tg := newTestTarget(t, f, "old", "secret")
+	for i := 0; i < 2; i++ {
+		if res := tg.scrape(context.Background()); res.Err != nil {
+			t.Fatalf("scrape %d: %v", i, res.Err)
+		}
+	}
+	cmds := f.received()
+	want := []string{"AUTH", "AUTH", "OUTPUT"}
+	if len(cmds) < len(want) {
+		t.Fatalf("received %q, want %q first", cmds, want)
+	}
+	for i, cmd := range want {
+		if cmds[i] != cmd {
+			t.Fatalf("received %q, want %q first", cmds, want)
+		}
+	}
+	for _, cmd := range cmds[len(want):] {
+		if cmd == "AUTH" || cmd == "OUTPUT" {
+			t.Errorf("received %q, want the connection set up once", cmds)
+			break
+		}
+	}
+	if n := f.connections(); n != 1 {
+		t.Errorf("%d connections, want the first one reused", n)
+	}
+}
+
+func TestAuthInvalidPassword(t *testing.T) {
+	f := newFakeTile38(t)
+	f.setPassword("secret")
+	res := newTestTarget(t, f, "wrong").scrape(context.Background())
+	var aerr *authError
+	if !errors.As(res.Err, &aerr) {
+		t.Fatalf("got error %v, want an authentication error", res.Err)
+	}
+	if got := res.Err.Error(); got != "auth: invalid password" {
+		t.Errorf("got error %q", got)
+	}
+	for _, cmd := range f.received() {
+		if cmd != "AUTH" {
+			t.Errorf("received %s after the password was rejected", cmd)
+		}
+	}
+}
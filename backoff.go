@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// backoffOpts configures the cool-down of targets that keep failing to
+// connect, during which dials fail at once instead of reaching for the server
+var backoffOpts struct {
+	After int           // consecutive failures before cooling down, 0 to disable
+	Max   time.Duration // longest cool-down
+}
+
+// backoffBase is the first cool-down, doubled on every further failure
+const backoffBase = time.Second
+
+// backoff is the state of the dials of a target
+type backoff struct {
+	mu       sync.Mutex
+	failures int // consecutive failures
+	until    time.Time
+	lastErr  error
+}
+
+// check returns an error while cooling down
+func (b *backoff) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	left := time.Until(b.until)
+	if left <= 0 {
+		return nil
+	}
+	return fmt.Errorf("not connecting for %s after %d failures: %v",
+		left.Round(time.Millisecond), b.failures, b.lastErr)
+}
+
+// record records the outcome of a dial. A success ends the cool-down at
+// once, and failures past the threshold start a jittered cool-down that
+// doubles with every failure.
+func (b *backoff) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		b.until = time.Time{}
+		b.lastErr = nil
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if backoffOpts.After <= 0 || b.failures < backoffOpts.After {
+		return
+	}
+	d := backoffOpts.Max
+	if n := b.failures - backoffOpts.After; n < 30 && backoffBase<<n < d {
+		d = backoffBase << n
+	}
+	// Jitter keeps the exporters scraping a server from retrying together
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	b.until = time.Now().Add(d)
+}
+
+var (
+	dialBackoffMetric  = metric{"gauge", "tile38_exporter_dial_backoff_seconds", "Time left before connecting to the Tile38 server is attempted again, 0 when not cooling down"}
+	dialFailuresMetric = metric{"gauge", "tile38_exporter_dial_consecutive_failures", "Number of consecutive failures to connect to the Tile38 server"}
+)
+
+// families returns the dial state of the target
+func (b *backoff) families() []*family {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	left := time.Until(b.until)
+	if left < 0 {
+		left = 0
+	}
+	return []*family{
+		dialBackoffMetric.family(left.Seconds()),
+		dialFailuresMetric.family(float64(b.failures)),
+	}
+}
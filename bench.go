@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+// benchOpts configures the bench subcommand, which measures the cost of
+// collections against a Tile38 server instead of serving metrics
+var benchOpts struct {
+	Enabled     bool
+	Duration    time.Duration
+	Concurrency int
+	Collectors  string
+	Output      string
+}
+
+// benchFlags registers the options of the bench subcommand
+func benchFlags() {
+	benchOpts.Enabled = true
+	flag.DurationVar(&benchOpts.Duration, "duration", time.Minute, "duration of the benchmark")
+	flag.IntVar(&benchOpts.Concurrency, "concurrency", 1, "number of concurrent collections")
+	flag.StringVar(&benchOpts.Collectors, "collectors", "server", "comma separated collectors to run: server, collections, queries, strings")
+	flag.StringVar(&benchOpts.Output, "output", "table", "output format, table or json")
+}
+
+// benchCollectors returns the collectors with the passed names. The server
+// collectors always run, as the others depend on the SERVER stats.
+func benchCollectors(names string) ([]collector, error) {
+	cs := []collector{{"go", collectGo}, {"tile38", collectTile38}}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "server":
+		case "collections":
+			cs = append(cs, collector{"collections", collectCollections})
+		case "queries":
+			if len(currentConfig().Queries) == 0 {
+				return nil, fmt.Errorf("queries requires queries in --config")
+			}
+			cs = append(cs, collector{"queries", collectQueries})
+		case "strings":
+			if len(currentConfig().Strings) == 0 {
+				return nil, fmt.Errorf("strings requires strings in --config")
+			}
+			cs = append(cs, collector{"strings", collectStrings})
+		default:
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	return cs, nil
+}
+
+// benchConn counts the bytes received on a connection, along with the time
+// Tile38 reports having spent on the commands
+type benchConn struct {
+	redis.Conn
+	bytes   int
+	elapsed time.Duration
+}
+
+func (c *benchConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(cmd, args...)
+	c.count(reply)
+	return reply, err
+}
+
+// count adds a reply, or the replies of a pipeline, to the totals
+func (c *benchConn) count(reply interface{}) {
+	var data []byte
+	switch r := reply.(type) {
+	case []byte:
+		data = r
+	case string:
+		data = []byte(r)
+	case []interface{}:
+		for _, r := range r {
+			c.count(r)
+		}
+		return
+	}
+	c.bytes += len(data)
+	if d, err := time.ParseDuration(gjson.GetBytes(data, "elapsed").String()); err == nil {
+		c.elapsed += d
+	}
+}
+
+// benchResult is the outcome of a single collection
+type benchResult struct {
+	latency, elapsed time.Duration
+	bytes            int
+	err              error
+}
+
+// benchOnce runs a single collection of the collectors against the target
+func benchOnce(t *target, cs []collector) benchResult {
+	start := time.Now()
+	conn := &benchConn{Conn: t.Pool.Get()}
+	defer conn.Close()
+	out, err := do(conn, "SERVER", "ext")
+	if err == nil {
+		stats := gjson.Get(out, "stats").Map()
+		for _, c := range cs {
+			if _, err = c.Collect(t, conn, stats); err != nil {
+				err = fmt.Errorf("%s: %v", c.Name, err)
+				break
+			}
+		}
+	}
+	return benchResult{latency: time.Since(start), elapsed: conn.elapsed,
+		bytes: conn.bytes, err: err}
+}
+
+// benchReport summarizes a benchmark. Latencies, times and costs are per
+// collection.
+type benchReport struct {
+	Collectors    []string `json:"collectors"`
+	Duration      float64  `json:"duration_seconds"`
+	Concurrency   int      `json:"concurrency"`
+	Pipeline      bool     `json:"pipeline"`
+	Collections   int      `json:"collections"`
+	Errors        int      `json:"errors"`
+	LatencyP50    float64  `json:"latency_p50_seconds"`
+	LatencyP95    float64  `json:"latency_p95_seconds"`
+	LatencyP99    float64  `json:"latency_p99_seconds"`
+	ServerElapsed float64  `json:"server_elapsed_seconds"`
+	Bytes         float64  `json:"bytes_received"`
+	CPU           float64  `json:"cpu_seconds"`
+	AllocBytes    float64  `json:"alloc_bytes"`
+	Allocs        float64  `json:"allocs"`
+}
+
+// runBench runs collections against the target for the configured duration
+// and prints the report. The connections of the target are closed when done.
+func runBench(t *target) {
+	if benchOpts.Duration <= 0 {
+		log.Fatalf("--duration must be positive")
+	}
+	if benchOpts.Concurrency < 1 {
+		log.Fatalf("--concurrency must be at least 1")
+	}
+	if benchOpts.Output != "table" && benchOpts.Output != "json" {
+		log.Fatalf("--output must be table or json")
+	}
+	cs, err := benchCollectors(benchOpts.Collectors)
+	if err != nil {
+		log.Fatalf("--collectors: %v", err)
+	}
+	defer t.close()
+
+	var ms0, ms1 runtime.MemStats
+	runtime.ReadMemStats(&ms0)
+	cpu0 := processCPUTime()
+	start := time.Now()
+	deadline := start.Add(benchOpts.Duration)
+	var mu sync.Mutex
+	var results []benchResult
+	var lastErr error
+	var wg sync.WaitGroup
+	for i := 0; i < benchOpts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				res := benchOnce(t, cs)
+				mu.Lock()
+				results = append(results, res)
+				if res.err != nil {
+					lastErr = res.err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	cpu := processCPUTime() - cpu0
+	runtime.ReadMemStats(&ms1)
+
+	rep := benchReport{Duration: elapsed.Seconds(), Concurrency: benchOpts.Concurrency,
+		Pipeline: pipelineCommands}
+	for _, c := range cs {
+		rep.Collectors = append(rep.Collectors, c.Name)
+	}
+	var latencies []float64
+	var serverElapsed time.Duration
+	var bytes int
+	for _, res := range results {
+		if res.err != nil {
+			rep.Errors++
+			continue
+		}
+		latencies = append(latencies, res.latency.Seconds())
+		serverElapsed += res.elapsed
+		bytes += res.bytes
+	}
+	rep.Collections = len(latencies)
+	if n := float64(len(latencies)); n > 0 {
+		sort.Float64s(latencies)
+		rep.LatencyP50 = percentile(latencies, 0.50)
+		rep.LatencyP95 = percentile(latencies, 0.95)
+		rep.LatencyP99 = percentile(latencies, 0.99)
+		rep.ServerElapsed = serverElapsed.Seconds() / n
+		rep.Bytes = float64(bytes) / n
+		rep.CPU = cpu.Seconds() / n
+		rep.AllocBytes = float64(ms1.TotalAlloc-ms0.TotalAlloc) / n
+		rep.Allocs = float64(ms1.Mallocs-ms0.Mallocs) / n
+	}
+
+	if benchOpts.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+	} else {
+		printBenchReport(rep)
+	}
+	if lastErr != nil {
+		log.Printf("bench: last error: %v", lastErr)
+	}
+	if rep.Collections == 0 {
+		os.Exit(1)
+	}
+}
+
+// percentile returns the q quantile of the sorted values, using the nearest
+// rank
+func percentile(sorted []float64, q float64) float64 {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// printBenchReport prints the report as a table
+func printBenchReport(rep benchReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	ms := func(s float64) string { return fmt.Sprintf("%.3fms", s*1000) }
+	fmt.Fprintf(w, "Collectors\t%s\n", strings.Join(rep.Collectors, ", "))
+	fmt.Fprintf(w, "Duration\t%.1fs\n", rep.Duration)
+	fmt.Fprintf(w, "Concurrency\t%d\n", rep.Concurrency)
+	fmt.Fprintf(w, "Pipeline\t%t\n", rep.Pipeline)
+	fmt.Fprintf(w, "Collections\t%d (%.1f/s)\n", rep.Collections,
+		float64(rep.Collections)/rep.Duration)
+	fmt.Fprintf(w, "Errors\t%d\n", rep.Errors)
+	fmt.Fprintf(w, "Latency p50/p95/p99\t%s / %s / %s\n",
+		ms(rep.LatencyP50), ms(rep.LatencyP95), ms(rep.LatencyP99))
+	fmt.Fprintf(w, "Tile38 elapsed\t%s per collection\n", ms(rep.ServerElapsed))
+	fmt.Fprintf(w, "Bytes received\t%.0f per collection\n", rep.Bytes)
+	fmt.Fprintf(w, "Exporter CPU\t%s per collection\n", ms(rep.CPU))
+	fmt.Fprintf(w, "Exporter allocations\t%.0f bytes in %.0f allocations per collection\n",
+		rep.AllocBytes, rep.Allocs)
+	w.Flush()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// breakerOpts configures the circuit breaker of the targets, which stops
+// scraping a server whose SERVER command keeps failing
+var breakerOpts struct {
+	Failures int           // consecutive failures opening the circuit, 0 to disable
+	Cooldown time.Duration // time the circuit stays open before a probe
+}
+
+// breaker states, as exported
+const (
+	breakerClosed   = 0
+	breakerOpen     = 1
+	breakerHalfOpen = 2
+)
+
+// breaker is the circuit breaker of a target. While open, scrapes fail at
+// once without touching the pool. Once the cool-down is over, a single
+// scrape probes the server, closing the circuit on success and opening it
+// again on failure.
+type breaker struct {
+	mu       sync.Mutex
+	state    int
+	failures int // consecutive failures
+	opened   time.Time
+	lastErr  error
+	trips    float64
+}
+
+// allow returns an error when the scrape must not reach the server. The
+// first scrape after the cool-down becomes the probe of the half-open circuit.
+func (b *breaker) allow() error {
+	if breakerOpts.Failures <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.state == breakerClosed:
+		return nil
+	case b.state == breakerOpen && time.Since(b.opened) >= breakerOpts.Cooldown:
+		b.state = breakerHalfOpen
+		return nil
+	}
+	return fmt.Errorf("backend unhealthy: circuit open after %d failures: %v",
+		b.failures, b.lastErr)
+}
+
+// probing reports whether a probe of a half-open circuit is in progress
+func (b *breaker) probing() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerHalfOpen
+}
+
+// record records the outcome of a SERVER command. Failures caused by the
+// scraper going away do not count, but a probe cut short that way opens the
+// circuit again for another cool-down, or it would stay half-open and reject
+// every scrape.
+func (b *breaker) record(err error) {
+	if breakerOpts.Failures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		if b.state == breakerHalfOpen {
+			b.state = breakerOpen
+			b.opened = time.Now()
+		}
+		return
+	}
+	if err == nil {
+		b.state = breakerClosed
+		b.failures = 0
+		b.lastErr = nil
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if b.state == breakerHalfOpen || b.failures >= breakerOpts.Failures {
+		if b.state != breakerOpen {
+			b.trips++
+		}
+		b.state = breakerOpen
+		b.opened = time.Now()
+	}
+}
+
+var (
+	breakerStateMetric = metric{"gauge", "tile38_exporter_circuit_breaker_state", "State of the circuit breaker of the Tile38 server: 0 closed, 1 open, 2 half-open"}
+	breakerTripsMetric = metric{"counter", "tile38_exporter_circuit_breaker_trips_total", "Total number of times the circuit breaker of the Tile38 server opened"}
+)
+
+// families returns the state of the circuit breaker
+func (b *breaker) families() []*family {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return []*family{
+		breakerStateMetric.family(float64(b.state)),
+		breakerTripsMetric.family(b.trips),
+	}
+}
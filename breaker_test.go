@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerCancelledProbe(t *testing.T) {
+	defer func(failures int, cooldown time.Duration) {
+		breakerOpts.Failures, breakerOpts.Cooldown = failures, cooldown
+	}(breakerOpts.Failures, breakerOpts.Cooldown)
+	breakerOpts.Failures, breakerOpts.Cooldown = 1, 20*time.Millisecond
+
+	f := newFakeTile38(t)
+	tg := newTestTarget(t, f)
+	tg.cb.record(errors.New("server not ready"))
+	if err := tg.cb.allow(); err == nil {
+		t.Fatal("the circuit didn't open")
+	}
+	time.Sleep(breakerOpts.Cooldown)
+
+	// The probe stalls past the deadline of its scrape
+	f.stallServer(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	res := tg.scrape(ctx)
+	cancel()
+	if !errors.Is(res.Err, context.DeadlineExceeded) {
+		t.Fatalf("probe failed with %v, want the deadline exceeded", res.Err)
+	}
+	if tg.cb.probing() {
+		t.Fatal("the circuit stayed half-open after the probe was cancelled")
+	}
+	if err := tg.cb.allow(); err == nil {
+		t.Fatal("a scrape was let through before the cool-down")
+	}
+	if tg.cb.trips != 1 {
+		t.Errorf("%v trips, want the cancelled probe not counted", tg.cb.trips)
+	}
+
+	// After the cool-down, another probe closes the circuit
+	time.Sleep(breakerOpts.Cooldown)
+	if res := tg.scrape(context.Background()); res.Err != nil {
+		t.Fatalf("second probe: %v", res.Err)
+	}
+	if err := tg.cb.allow(); err != nil {
+		t.Errorf("the circuit didn't close after a successful probe: %v", err)
+	}
+}
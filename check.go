@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// checkMode runs the check subcommand, which lints the output of one
+// collection instead of serving metrics
+var checkMode bool
+
+// checkProblem is a finding of the check subcommand about a metric
+type checkProblem struct {
+	Metric, Text string
+}
+
+// runCheck collects the targets once and renders the output as /metrics
+// would, then prints the problems Prometheus and promtool would find with it.
+// It returns the exit status, 1 when problems are found.
+func runCheck(n string) int {
+	ctx := context.Background()
+	if scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, scrapeTimeout)
+		defer cancel()
+	}
+	snap := collect(ctx)
+	var problems []checkProblem
+	for _, res := range snap.Results {
+		if res.Err != nil {
+			problems = append(problems, checkProblem{"tile38_up",
+				fmt.Sprintf("%s could not be collected: %v", res.Target.Addr, res.Err)})
+		}
+	}
+	if err := checkStrict(snap); err != nil {
+		problems = append(problems, checkProblem{"", err.Error()})
+	}
+	sections, _ := relabel(servedSections(snap))
+	out := render(sections, n)
+	problems = append(problems, lintOutput(out)...)
+	for _, p := range problems {
+		if p.Metric == "" {
+			fmt.Println(p.Text)
+		} else {
+			fmt.Printf("%s: %s\n", p.Metric, p.Text)
+		}
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d problem(s) found in the output of %d target(s)\n", len(problems), len(snap.Results))
+		return 1
+	}
+	fmt.Printf("no problems found in the output of %d target(s)\n", len(snap.Results))
+	return 0
+}
+
+// lintOutput returns the problems of a document of the text format: those of
+// its HELP and TYPE lines, the errors of the parser of Prometheus and, once
+// parsed, the naming problems reported by promtool
+func lintOutput(out string) []checkProblem {
+	var problems []checkProblem
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, "# HELP ") && !strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		fields := strings.SplitN(line[2:], " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		if key := fields[0] + " " + fields[1]; seen[key] {
+			problems = append(problems, checkProblem{fields[1],
+				fmt.Sprintf("duplicate %s line, the family is exported more than once", fields[0])})
+		} else {
+			seen[key] = true
+		}
+		if fields[0] == "HELP" && len(fields) == 3 && !validHelpEscapes(fields[2]) {
+			problems = append(problems, checkProblem{fields[1],
+				`HELP has an invalid escape sequence, only \\ and \n are allowed`})
+		}
+	}
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(strings.NewReader(out))
+	if err != nil {
+		return append(problems, checkProblem{"", err.Error()})
+	}
+	names := make([]string, 0, len(mfs))
+	for name := range mfs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		problems = append(problems, lintFamily(mfs[name])...)
+	}
+	return problems
+}
+
+// lintFamily returns the problems promtool reports about the naming of a
+// parsed family, along with its duplicate series
+func lintFamily(mf *dto.MetricFamily) []checkProblem {
+	name := mf.GetName()
+	var problems []checkProblem
+	add := func(text string) {
+		problems = append(problems, checkProblem{name, text})
+	}
+	if mf.GetHelp() == "" {
+		add("no help text")
+	}
+	if strings.ToLower(name) != name {
+		add("metric names should be written in 'snake_case' not 'camelCase'")
+	}
+	total := strings.HasSuffix(name, "_total")
+	switch {
+	case mf.GetType() == dto.MetricType_COUNTER && !total:
+		add(`counter metrics should have "_total" suffix`)
+	case mf.GetType() != dto.MetricType_COUNTER && total:
+		add(`non-counter metrics should not have "_total" suffix`)
+	}
+	series := make(map[string]bool)
+	for _, m := range mf.GetMetric() {
+		var pairs []string
+		for _, lp := range m.GetLabel() {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+		}
+		sort.Strings(pairs)
+		key := "{" + strings.Join(pairs, ",") + "}"
+		if series[key] {
+			add("duplicate series " + key)
+		}
+		series[key] = true
+	}
+	return problems
+}
+
+// validHelpEscapes reports whether the only escape sequences of a HELP text
+// of the text format are \\ and \n
+func validHelpEscapes(help string) bool {
+	for i := 0; i < len(help); i++ {
+		if help[i] != '\\' {
+			continue
+		}
+		if i+1 == len(help) || (help[i+1] != '\\' && help[i+1] != 'n') {
+			return false
+		}
+		i++
+	}
+	return true
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// collectInterval is the interval of background collection. When zero, all
+// targets are collected on every scrape.
+var collectInterval time.Duration
+
+// metricsTimestamps stamps the samples served from the background collection
+// with the time of their collection
+var metricsTimestamps bool
+
+// snapshot is the result of collecting all targets once
+type snapshot struct {
+	Time     time.Time
+	Duration time.Duration
+	Results  []targetResult
+	Shadow   *targetResult // result of the shadow server, if any
+	Native   []*family     // native Tile38 metrics merged into the output
+
+	Background bool // taken by the background collection
+}
+
+// latest holds the most recent background snapshot
+var latest struct {
+	sync.RWMutex
+	snap *snapshot
+}
+
+// onCollect are called with every snapshot taken by the background
+// collection loop
+var onCollect []func(snap *snapshot)
+
+var snapshotAgeMetric = metric{"gauge", "tile38_exporter_snapshot_age_seconds", "Time since the served results of a target were collected"}
+
+// sections returns the merged sections of all target results
+func (snap *snapshot) sections() []section {
+	// Targets of other shards count, so that the labels of a target
+	// don't depend on the shard it's in. Discovered targets are always
+	// labeled, as their number changes.
+	addrLabel := len(snap.Results)+len(skippedTargets()) > 1 || discoveryEnabled()
+	sections := mergeSections(snap.Results, addrLabel, metricsTimestamps && snap.Background)
+	age := snapshotAgeMetric.emptyFamily()
+	for _, res := range snap.Results {
+		age.Samples = append(age.Samples, sample{Labels: res.Target.labels(addrLabel),
+			Value: time.Since(res.Time).Seconds()})
+	}
+	for i := range sections {
+		if sections[i].Name == "exporter" {
+			sections[i].Families = append(sections[i].Families, age)
+		}
+	}
+	if snap.Shadow != nil {
+		sections = append(sections, shadowSection(snap.Results[0], *snap.Shadow))
+	}
+	if len(snap.Native) > 0 {
+		sections = append(sections, section{Name: "native", Families: snap.Native})
+	}
+	return sections
+}
+
+// health tracks the outcome of recent collections
+var health struct {
+	sync.Mutex
+	LastCollect   time.Time
+	LastError     string
+	LastErrorTime time.Time
+	Warm          bool // any target was collected once
+}
+
+// collect scrapes all targets and returns the results as a snapshot. The
+// commands sent to Tile38 give up at the deadline of ctx, if any.
+func collect(ctx context.Context) *snapshot {
+	defer trackCollection()()
+	start := time.Now()
+	var results []targetResult
+	var shadowRes *targetResult
+	if shadow != nil {
+		// The shadow is scraped alongside the primary, but kept out of
+		// the results so that it never affects the primary metrics.
+		all := scrape(ctx, []*target{currentTargets()[0], shadow})
+		results, shadowRes = all[:1], &all[1]
+	} else {
+		results = scrape(ctx, currentTargets())
+	}
+	snap := &snapshot{Time: start, Duration: time.Since(start), Results: results,
+		Shadow: shadowRes, Native: nativeFamilies(results)}
+	observeCollectPhases(results)
+	recordHealth(results, start)
+	return snap
+}
+
+// recordHealth updates the health with the outcome of a collection, which
+// succeeded when all of its targets were collected
+func recordHealth(results []targetResult, at time.Time) {
+	ok := len(results) > 0
+	health.Lock()
+	health.LastCollect = at
+	for _, res := range results {
+		if res.Err != nil {
+			health.LastError = res.Target.Addr + ": " + res.Err.Error()
+			health.LastErrorTime = at
+			ok = false
+		} else {
+			health.Warm = true
+		}
+	}
+	health.Unlock()
+	heartbeat(ok)
+	notifyTransitions(results)
+}
+
+// collectLoop collects all targets on every collectInterval, keeping the
+// latest snapshot for scrapes to serve. Unless staggering is disabled, the
+// targets are refreshed at their own offset within the interval after the
+// first collection, instead of all at once.
+func collectLoop() {
+	if noStagger || shadow != nil {
+		for {
+			snap := collect(context.Background())
+			publish(snap)
+			time.Sleep(collectInterval - time.Since(snap.Time))
+		}
+	}
+	snap := collect(context.Background())
+	publish(snap)
+	running := make(map[*target]bool)
+	for _, t := range currentTargets() {
+		running[t] = true
+		go staggerLoop(t, snap.Time, false)
+	}
+	// Targets added later are collected right away, and then staggered
+	for range targetsChanged {
+		for t := range running {
+			if t.isClosed() {
+				delete(running, t)
+			}
+		}
+		for _, t := range currentTargets() {
+			if !running[t] {
+				running[t] = true
+				go staggerLoop(t, time.Now(), true)
+			}
+		}
+	}
+}
+
+// noStagger disables staggering, refreshing all targets at once
+var noStagger bool
+
+// publishMu serializes the publishing of snapshots
+var publishMu sync.Mutex
+
+// publish makes snap the latest snapshot and passes it to the onCollect
+// functions
+func publish(snap *snapshot) {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+	publishLocked(snap)
+}
+
+// publishLocked is publish for callers already holding publishMu
+func publishLocked(snap *snapshot) {
+	snap.Background = true
+	latest.Lock()
+	latest.snap = snap
+	latest.Unlock()
+	for _, fn := range onCollect {
+		fn(snap)
+	}
+}
+
+// staggerOffset returns the offset of a target's refreshes within the
+// interval, derived from a hash of its address
+func staggerOffset(addr string, interval time.Duration) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(addr))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// clock tells the time and sleeps, faked in tests of the schedules
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// realClock is the clock of the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// staggerLoop refreshes a target on every collectInterval, at its offset
+// from first, replacing its result in the latest snapshot. When now is set
+// the target is also refreshed right away. It returns once the target is
+// removed. While the target belongs to another shard, after a reload, it is
+// not refreshed.
+func staggerLoop(t *target, first time.Time, now bool) {
+	staggered(realClock{}, first, staggerOffset(t.Addr, collectInterval), collectInterval,
+		now, t.isClosed, func() {
+			if t.owned() {
+				refresh(t)
+			}
+		})
+}
+
+// staggered calls fn on every interval at offset from first, and right away
+// when now is set, until done returns true. The calls missed while fn was
+// running are skipped.
+func staggered(c clock, first time.Time, offset, interval time.Duration, now bool, done func() bool, fn func()) {
+	if now {
+		fn()
+	}
+	next := first.Add(offset)
+	if !next.After(first) {
+		next = next.Add(interval)
+	}
+	for {
+		c.Sleep(next.Sub(c.Now()))
+		if done() {
+			return
+		}
+		fn()
+		// Skip the refreshes that were missed by a slow scrape
+		for next = next.Add(interval); !next.After(c.Now()); {
+			next = next.Add(interval)
+		}
+	}
+}
+
+// refresh scrapes a single target and publishes a snapshot with its result
+// replaced
+func refresh(t *target) {
+	done := trackCollection()
+	res := t.scrape(context.Background())
+	done()
+	observeCollectPhases([]targetResult{res})
+	recordHealth([]targetResult{res}, res.Time)
+
+	// Replacing the result and publishing must not interleave with the
+	// other targets, or their results could be lost.
+	publishMu.Lock()
+	defer publishMu.Unlock()
+	latest.RLock()
+	prev := latest.snap.Results
+	latest.RUnlock()
+	results := replaceResult(prev, res)
+	publishLocked(&snapshot{Time: res.Time, Duration: time.Since(res.Time),
+		Results: results, Native: nativeFamilies(results)})
+}
+
+// replaceResult returns the results of the current targets, in order, with
+// the result of res's target replaced. Targets without a result yet are left
+// out.
+func replaceResult(prev []targetResult, res targetResult) []targetResult {
+	byTarget := make(map[*target]targetResult, len(prev)+1)
+	for _, r := range prev {
+		byTarget[r.Target] = r
+	}
+	byTarget[res.Target] = res
+	var results []targetResult
+	for _, t := range currentTargets() {
+		if r, ok := byTarget[t]; ok {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// getSnapshot returns the latest background snapshot. When background
+// collection is disabled, or has yet to complete, the targets are collected
+// immediately, within the deadline of ctx.
+func getSnapshot(ctx context.Context) *snapshot {
+	if collectInterval > 0 {
+		latest.RLock()
+		snap := latest.snap
+		latest.RUnlock()
+		if snap != nil {
+			return snap
+		}
+	}
+	return collect(ctx)
+}
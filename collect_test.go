@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useSnapshot collects the targets once into the background snapshot, which
+// the handlers serve until the end of the test
+func useSnapshot(t *testing.T, targets ...*target) {
+	useTargets(t, targets...)
+	interval := collectInterval
+	collectInterval = time.Minute
+	latest.Lock()
+	latest.snap = collect(context.Background())
+	latest.Unlock()
+	t.Cleanup(func() {
+		latest.Lock()
+		latest.snap = nil
+		latest.Unlock()
+		collectInterval = interval
+	})
+}
+
+// fakeClock is a clock whose time only moves when sleeping, or when
+// advanced by the test
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+}
+
+func TestStaggered(t *testing.T) {
+	first := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		offset time.Duration
+		now    bool
+		slow   map[int]time.Duration // time taken by the nth refresh
+		want   []time.Duration       // times of the refreshes, from first
+	}{
+		{name: "offset", offset: 3 * time.Second,
+			want: []time.Duration{3 * time.Second, 13 * time.Second, 23 * time.Second}},
+		{name: "now", offset: 3 * time.Second, now: true,
+			want: []time.Duration{0, 3 * time.Second, 13 * time.Second}},
+		{name: "zero offset",
+			want: []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second}},
+		{name: "slow refresh", offset: 3 * time.Second, slow: map[int]time.Duration{0: 25 * time.Second},
+			want: []time.Duration{3 * time.Second, 33 * time.Second, 43 * time.Second}},
+	} {
+		c := &fakeClock{now: first}
+		var got []time.Duration
+		done := func() bool { return len(got) == len(tc.want) }
+		staggered(c, first, tc.offset, 10*time.Second, tc.now, done, func() {
+			got = append(got, c.now.Sub(first))
+			c.now = c.now.Add(tc.slow[len(got)-1])
+		})
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: refreshed at %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestStaggerOffset(t *testing.T) {
+	interval := 10 * time.Second
+	for _, addr := range []string{"10.0.0.1:9851", "10.0.0.2:9851", "unix:///tmp/tile38.sock"} {
+		off := staggerOffset(addr, interval)
+		if off < 0 || off >= interval {
+			t.Errorf("%s: offset %s outside of the interval", addr, off)
+		}
+		if again := staggerOffset(addr, interval); again != off {
+			t.Errorf("%s: offset %s then %s, want it stable", addr, off, again)
+		}
+	}
+}
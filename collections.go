@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+// collectionsOpts configures the per-collection collector
+var collectionsOpts struct {
+	Enabled       bool
+	Match         string
+	Max           int
+	Bounds        bool
+	BoundsVerbose bool
+}
+
+// collectionMetrics are read from the STATS of each collection
+var collectionMetrics = []metric{
+	metric{"gauge", "in_memory_size", "Total in memory size of the collection"},
+	metric{"gauge", "num_objects", "Number of objects in the collection"},
+	metric{"gauge", "num_points", "Number of points in the collection"},
+	metric{"gauge", "num_strings", "Number of strings in the collection"},
+}
+
+var (
+	shardUnexpectedKeysMetric = metric{"gauge", "tile38_shard_unexpected_keys", "Number of collections outside of the declared key prefixes of the shard"}
+	collectionsDroppedMetric  = metric{"gauge", "tile38_collections_dropped", "Number of matching collections not exported due to the maximum"}
+	boundsAreaMetric          = metric{"gauge", "tile38_collection_bounds_area_km2", "Approximate area of the bounding box of the collection in square kilometers"}
+	boundsFailuresMetric      = metric{"counter", "tile38_collection_bounds_failures_total", "Total number of BOUNDS commands of the collection that failed"}
+)
+
+// boundsMetrics are the bounding box of each collection, with
+// --collections-bounds-verbose
+var boundsMetrics = []metric{
+	metric{"gauge", "tile38_collection_bounds_min_lat", "Minimum latitude of the collection"},
+	metric{"gauge", "tile38_collection_bounds_min_lon", "Minimum longitude of the collection"},
+	metric{"gauge", "tile38_collection_bounds_max_lat", "Maximum latitude of the collection"},
+	metric{"gauge", "tile38_collection_bounds_max_lon", "Maximum longitude of the collection"},
+}
+
+// earthRadiusKm is the mean radius of the earth in kilometers
+const earthRadiusKm = 6371.0088
+
+// collectCollections exports per-collection metrics for every collection
+// matching the configured pattern, up to the configured maximum.
+func collectCollections(t *target, conn redis.Conn, _ map[string]gjson.Result) ([]*family, error) {
+	keys, err := matchCollections(conn)
+	if err != nil {
+		return nil, err
+	}
+	var fams []*family
+	if sc, ok := currentConfig().shardOf(t.Addr); ok && len(sc.Prefixes) > 0 {
+		fams = append(fams, shardUnexpectedKeysMetric.family(float64(sc.unexpectedKeys(keys))))
+	}
+	dropped := 0
+	if collectionsOpts.Max > 0 && len(keys) > collectionsOpts.Max {
+		dropped = len(keys) - collectionsOpts.Max
+		keys = keys[:collectionsOpts.Max]
+	}
+	fams = append(fams, collectionsDroppedMetric.family(float64(dropped)))
+	if len(keys) == 0 {
+		return fams, nil
+	}
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	out, err := do(conn, "STATS", args...)
+	if err != nil {
+		return nil, err
+	}
+	stats := gjson.Get(out, "stats").Array()
+	missing := 0
+	for _, m := range collectionMetrics {
+		f := &family{Name: "tile38_collection_" + m.Key, Type: m.Type, Help: m.Desc}
+		for i, key := range keys {
+			if i >= len(stats) || !stats[i].Exists() || stats[i].Type == gjson.Null {
+				continue
+			}
+			v, ok := lookup(stats[i].Map(), m.Key)
+			if !ok && !missingAsNaN {
+				missing++
+				continue
+			}
+			f.Samples = append(f.Samples, sample{
+				Labels: []label{{"collection", key}},
+				Value:  v,
+			})
+		}
+		fams = append(fams, f)
+	}
+	fams = append(fams, missingFamily(missing))
+
+	if collectionsOpts.Bounds {
+		bfams, err := collectBounds(t, conn, keys)
+		if err != nil {
+			return nil, err
+		}
+		fams = append(fams, bfams...)
+	}
+	return fams, nil
+}
+
+// matchCollections returns the sorted collection keys that match the
+// configured pattern
+func matchCollections(conn redis.Conn) ([]string, error) {
+	out, err := do(conn, "KEYS", collectionsOpts.Match)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, key := range gjson.Get(out, "keys").Array() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// collectBounds exports the bounding box area of each collection. Collections
+// without any objects are omitted, along with those emptied or dropped since
+// they were listed. Any other error replied to the BOUNDS of a collection is
+// counted against that collection, and only a broken connection fails the
+// collector.
+func collectBounds(t *target, conn redis.Conn, keys []string) ([]*family, error) {
+	area := boundsAreaMetric.emptyFamily()
+	failures := boundsFailuresMetric.emptyFamily()
+	raw := make([]*family, len(boundsMetrics))
+	for i, m := range boundsMetrics {
+		raw[i] = m.emptyFamily()
+	}
+	cmds := make([]command, len(keys))
+	for i, key := range keys {
+		cmds[i] = command{"BOUNDS", []interface{}{key}}
+	}
+	replies := doAll(conn, cmds)
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+	for i, r := range replies {
+		key := keys[i]
+		if r.Err != nil && !isNotFound(r.Err) {
+			debugf("msg=\"bounds failed\" target=%s collection=%q err=%q", describeAddr(t.Addr), key, r.Err)
+			t.inc("bounds_failures:" + key)
+		}
+		failures.Samples = append(failures.Samples, sample{
+			Labels: []label{{"collection", key}},
+			Value:  t.counter("bounds_failures:" + key),
+		})
+		if r.Err != nil {
+			continue
+		}
+		minLat, minLon, maxLat, maxLon, ok := bbox(gjson.Get(r.Out, "bounds"))
+		if !ok {
+			continue
+		}
+		labels := []label{{"collection", key}}
+		area.Samples = append(area.Samples, sample{Labels: labels,
+			Value: bboxArea(minLat, minLon, maxLat, maxLon)})
+		for i, v := range []float64{minLat, minLon, maxLat, maxLon} {
+			raw[i].Samples = append(raw[i].Samples, sample{Labels: labels, Value: v})
+		}
+	}
+	fams := []*family{area, failures}
+	if collectionsOpts.BoundsVerbose {
+		fams = append(fams, raw...)
+	}
+	return fams, nil
+}
+
+// bbox returns the bounding box of all the positions in a GeoJSON geometry.
+// It returns false when the geometry is null or has no positions.
+func bbox(geom gjson.Result) (minLat, minLon, maxLat, maxLon float64, ok bool) {
+	minLat, minLon = math.Inf(+1), math.Inf(+1)
+	maxLat, maxLon = math.Inf(-1), math.Inf(-1)
+	var walk func(v gjson.Result)
+	walk = func(v gjson.Result) {
+		arr := v.Array()
+		if len(arr) >= 2 && arr[0].Type == gjson.Number {
+			lon, lat := arr[0].Float(), arr[1].Float()
+			minLat, maxLat = math.Min(minLat, lat), math.Max(maxLat, lat)
+			minLon, maxLon = math.Min(minLon, lon), math.Max(maxLon, lon)
+			ok = true
+			return
+		}
+		for _, v := range arr {
+			walk(v)
+		}
+	}
+	walk(geom.Get("coordinates"))
+	return
+}
+
+// bboxArea returns the area of a latitude/longitude rectangle in square
+// kilometers, treating the earth as a sphere.
+func bboxArea(minLat, minLon, maxLat, maxLon float64) float64 {
+	rad := math.Pi / 180
+	return earthRadiusKm * earthRadiusKm * (maxLon - minLon) * rad *
+		math.Abs(math.Sin(maxLat*rad)-math.Sin(minLat*rad))
+}
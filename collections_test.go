@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+// collectCollectionsOf runs the collections collector with bounds against
+// the fake server, returning the samples of each family by collection
+func collectCollectionsOf(t *testing.T, f *fakeTile38) (map[string]map[string]float64, error) {
+	t.Helper()
+	prev := collectionsOpts
+	collectionsOpts.Match, collectionsOpts.Max, collectionsOpts.Bounds = "*", 0, true
+	defer func() { collectionsOpts = prev }()
+
+	tg := newTestTarget(t, f)
+	conn := tg.Pool.Get()
+	defer conn.Close()
+	fams, err := collectCollections(tg, conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	got := make(map[string]map[string]float64)
+	for _, f := range fams {
+		for _, smp := range f.Samples {
+			if len(smp.Labels) == 0 {
+				continue
+			}
+			if got[f.Name] == nil {
+				got[f.Name] = make(map[string]float64)
+			}
+			got[f.Name][smp.Labels[0].Value] = smp.Value
+		}
+	}
+	return got, nil
+}
+
+func TestCollectBoundsNotFound(t *testing.T) {
+	f := newFakeTile38(t)
+	f.setCollection("fleet", [2]float64{-112, 33}, [2]float64{-111, 34})
+	f.setCollection("empty")
+	// Dropped between KEYS and BOUNDS
+	f.setCollection("gone", [2]float64{0, 0})
+	f.failBoundsOf("gone", "key not found")
+
+	got, err := collectCollectionsOf(t, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"fleet", "empty", "gone"} {
+		if _, ok := got["tile38_collection_num_objects"][key]; !ok {
+			t.Errorf("no num_objects of %s", key)
+		}
+	}
+	areas := got[boundsAreaMetric.Key]
+	if len(areas) != 1 || areas["fleet"] <= 0 {
+		t.Errorf("got bounds areas %v, want only fleet's", areas)
+	}
+}
+
+func TestCollectBoundsFailure(t *testing.T) {
+	f := newFakeTile38(t)
+	f.setCollection("fleet", [2]float64{-112, 33}, [2]float64{-111, 34})
+	f.setCollection("broken", [2]float64{0, 0}, [2]float64{1, 1})
+	f.setCollection("trucks", [2]float64{2, 2}, [2]float64{3, 3})
+	f.failBoundsOf("broken", "some error")
+
+	got, err := collectCollectionsOf(t, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	areas := got[boundsAreaMetric.Key]
+	if len(areas) != 2 || areas["fleet"] <= 0 || areas["trucks"] <= 0 {
+		t.Errorf("got bounds areas %v, want fleet's and trucks'", areas)
+	}
+	want := map[string]float64{"fleet": 0, "broken": 1, "trucks": 0}
+	for key, v := range want {
+		if got := got[boundsFailuresMetric.Key][key]; got != v {
+			t.Errorf("got %v bounds failures of %s, want %v", got, key, v)
+		}
+		if _, ok := got["tile38_collection_num_objects"][key]; !ok {
+			t.Errorf("no num_objects of %s", key)
+		}
+	}
+}
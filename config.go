@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// config is the optional YAML configuration file of the exporter
+type config struct {
+	Queries []queryConfig  `yaml:"queries"`
+	Strings []stringConfig `yaml:"strings"`
+	Shards  []shardConfig  `yaml:"shards"`
+	Relabel []relabelRule  `yaml:"relabel"`
+
+	// Sharding overrides --shard-index and --shard-count when set
+	Sharding *shardAssignment `yaml:"sharding"`
+
+	// Rename maps metric names, without the namespace, to the names they
+	// are exported under instead, with no namespace applied
+	Rename map[string]string `yaml:"rename"`
+
+	// Types maps metric names, without the namespace, to the types they are
+	// exported with instead
+	Types map[string]string `yaml:"types"`
+
+	// Labels are added to every exported series, see withConstLabels
+	Labels map[string]string `yaml:"labels"`
+}
+
+// configPath is the path of the configuration file, or empty for none
+var configPath string
+
+// cfg is the active configuration. It is never nil, and is replaced as a
+// whole when reloaded, so that a scrape sees a single configuration.
+var (
+	cfgMu sync.RWMutex
+	cfg   = &config{}
+)
+
+// currentConfig returns the active configuration
+func currentConfig() *config {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return cfg
+}
+
+// setConfig makes the configuration the active one
+func setConfig(c *config) {
+	cfgMu.Lock()
+	cfg = c
+	cfgMu.Unlock()
+}
+
+// loadConfig reads and validates the configuration file at path
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// validate checks the configuration for errors
+func (c *config) validate() error {
+	names := make(map[string]bool)
+	for i, q := range c.Queries {
+		if err := q.validate(); err != nil {
+			return fmt.Errorf("queries[%d]: %v", i, err)
+		}
+		if names[q.Name] {
+			return fmt.Errorf("queries[%d]: duplicate name %q", i, q.Name)
+		}
+		names[q.Name] = true
+	}
+	metrics := make(map[string]bool)
+	for i, sc := range c.Strings {
+		if err := sc.validate(); err != nil {
+			return fmt.Errorf("strings[%d]: %v", i, err)
+		}
+		if metrics[sc.Metric] {
+			return fmt.Errorf("strings[%d]: duplicate metric %q", i, sc.Metric)
+		}
+		metrics[sc.Metric] = true
+	}
+	shards := make(map[string]bool)
+	targets := make(map[string]bool)
+	for i, sc := range c.Shards {
+		if err := sc.validate(); err != nil {
+			return fmt.Errorf("shards[%d]: %v", i, err)
+		}
+		if shards[sc.Name] {
+			return fmt.Errorf("shards[%d]: duplicate name %q", i, sc.Name)
+		}
+		if targets[sc.Target] {
+			return fmt.Errorf("shards[%d]: duplicate target %q", i, sc.Target)
+		}
+		shards[sc.Name], targets[sc.Target] = true, true
+	}
+	if c.Sharding != nil {
+		if err := c.Sharding.validate(); err != nil {
+			return fmt.Errorf("sharding: %v", err)
+		}
+	}
+	for i := range c.Relabel {
+		if err := c.Relabel[i].compile(); err != nil {
+			return fmt.Errorf("relabel[%d]: %v", i, err)
+		}
+	}
+	renamed := make(map[string]string)
+	for _, name := range c.renamed() {
+		to := c.Rename[name]
+		if !metricNameRE.MatchString(to) {
+			return fmt.Errorf("rename[%s]: invalid metric name %q", name, to)
+		}
+		if other, ok := renamed[to]; ok {
+			return fmt.Errorf("rename[%s]: %s is also renamed to %q", name, other, to)
+		}
+		renamed[to] = name
+	}
+	for name := range c.Labels {
+		if err := checkLabelName(name); err != nil {
+			return fmt.Errorf("labels: %v", err)
+		}
+	}
+	for name, typ := range c.Types {
+		switch typ {
+		case "gauge", "counter", "untyped":
+		default:
+			return fmt.Errorf("types[%s]: type must be gauge, counter or untyped, not %q", name, typ)
+		}
+	}
+	return nil
+}
+
+// checkMetrics checks the renames and type overrides against the metrics
+// exported with the configuration in the namespace n. It returns an error
+// when a metric is renamed to the name of another, or when the type of a
+// metric that is not exported, or of a histogram or summary, is overridden.
+// Renames of metrics that are not exported, such as misspelled ones, are
+// warned about.
+func (c *config) checkMetrics(n string) error {
+	exported := make(map[string]string)
+	known := make(map[string]string)
+	for _, info := range c.catalog() {
+		known[info.Name] = info.Type
+		if _, ok := c.Rename[info.Name]; !ok {
+			exported[namespaced(n, info.Name)] = info.Name
+		}
+	}
+	for name := range c.Types {
+		switch known[name] {
+		case "":
+			return fmt.Errorf("types[%s]: no such metric is exported", name)
+		case "histogram", "summary":
+			return fmt.Errorf("types[%s]: the type of a %s can't be overridden", name, known[name])
+		}
+	}
+	for _, name := range c.renamed() {
+		if known[name] == "" {
+			log.Printf("level=warn msg=\"renamed metric is not exported, ignoring it\" name=%q", name)
+			continue
+		}
+		if other, ok := exported[c.Rename[name]]; ok {
+			return fmt.Errorf("rename[%s]: %q is the name of %s", name, c.Rename[name], other)
+		}
+	}
+	return nil
+}
+
+// renamed returns the sorted names of the renamed metrics
+func (c *config) renamed() []string {
+	names := make([]string, 0, len(c.Rename))
+	for name := range c.Rename {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestTypeOverrides(t *testing.T) {
+	defer func(c *config) { setConfig(c) }(currentConfig())
+	const name = "tile38_uptime_in_seconds"
+	for _, tc := range []struct {
+		override string
+		want     dto.MetricType
+	}{
+		{"", dto.MetricType_COUNTER},
+		{"gauge", dto.MetricType_GAUGE},
+		{"untyped", dto.MetricType_UNTYPED},
+	} {
+		c := &config{}
+		if tc.override != "" {
+			c.Types = map[string]string{name: tc.override}
+		}
+		setConfig(c)
+		sections := statsSections(t)
+
+		out := render(sections, "")
+		if mf := parseOutput(t, out)[name]; mf.GetType() != tc.want {
+			t.Errorf("override %q: text TYPE %s, want %s", tc.override, mf.GetType(), tc.want)
+		}
+		dec := expfmt.NewDecoder(strings.NewReader(renderProtobuf(sections, "")), expfmt.FmtProtoDelim)
+		for {
+			var mf dto.MetricFamily
+			if err := dec.Decode(&mf); err != nil {
+				t.Errorf("override %q: %s is missing from the protobuf output: %v", tc.override, name, err)
+				break
+			}
+			if mf.GetName() == name {
+				if mf.GetType() != tc.want {
+					t.Errorf("override %q: protobuf type %s, want %s", tc.override, mf.GetType(), tc.want)
+				}
+				break
+			}
+		}
+	}
+}
+
+func TestCheckTypeOverrides(t *testing.T) {
+	for _, tc := range []struct {
+		types   map[string]string
+		wantErr string
+	}{
+		{map[string]string{"tile38_uptime_in_seconds": "gauge"}, ""},
+		{map[string]string{"tile38_uptime_in_second": "gauge"}, "no such metric"},
+		{map[string]string{"tile38_exporter_phase_duration_seconds": "gauge"}, "histogram"},
+	} {
+		err := (&config{Types: tc.types}).checkMetrics("")
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%v: %v", tc.types, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%v: got error %v, want %q", tc.types, err, tc.wantErr)
+		}
+	}
+}
+
+func TestValidateTypes(t *testing.T) {
+	for typ, ok := range map[string]bool{"gauge": true, "counter": true, "untyped": true,
+		"histogram": false, "Gauge": false, "": false} {
+		err := (&config{Types: map[string]string{"tile38_uptime_in_seconds": typ}}).validate()
+		if ok != (err == nil) {
+			t.Errorf("type %q: got error %v", typ, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consulOpts configures the discovery of targets from the Consul catalog
+var consulOpts struct {
+	Addr, Service, Tag, Datacenter string
+}
+
+// consulClient waits longer than the blocking query wait time
+var consulClient = &http.Client{Timeout: 6 * time.Minute}
+
+// consulService is an entry of the catalog service endpoint
+type consulService struct {
+	Node           string
+	Address        string
+	Datacenter     string
+	ServiceAddress string
+	ServicePort    int
+	NodeMeta       map[string]string
+	ServiceMeta    map[string]string
+}
+
+// watchConsul keeps the targets in sync with the Consul catalog using
+// blocking queries. Errors are retried, keeping the current targets.
+func watchConsul() {
+	var index uint64
+	for {
+		start := time.Now()
+		found, next, err := consulServices(index)
+		if err != nil {
+			log.Printf("consul: %v", err)
+			sdErrors.Inc("consul")
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		// The index must be reset when it goes backwards
+		if next < index {
+			index = 0
+		} else {
+			index = next
+		}
+		updateDiscovered("consul", found)
+		// Don't spin when the blocking query returns right away
+		time.Sleep(time.Second - time.Since(start))
+	}
+}
+
+// consulServices returns the instances of the service along with the index
+// to pass to the next blocking query
+func consulServices(index uint64) ([]discovered, uint64, error) {
+	base := consulOpts.Addr
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	q := url.Values{}
+	q.Set("index", strconv.FormatUint(index, 10))
+	q.Set("wait", "5m")
+	if consulOpts.Tag != "" {
+		q.Set("tag", consulOpts.Tag)
+	}
+	if consulOpts.Datacenter != "" {
+		q.Set("dc", consulOpts.Datacenter)
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(base, "/")+
+		"/v1/catalog/service/"+url.PathEscape(consulOpts.Service)+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := consulClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, 0, fmt.Errorf("catalog: %s", resp.Status)
+	}
+	var services []consulService
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return nil, 0, fmt.Errorf("catalog: %v", err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	var found []discovered
+	for _, s := range services {
+		host := s.ServiceAddress
+		if host == "" {
+			host = s.Address
+		}
+		d := discovered{Addr: net.JoinHostPort(host, strconv.Itoa(s.ServicePort)),
+			Labels: []label{{"node", s.Node}, {"datacenter", s.Datacenter}}}
+		d.Labels = append(d.Labels, metaLabels("node_meta_", s.NodeMeta)...)
+		d.Labels = append(d.Labels, metaLabels("service_meta_", s.ServiceMeta)...)
+		found = append(found, d)
+	}
+	return found, next, nil
+}
+
+// metaLabels returns the metadata as labels, sorted by key
+func metaLabels(prefix string, meta map[string]string) []label {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	labels := make([]label, 0, len(keys))
+	for _, k := range keys {
+		labels = append(labels, label{labelName(prefix, k), meta[k]})
+	}
+	return labels
+}
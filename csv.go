@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleCSV serves the same snapshot as /metrics as CSV, with a row for every
+// sample. The addr label is a column of its own, the other labels are a JSON
+// object.
+func handleCSV(w http.ResponseWriter, r *http.Request, n string) {
+	snap := getSnapshot(r.Context())
+	var errs []string
+	for _, res := range snap.Results {
+		if res.Err != nil {
+			errs = append(errs, res.Err.Error())
+		}
+	}
+	if len(snap.Results) > 0 && len(errs) == len(snap.Results) {
+		http.Error(w, strings.Join(errs, "\n"), 500)
+		return
+	}
+	if err := checkStrict(snap); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	cw.Write([]string{"timestamp", "addr", "metric", "labels", "value"})
+	ts := snap.Time.UTC().Format(time.RFC3339)
+	sections, _ := relabel(servedSections(snap))
+	for _, row := range csvRows(sections, n) {
+		cw.Write(append([]string{ts}, row...))
+	}
+	cw.Flush()
+}
+
+// csvRows returns the addr, metric, labels and value of every sample, in
+// the order of the text format. Families exported under the same name are
+// grouped as in the text format, so that no series is repeated.
+func csvRows(sections []section, n string) [][]string {
+	var rows [][]string
+	for _, s := range groupFamilies(sections, n) {
+		fams := append([]*family(nil), s.Families...)
+		sort.SliceStable(fams, func(i, j int) bool {
+			return fams[i].Name < fams[j].Name
+		})
+		for _, f := range fams {
+			name := finalName(n, f.Name)
+			samples := append([]sample(nil), f.Samples...)
+			sort.SliceStable(samples, func(i, j int) bool {
+				return sampleLess(samples[i], samples[j])
+			})
+			for _, smp := range samples {
+				labels, addr := withoutLabel(smp.Labels, "addr")
+				obj := make(map[string]string, len(labels))
+				for _, l := range labels {
+					obj[l.Name] = l.Value
+				}
+				data, _ := json.Marshal(obj)
+				rows = append(rows, []string{addr, name + smp.Suffix, string(data),
+					strconv.FormatFloat(smp.Value, 'f', -1, 64)})
+			}
+		}
+	}
+	return rows
+}
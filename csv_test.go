@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCSVRowsGrouped(t *testing.T) {
+	// A relabel rule renamed series of the tile38 section into a family of
+	// the go section, one of them already present there
+	sections := []section{
+		{Name: "go", Families: []*family{
+			{Name: "heap_alloc_bytes", Type: "gauge", Samples: []sample{
+				{Labels: []label{{"addr", "10.0.0.1:9851"}}, Value: 1024},
+			}},
+		}},
+		{Name: "tile38", Families: []*family{
+			{Name: "heap_alloc_bytes", Type: "gauge", Samples: []sample{
+				{Labels: []label{{"addr", "10.0.0.2:9851"}}, Value: 2048},
+				{Labels: []label{{"addr", "10.0.0.1:9851"}}, Value: 4096},
+			}},
+		}},
+	}
+	want := [][]string{
+		{"10.0.0.1:9851", "tile38_heap_alloc_bytes", "{}", "1024"},
+		{"10.0.0.2:9851", "tile38_heap_alloc_bytes", "{}", "2048"},
+	}
+	if got := csvRows(sections, "tile38"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows\n%q\nwant\n%q", got, want)
+	}
+}
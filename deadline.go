@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// scrapeTimeout is the time a scrape may take when Prometheus does not send
+// its scrape timeout, or 0 for no limit
+var scrapeTimeout time.Duration
+
+// scrapeTimeoutOffset is subtracted from the scrape timeout of Prometheus,
+// leaving time to reply before Prometheus gives up
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// requestTimeout returns the time the scrape of the request may take, or 0
+// for no limit
+func requestTimeout(r *http.Request) time.Duration {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+		d := time.Duration(secs * float64(time.Second))
+		if d > 2*scrapeTimeoutOffset {
+			d -= scrapeTimeoutOffset
+		}
+		return capToWriteTimeout(d)
+	}
+	return capToWriteTimeout(scrapeTimeout)
+}
+
+// capToWriteTimeout shortens the scrape timeout so that the reply is written
+// before the write timeout of the http server
+func capToWriteTimeout(d time.Duration) time.Duration {
+	if webTimeouts.Write <= 0 {
+		return d
+	}
+	max := webTimeouts.Write - scrapeTimeoutOffset
+	if d == 0 || d > max {
+		return max
+	}
+	return d
+}
+
+// ctxConn is a pooled connection whose commands give up at the deadline of a
+// context, or after the read timeout when that comes first, and are aborted
+// when the context is cancelled
+type ctxConn struct {
+	redis.Conn
+	ctx context.Context
+}
+
+// withContext returns the connection bound to ctx, or the connection itself
+// when ctx can never be done
+func withContext(ctx context.Context, conn redis.Conn) redis.Conn {
+	if ctx.Done() == nil {
+		return conn
+	}
+	return ctxConn{conn, ctx}
+}
+
+func (c ctxConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	// The context travels through the pool as the first argument, to the
+	// abortConn that dialed the connection
+	args = append([]interface{}{cancelOn{c.ctx}}, args...)
+	deadline, ok := c.ctx.Deadline()
+	if !ok {
+		return c.Conn.Do(cmd, args...)
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	if timeouts.ReadWrite > 0 && timeouts.ReadWrite < d {
+		d = timeouts.ReadWrite
+	}
+	reply, err := redis.DoWithTimeout(c.Conn, d, cmd, args...)
+	// The read timeout may expire just before the context is done
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() && !time.Now().Before(deadline) {
+		err = context.DeadlineExceeded
+	}
+	return reply, err
+}
+
+// cancelOn is the context of a command, passed from a ctxConn to an
+// abortConn
+type cancelOn struct{ ctx context.Context }
+
+// abortConn is a connection of a target that closes its network connection
+// when the context of a command is cancelled, so that the pool discards it
+// and the command returns at once
+type abortConn struct {
+	redis.Conn
+	nc      net.Conn
+	created time.Time
+}
+
+func (c abortConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.DoWithTimeout(-1, cmd, args...)
+}
+
+// DoWithTimeout runs the command with the read timeout, or with the read
+// timeout of the connection when negative
+func (c abortConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	do := func() (interface{}, error) {
+		if timeout < 0 {
+			return c.Conn.Do(cmd, args...)
+		}
+		return redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+	}
+	if len(args) == 0 {
+		return do()
+	}
+	co, ok := args[0].(cancelOn)
+	if !ok {
+		return do()
+	}
+	args = args[1:]
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-co.ctx.Done():
+			c.nc.Close()
+		case <-done:
+		}
+	}()
+	reply, err := do()
+	if err != nil && co.ctx.Err() != nil {
+		err = co.ctx.Err()
+	}
+	return reply, err
+}
+
+// ReceiveWithTimeout receives a reply with the read timeout, or with the read
+// timeout of the connection when negative. Along with DoWithTimeout, it lets
+// the pool apply the deadlines of scrapes.
+func (c abortConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	if timeout < 0 {
+		return c.Conn.Receive()
+	}
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
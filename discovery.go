@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"time"
+)
+
+// discovered is a Tile38 server found by service discovery
+type discovered struct {
+	Addr   string
+	Labels []label
+}
+
+// discoveryCreds are the credentials of discovered targets
+var discoveryCreds *credentials
+
+// discoveredTargets are all discovered targets, including the ones of other
+// shards
+var discoveredTargets []*target
+
+var (
+	sdLastSuccess = newSelfMetric("gauge", "tile38_exporter_sd_last_success_timestamp_seconds",
+		"Time of the last successful target discovery", "mechanism")
+	sdTargets = newSelfMetric("gauge", "tile38_exporter_sd_targets",
+		"Number of discovered targets", "mechanism")
+	sdErrors = newSelfMetric("counter", "tile38_exporter_sd_errors_total",
+		"Number of failed target discoveries", "mechanism")
+)
+
+// discoveryEnabled returns true when the targets are maintained by service
+// discovery
+func discoveryEnabled() bool {
+	return consulOpts.Addr != "" || kubernetesOpts.Enabled
+}
+
+// updateDiscovered replaces the targets with the discovered ones. Targets
+// that are still present keep their connection pool and counters.
+func updateDiscovered(mechanism string, found []discovered) {
+	prev := make(map[string]*target, len(discoveredTargets))
+	for _, t := range discoveredTargets {
+		prev[t.Addr] = t
+	}
+	var next []*target
+	var added int
+	for _, d := range found {
+		if t, ok := prev[d.Addr]; ok && labelsString(t.Labels) == labelsString(d.Labels) {
+			next = append(next, t)
+			delete(prev, d.Addr)
+			continue
+		}
+		t := newTarget(d.Addr, discoveryCreds)
+		t.Labels = append(t.Labels, d.Labels...)
+		next = append(next, t)
+		added++
+	}
+	discoveredTargets = next
+	setTargets(next)
+	for _, t := range prev {
+		t.close()
+	}
+	if added > 0 || len(prev) > 0 {
+		log.Printf("%s: %d targets, %d added, %d removed", mechanism, len(next),
+			added, len(prev))
+	}
+	sdLastSuccess.Set(float64(time.Now().UnixNano())/1e9, mechanism)
+	sdTargets.Set(float64(len(next)), mechanism)
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// labelName turns a discovery metadata key into a valid label name
+func labelName(prefix, key string) string {
+	return prefix + invalidLabelChars.ReplaceAllString(key, "_")
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTile38 is an in-process Tile38 server speaking RESP, replying in JSON
+// once a connection sent OUTPUT json, as Tile38 does
+type fakeTile38 struct {
+	Addr string
+	ln   net.Listener
+
+	mu       sync.Mutex
+	password string                 // required by AUTH when set
+	stats    map[string]interface{} // reply of SERVER
+	repl     map[string]interface{} // replication stats, only in plain SERVER
+	strs     map[string]string      // string objects, by key/id
+	commands []string               // commands received, upper case
+	conns    map[net.Conn]bool
+	accepted int
+	stall    int           // SERVER commands stalling their connection
+	delay    time.Duration // before replying to SERVER
+
+	collections map[string][][2]float64 // points of each collection, lon/lat
+	failBounds  map[string]string       // errors replied to BOUNDS, by key
+}
+
+// newFakeTile38 starts a fake server, stopped at the end of the test
+func newFakeTile38(t *testing.T) *fakeTile38 {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeTile38{Addr: ln.Addr().String(), ln: ln,
+		stats: map[string]interface{}{
+			"tile38_connected_clients": 1,
+			"tile38_num_points":        3,
+			"tile38_num_collections":   2,
+			"heap_alloc_bytes":         1000,
+			"sys_bytes":                3000,
+			"go_goroutines":            12,
+		},
+		repl:        make(map[string]interface{}),
+		strs:        make(map[string]string),
+		conns:       make(map[net.Conn]bool),
+		collections: make(map[string][][2]float64),
+		failBounds:  make(map[string]string)}
+	go f.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		f.dropConns()
+	})
+	return f
+}
+
+func (f *fakeTile38) serve() {
+	for {
+		c, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns[c] = true
+		f.accepted++
+		f.mu.Unlock()
+		go f.handle(c)
+	}
+}
+
+// setStat sets a stat of the SERVER reply, or removes it when v is nil
+func (f *fakeTile38) setStat(name string, v interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v == nil {
+		delete(f.stats, name)
+		return
+	}
+	f.stats[name] = v
+}
+
+// setPassword requires AUTH with the password before any other command
+func (f *fakeTile38) setPassword(password string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.password = password
+}
+
+// setString sets a string object, or removes it when v is empty
+func (f *fakeTile38) setString(key, id, v string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v == "" {
+		delete(f.strs, key+"/"+id)
+		return
+	}
+	f.strs[key+"/"+id] = v
+}
+
+// setCollection sets a collection of points, each a lon/lat pair
+func (f *fakeTile38) setCollection(key string, points ...[2]float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.collections[key] = points
+}
+
+// failBoundsOf replies msg as an error to BOUNDS of the key, even when the
+// collection is listed by KEYS
+func (f *fakeTile38) failBoundsOf(key, msg string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failBounds[key] = msg
+}
+
+// received returns the commands received so far
+func (f *fakeTile38) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+// connections returns the number of connections accepted so far
+func (f *fakeTile38) connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.accepted
+}
+
+// open returns the number of connections not yet closed
+func (f *fakeTile38) open() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.conns)
+}
+
+// stallServer leaves the next n SERVER commands, and the commands sent after
+// them on the same connection, unanswered, as a stalled server would
+func (f *fakeTile38) stallServer(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stall = n
+}
+
+// follow makes the server a follower of leader, reporting the replication
+// fields in the reply of plain SERVER only, as Tile38 does
+func (f *fakeTile38) follow(leader string, caughtUp bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.repl = map[string]interface{}{"following": leader, "caught_up": caughtUp,
+		"caught_up_once": caughtUp}
+}
+
+// delayServer delays the replies to SERVER by d, as a slow server would
+func (f *fakeTile38) delayServer(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delay = d
+}
+
+// dropConns closes all open connections, as a restarted server would
+func (f *fakeTile38) dropConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.conns {
+		c.Close()
+		delete(f.conns, c)
+	}
+}
+
+func (f *fakeTile38) handle(c net.Conn) {
+	defer func() {
+		c.Close()
+		f.mu.Lock()
+		delete(f.conns, c)
+		f.mu.Unlock()
+	}()
+	r := bufio.NewReader(c)
+	f.mu.Lock()
+	authed := f.password == ""
+	f.mu.Unlock()
+	jsonMode, stalled := false, false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if stalled {
+			continue
+		}
+		cmd := strings.ToUpper(args[0])
+		f.mu.Lock()
+		f.commands = append(f.commands, cmd)
+		f.mu.Unlock()
+		reply := func(v map[string]interface{}) {
+			v["ok"] = true
+			data, _ := json.Marshal(v)
+			fmt.Fprintf(c, "$%d\r\n%s\r\n", len(data), data)
+		}
+		fail := func(msg string) {
+			if !jsonMode {
+				fmt.Fprintf(c, "-ERR %s\r\n", msg)
+				return
+			}
+			data, _ := json.Marshal(map[string]interface{}{"ok": false, "err": msg})
+			fmt.Fprintf(c, "$%d\r\n%s\r\n", len(data), data)
+		}
+		switch {
+		case cmd == "AUTH":
+			f.mu.Lock()
+			ok := len(args) > 1 && args[1] == f.password
+			f.mu.Unlock()
+			if !ok {
+				fail("invalid password")
+				continue
+			}
+			authed = true
+			if jsonMode {
+				reply(map[string]interface{}{})
+			} else {
+				io.WriteString(c, "+OK\r\n")
+			}
+		case !authed:
+			fail("authentication required")
+		case cmd == "OUTPUT":
+			jsonMode = len(args) > 1 && strings.EqualFold(args[1], "json")
+			reply(map[string]interface{}{})
+		case cmd == "PING":
+			reply(map[string]interface{}{"ping": "pong"})
+		case cmd == "SERVER":
+			f.mu.Lock()
+			if f.stall > 0 {
+				f.stall--
+				f.mu.Unlock()
+				stalled = true
+				continue
+			}
+			delay := f.delay
+			stats := make(map[string]interface{}, len(f.stats))
+			for k, v := range f.stats {
+				stats[k] = v
+			}
+			if len(args) == 1 {
+				for k, v := range f.repl {
+					stats[k] = v
+				}
+			}
+			f.mu.Unlock()
+			time.Sleep(delay)
+			reply(map[string]interface{}{"stats": stats})
+		case cmd == "GET" && len(args) > 2:
+			f.mu.Lock()
+			v, ok := f.strs[args[1]+"/"+args[2]]
+			f.mu.Unlock()
+			if !ok {
+				fail("id not found")
+				continue
+			}
+			reply(map[string]interface{}{"object": v})
+		case cmd == "KEYS" && len(args) > 1:
+			f.mu.Lock()
+			keys := []string{}
+			for key := range f.collections {
+				if ok, _ := path.Match(args[1], key); ok {
+					keys = append(keys, key)
+				}
+			}
+			f.mu.Unlock()
+			sort.Strings(keys)
+			reply(map[string]interface{}{"keys": keys})
+		case cmd == "STATS":
+			f.mu.Lock()
+			stats := make([]interface{}, len(args)-1)
+			for i, key := range args[1:] {
+				if points, ok := f.collections[key]; ok {
+					stats[i] = map[string]interface{}{"in_memory_size": 100 * len(points),
+						"num_objects": len(points), "num_points": len(points), "num_strings": 0}
+				}
+			}
+			f.mu.Unlock()
+			reply(map[string]interface{}{"stats": stats})
+		case cmd == "BOUNDS" && len(args) > 1:
+			f.mu.Lock()
+			msg, failed := f.failBounds[args[1]]
+			points, ok := f.collections[args[1]]
+			f.mu.Unlock()
+			switch {
+			case failed:
+				fail(msg)
+			case !ok || len(points) == 0:
+				fail("key not found")
+			default:
+				min, max := points[0], points[0]
+				for _, p := range points {
+					min = [2]float64{math.Min(min[0], p[0]), math.Min(min[1], p[1])}
+					max = [2]float64{math.Max(max[0], p[0]), math.Max(max[1], p[1])}
+				}
+				reply(map[string]interface{}{"bounds": map[string]interface{}{"type": "Polygon",
+					"coordinates": [][][2]float64{{min, {max[0], min[1]}, max, {min[0], max[1]}, min}}}})
+			}
+		default:
+			fail(fmt.Sprintf("unknown command '%s'", strings.ToLower(cmd)))
+		}
+	}
+}
+
+// readCommand reads a RESP array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// newTestTarget returns a target of the fake server, closed at the end of the
+// test
+func newTestTarget(t *testing.T, f *fakeTile38, passwords ...string) *target {
+	creds, err := newCredentials(passwords, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg := newTarget(f.Addr, creds)
+	t.Cleanup(tg.close)
+	return tg
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// scrapeConfig is the part of a Prometheus scrape_config that is generated
+type scrapeConfig struct {
+	JobName        string         `yaml:"job_name"`
+	ScrapeInterval string         `yaml:"scrape_interval,omitempty"`
+	MetricsPath    string         `yaml:"metrics_path"`
+	Scheme         string         `yaml:"scheme"`
+	StaticConfigs  []staticConfig `yaml:"static_configs"`
+}
+
+// staticConfig is a static list of targets of a scrape config
+type staticConfig struct {
+	Targets []string `yaml:"targets"`
+}
+
+// alertRule is a Prometheus alerting rule
+type alertRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// generateConfig handles "tile38-prometheus generate-config [options]". It
+// prints a scrape config for the exporters at the passed targets and starter
+// alerting rules. The exporter options are accepted too, so that the output
+// matches the way the exporter runs.
+func generateConfig(args []string) {
+	fs := flag.NewFlagSet("generate-config", flag.ExitOnError)
+	jobName := fs.String("job-name", "tile38", "job name of the scrape config")
+	targets := fs.String("targets", "", "comma separated addresses of the exporters, defaults to --http-addr")
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Parse(args)
+
+	addrs := strings.Split(*targets, ",")
+	if *targets == "" {
+		// Prometheus scrapes the first host:port, it can't scrape unix sockets
+		addr := ""
+		for _, a := range parseAddrs(flag.Lookup("http-addr").Value.String()) {
+			if !strings.HasPrefix(a, "unix://") {
+				addr = a
+				break
+			}
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "generate-config: --http-addr: %v\n", err)
+			os.Exit(1)
+		}
+		if host == "" {
+			host = "localhost"
+		}
+		addrs = []string{net.JoinHostPort(host, port)}
+	}
+
+	routePrefix = normalizeRoutePrefix(routePrefix)
+	if err := applyWebConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "generate-config: --web.config.file: %v\n", err)
+		os.Exit(1)
+	}
+	sc := scrapeConfig{JobName: *jobName, MetricsPath: route(telemetryPath), Scheme: "http"}
+	if webTLSEnabled() {
+		sc.Scheme = "https"
+	}
+	if collectInterval > 0 {
+		// Scraping more often than the background collection only returns
+		// the same values again
+		sc.ScrapeInterval = collectInterval.String()
+	}
+	sc.StaticConfigs = []staticConfig{{Targets: addrs}}
+	if err := checkNamingMode(); err != nil {
+		fmt.Fprintf(os.Stderr, "generate-config: %v\n", err)
+		os.Exit(1)
+	}
+	n := flag.Lookup("namespace").Value.String()
+	if err := checkNamespace(n); err != nil {
+		fmt.Fprintf(os.Stderr, "generate-config: %v\n", err)
+		os.Exit(1)
+	}
+	// Renames of the configuration apply to the names in the rules
+	if configPath != "" {
+		c, err := loadConfig(configPath)
+		if err == nil {
+			err = c.checkMetrics(n)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "generate-config: config: %v\n", err)
+			os.Exit(1)
+		}
+		setConfig(c)
+	}
+	rules := alertRules(*jobName, n)
+
+	scrape, err := yaml.Marshal(map[string][]scrapeConfig{"scrape_configs": {sc}})
+	if err != nil {
+		panic(err)
+	}
+	groups, err := yaml.Marshal(map[string]interface{}{"groups": []interface{}{
+		map[string]interface{}{"name": *jobName, "rules": rules}}})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("# Scrape config, for prometheus.yml\n%s\n", scrape)
+	fmt.Printf("# Alerting rules, for a rule file\n%s", groups)
+}
+
+// alertRules returns the starter alerting rules. The metric names are taken
+// from the metric tables of the exporter, in its naming mode and with the
+// renames of the configuration, so that they always match its output.
+func alertRules(job, n string) []alertRule {
+	name := func(m metric) string {
+		return finalName(n, exportName(m.Key))
+	}
+	heap := name(tableMetric(goMetrics, "heap_alloc_bytes"))
+	maxHeap := name(tableMetric(tile38Metrics, "tile38_max_heap_size"))
+	rewrite := name(tableMetric(tile38Metrics, "tile38_aof_current_rewrite_time_sec"))
+	selector := fmt.Sprintf(`{job=%q}`, job)
+	return []alertRule{{
+		Alert: "Tile38Down",
+		Expr:  name(upMetric) + selector + " == 0",
+		For:   "1m", Labels: map[string]string{"severity": "critical"},
+		Annotations: map[string]string{"summary": "Tile38 {{ $labels.instance }} cannot be scraped"},
+	}, {
+		Alert: "Tile38HeapNearLimit",
+		Expr:  heap + selector + " / " + maxHeap + selector + " > 0.9 and " + maxHeap + selector + " > 0",
+		For:   "10m", Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Tile38 {{ $labels.instance }} uses over 90% of its maximum heap size"},
+	}, {
+		Alert: "Tile38FollowerNotCaughtUp",
+		Expr:  name(caughtUpMetric) + selector + " == 0",
+		For:   "15m", Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Tile38 follower {{ $labels.instance }} has not caught up with its leader"},
+	}, {
+		Alert: "Tile38AOFRewriteStuck",
+		Expr:  rewrite + selector + " > 1800",
+		For:   "5m", Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Tile38 {{ $labels.instance }} has been rewriting its AOF for over 30 minutes"},
+	}}
+}
+
+// tableMetric returns the metric of the table with the passed key. It panics
+// when the metric is missing, which means the rules are out of date.
+func tableMetric(table []metric, key string) metric {
+	for _, m := range table {
+		if m.Key == key {
+			return m
+		}
+	}
+	panic("no metric " + key)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlertRulesNames(t *testing.T) {
+	defer func(c *config) { setConfig(c) }(currentConfig())
+	for _, tc := range []struct {
+		n, mode string
+		rename  map[string]string
+		want    []string // in the expressions of the rules
+	}{
+		{"", "legacy", nil,
+			[]string{"tile38_up{", "heap_alloc_bytes{", "tile38_max_heap_size{", "tile38_aof_current_rewrite_time_sec{"}},
+		{"tile38", "prometheus", nil,
+			[]string{"tile38_up{", "tile38_heap_alloc_bytes{", "tile38_max_heap_size_bytes{", "tile38_aof_current_rewrite_time_seconds{"}},
+		{"app", "legacy", map[string]string{"tile38_up": "tile38_reachable", "heap_alloc_bytes": "tile38_heap_bytes"},
+			[]string{"tile38_reachable{", "tile38_heap_bytes{", "app_tile38_max_heap_size{"}},
+	} {
+		setNaming(t, tc.mode, false)
+		setConfig(&config{Rename: tc.rename})
+		var exprs []string
+		for _, r := range alertRules("tile38", tc.n) {
+			exprs = append(exprs, r.Expr)
+		}
+		all := strings.Join(exprs, "\n")
+		for _, name := range tc.want {
+			if !strings.Contains(all, name) {
+				t.Errorf("namespace %q, %s mode, renames %v: no %s in\n%s", tc.n, tc.mode, tc.rename, name, all)
+			}
+		}
+		if tc.rename != nil && strings.Contains(all, "app_tile38_up") {
+			t.Errorf("the renamed tile38_up is in the namespace:\n%s", all)
+		}
+	}
+}
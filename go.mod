@@ -1,8 +1,16 @@
 module github.com/tile38/tile38-prometheus-sidekick
 
-go 1.14
+go 1.16
 
 require (
 	github.com/gomodule/redigo v2.0.0+incompatible
+	github.com/kardianos/service v1.2.2
+	github.com/prometheus/client_model v0.4.0
+	github.com/prometheus/common v0.44.0
 	github.com/tidwall/gjson v1.6.0
+	golang.org/x/crypto v0.11.0
+	golang.org/x/net v0.12.0
+	golang.org/x/sys v0.10.0
+	golang.org/x/term v0.10.0
+	gopkg.in/yaml.v2 v2.4.0
 )
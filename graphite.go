@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// graphiteOpts configures pushing the metrics to Graphite
+var graphiteOpts struct {
+	Addr     string        // plaintext protocol listener of carbon, empty to disable
+	Interval time.Duration // interval of pushes
+}
+
+// graphiteRetryBase is the delay before retrying a failed push, doubled on
+// every further failure
+const graphiteRetryBase = time.Second
+
+var (
+	graphitePushes = newSelfMetric("counter", "tile38_exporter_graphite_pushes_total",
+		"Number of pushes of the metrics to Graphite")
+	graphiteErrors = newSelfMetric("counter", "tile38_exporter_graphite_errors_total",
+		"Number of failed pushes of the metrics to Graphite, including retries")
+)
+
+// graphiteLoop pushes the metrics to Graphite on every interval. Failed
+// pushes are retried with a doubling delay until the next one is due, which
+// replaces them.
+func graphiteLoop(n string) {
+	for next := time.Now(); ; {
+		time.Sleep(time.Until(next))
+		next = next.Add(graphiteOpts.Interval)
+		ctx, cancel := context.WithDeadline(context.Background(), next)
+		snap := getSnapshot(ctx)
+		cancel()
+		sections, _ := relabel(withSelfMetrics(snap.sections()))
+		data := []byte(renderGraphite(sections, n, snap.Time))
+		for delay := graphiteRetryBase; ; delay *= 2 {
+			err := pushGraphite(data)
+			if err == nil {
+				graphitePushes.Inc()
+				break
+			}
+			graphiteErrors.Inc()
+			if time.Now().Add(delay).After(next) {
+				log.Printf("level=warn msg=\"graphite push failed, giving up until the next one\" addr=%s err=%q",
+					graphiteOpts.Addr, err)
+				break
+			}
+			debugf("msg=\"graphite push failed, retrying\" delay=%s err=%q", delay, err)
+			time.Sleep(delay)
+		}
+	}
+}
+
+// pushGraphite sends a document to Graphite over a new connection
+func pushGraphite(data []byte) error {
+	conn, err := net.DialTimeout("tcp", graphiteOpts.Addr, timeouts.Connect)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(timeouts.ReadWrite))
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// renderGraphite produces Graphite plaintext from the passed sections. Each
+// series is a path of the namespace, or tile38 without one, the metric
+// without the namespace, and the values of its labels sorted by label name,
+// joined by dots. Characters other than letters, digits, _ and - are replaced
+// by _, and empty label values are left out. Lines are stamped with the
+// timestamp of their samples, or t, in seconds. NaN and infinite values,
+// which carbon would refuse, are left out.
+func renderGraphite(sections []section, n string, t time.Time) string {
+	prefix := n
+	if prefix == "" {
+		prefix = "tile38"
+	}
+	prefix = graphiteNode(prefix)
+	var sb strings.Builder
+	for _, s := range groupFamilies(sections, n) {
+		fams := append([]*family(nil), s.Families...)
+		sort.SliceStable(fams, func(i, j int) bool {
+			return fams[i].Name < fams[j].Name
+		})
+		for _, f := range fams {
+			name := strings.TrimPrefix(finalName(n, f.Name), n+"_")
+			samples := append([]sample(nil), f.Samples...)
+			sort.SliceStable(samples, func(i, j int) bool {
+				return sampleLess(samples[i], samples[j])
+			})
+			for _, smp := range samples {
+				if math.IsNaN(smp.Value) || math.IsInf(smp.Value, 0) {
+					continue
+				}
+				ts := t.Unix()
+				if smp.Timestamp != 0 {
+					ts = smp.Timestamp / 1000
+				}
+				sb.WriteString(prefix)
+				sb.WriteByte('.')
+				sb.WriteString(graphiteNode(name + smp.Suffix))
+				sb.WriteString(graphiteLabels(smp.Labels))
+				sb.WriteByte(' ')
+				sb.WriteString(strconv.FormatFloat(smp.Value, 'f', -1, 64))
+				sb.WriteByte(' ')
+				sb.WriteString(strconv.FormatInt(ts, 10))
+				sb.WriteByte('\n')
+			}
+		}
+	}
+	return sb.String()
+}
+
+// graphiteLabels returns the values of the labels sorted by label name, each
+// preceded by a dot
+func graphiteLabels(labels []label) string {
+	sorted := append([]label(nil), labels...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	var sb strings.Builder
+	for _, l := range sorted {
+		if l.Value == "" {
+			continue
+		}
+		sb.WriteByte('.')
+		sb.WriteString(graphiteNode(l.Value))
+	}
+	return sb.String()
+}
+
+// graphiteNode replaces the characters of s that can't be part of a node of
+// a Graphite path
+func graphiteNode(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
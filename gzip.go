@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipMinSize is the size under which bodies are sent uncompressed, as the
+// gzip header and a round of compression would cost more than they save
+const gzipMinSize = 1024
+
+// gzipWriters are reused across scrapes, a gzip.Writer allocating several
+// hundred kilobytes
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// gzipBuffers hold the compressed bodies
+var gzipBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// acceptsGzip reports whether the client advertises gzip in Accept-Encoding,
+// with a non-zero quality
+func acceptsGzip(r *http.Request) bool {
+	for _, h := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(h, ",") {
+			params := strings.Split(part, ";")
+			coding := strings.TrimSpace(params[0])
+			if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+				continue
+			}
+			if qValue(params[1:]) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeBody writes a successful response, compressed with gzip when the
+// client accepts it and the body is large enough. The length is set for HEAD
+// requests too, whose body is discarded by the server.
+func writeBody(w http.ResponseWriter, r *http.Request, body []byte) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(body) < gzipMinSize || !acceptsGzip(r) || w.Header().Get("Content-Encoding") != "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		// Otherwise the compressed bytes are sniffed
+		w.Header().Set("Content-Type", http.DetectContentType(body))
+	}
+	buf := gzipBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer gzipBuffers.Put(buf)
+	gz := gzipWriters.Get().(*gzip.Writer)
+	gz.Reset(buf)
+	gz.Write(body)
+	gz.Close()
+	gzipWriters.Put(gz)
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
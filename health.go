@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// healthTimeout bounds the PING of the targets by /-/healthy
+const healthTimeout = 2 * time.Second
+
+// isHealthPath reports whether the path is one of the health endpoints,
+// which may be exempted from authentication for probes. The status page
+// isn't one, as it shows the targets and configuration.
+func isHealthPath(path string) bool {
+	switch path {
+	case route("/-/healthy"), route("/-/ready"):
+		return true
+	}
+	return false
+}
+
+// ping sends PING to the target
+func (t *target) ping(ctx context.Context) error {
+	_, err := t.probe(ctx, "PING")
+	return err
+}
+
+// probe sends a command of the health endpoints to the target. A connection
+// is dialed outside of the pool when all of the pooled ones are busy, as with
+// slow scrapes, so that the health of the server is still told apart from the
+// load of the exporter.
+func (t *target) probe(ctx context.Context, cmd string, args ...interface{}) (string, error) {
+	var conn redis.Conn
+	var err error
+	if poolOpts.MaxActive > 0 && t.Pool.Stats().ActiveCount >= poolOpts.MaxActive {
+		conn, err = t.dial()
+	} else {
+		conn, err = t.Pool.GetContext(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return do(withContext(ctx, conn), cmd, args...)
+}
+
+// handleHealthy answers 200 when every target answers PING, and 503 with the
+// errors otherwise. It is cheap enough for liveness probes, which must not
+// wait for a full collection.
+func handleHealthy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+	all := currentTargets()
+	if len(all) == 0 {
+		http.Error(w, "no tile38 targets", http.StatusServiceUnavailable)
+		return
+	}
+	if failed := checkTargets(all, func(t *target) error { return t.ping(ctx) }); len(failed) > 0 {
+		http.Error(w, strings.Join(failed, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{\"status\":\"ok\",\"targets\":%d}\n", len(all))
+}
+
+// checkTargets runs the check on every target at once, returning the errors
+// prefixed with the address of their target
+func checkTargets(all []*target, check func(t *target) error) []string {
+	errs := make([]error, len(all))
+	var wg sync.WaitGroup
+	for i, t := range all {
+		wg.Add(1)
+		go func(i int, t *target) {
+			defer wg.Done()
+			errs[i] = check(t)
+		}(i, t)
+	}
+	wg.Wait()
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", all[i].Addr, err))
+		}
+	}
+	return failed
+}
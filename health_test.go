@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestIsHealthPath(t *testing.T) {
+	defer func(p string) { routePrefix = p }(routePrefix)
+	for _, prefix := range []string{"", "/tile38"} {
+		routePrefix = prefix
+		for path, want := range map[string]bool{
+			"/-/healthy": true,
+			"/-/ready":   true,
+			"/status":    false,
+			"/metrics":   false,
+			"/-/reload":  false,
+		} {
+			if got := isHealthPath(prefix + path); got != want {
+				t.Errorf("prefix %q: isHealthPath(%s) = %t, want %t", prefix, prefix+path, got, want)
+			}
+		}
+		if prefix != "" && isHealthPath("/-/healthy") {
+			t.Errorf("prefix %q: the unprefixed path is a health path", prefix)
+		}
+	}
+}
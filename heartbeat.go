@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// heartbeatOpts configures the pings of a deadman service after collections
+var heartbeatOpts struct {
+	URL, FailURL string
+	Method       string
+	Interval     time.Duration // minimum interval between pings of a URL
+}
+
+// heartbeatClient has a short timeout, as pings are only a signal of life
+var heartbeatClient = &http.Client{Timeout: 5 * time.Second}
+
+// heartbeats holds the time of the last ping of each URL
+var heartbeats struct {
+	sync.Mutex
+	last map[string]time.Time
+}
+
+var (
+	heartbeatsSent = newSelfMetric("counter", "tile38_exporter_heartbeats_total",
+		"Number of heartbeats delivered", "type")
+	heartbeatErrors = newSelfMetric("counter", "tile38_exporter_heartbeat_errors_total",
+		"Number of heartbeats that failed to be delivered", "type")
+)
+
+// heartbeat pings the heartbeat URL after a successful collection, or the
+// failure URL after a failed one, at most once per interval. The ping is sent
+// in the background so that it never slows down collection.
+func heartbeat(ok bool) {
+	url, typ := heartbeatOpts.URL, "success"
+	if !ok {
+		url, typ = heartbeatOpts.FailURL, "failure"
+	}
+	if url == "" {
+		return
+	}
+	heartbeats.Lock()
+	if heartbeats.last == nil {
+		heartbeats.last = make(map[string]time.Time)
+	}
+	if time.Since(heartbeats.last[url]) < heartbeatOpts.Interval {
+		heartbeats.Unlock()
+		return
+	}
+	heartbeats.last[url] = time.Now()
+	heartbeats.Unlock()
+
+	go func() {
+		if err := ping(url); err != nil {
+			log.Printf("heartbeat: %v", err)
+			heartbeatErrors.Inc(typ)
+			return
+		}
+		heartbeatsSent.Inc(typ)
+	}()
+}
+
+// ping requests a heartbeat URL
+func ping(url string) error {
+	req, err := http.NewRequest(heartbeatOpts.Method, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := heartbeatClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+// hedgeAfter is the delay after which a second SERVER request is issued on
+// another connection when the first has yet to return. Zero disables hedging.
+var hedgeAfter time.Duration
+
+var (
+	hedgedRequests = newSelfMetric("counter", "tile38_exporter_hedged_requests_total",
+		"Total number of hedged SERVER requests issued")
+	hedgeWins = newSelfMetric("counter", "tile38_exporter_hedge_wins_total",
+		"Total number of hedged SERVER requests that returned before the original")
+)
+
+// serverReply is the outcome of a SERVER request
+type serverReply struct {
+	conn     redis.Conn
+	out      string
+	plain    string // reply of the plain SERVER
+	err      error
+	hedge    bool
+	get, cmd time.Duration // time spent in pool_get and command phases
+}
+
+// serverStats issues SERVER ext against the target, pipelined with a plain
+// SERVER for the replication fields it alone reports, hedging the request
+// when enabled. The replies of both are returned. The connection that produced the returned reply is returned for
+// further use, and must be closed by the caller. The time spent getting the
+// connection and running the command is recorded in ph. The connection
+// gives up on commands at the deadline of ctx, and when ctx is cancelled. A
+// pooled connection found dead is replaced once by a new one.
+func (t *target) serverStats(ctx context.Context, ph phases) (conn redis.Conn, ext, plain string, err error) {
+	attempt := func(ctx context.Context, hedge bool) serverReply {
+		start := time.Now()
+		waiting := t.poolWaiting()
+		conn, _ := t.Pool.GetContext(ctx)
+		conn = withContext(ctx, conn)
+		get := time.Since(start)
+		if waiting {
+			t.recordPoolWait(get)
+		}
+		out, plain, err := serverCommands(conn)
+		if isBrokenConn(err) && ctx.Err() == nil {
+			// The pooled connection died while idle. Retry once on a
+			// freshly dialed one, which is closed rather than pooled
+			// after the scrape.
+			if fresh, derr := t.dial(); derr == nil {
+				conn.Close()
+				conn = withContext(ctx, fresh)
+				out, plain, err = serverCommands(conn)
+			}
+		}
+		return serverReply{conn, out, plain, err, hedge, get, time.Since(start) - get}
+	}
+	// The probe of a half-open circuit tests the server with a single
+	// connection
+	if hedgeAfter <= 0 || t.cb.probing() {
+		r := attempt(ctx, false)
+		ph.add("pool_get", r.get)
+		ph.add("command", r.cmd)
+		return r.conn, r.out, r.plain, r.err
+	}
+	// Each attempt has its own context, cancelled to abort its command
+	// when the other one wins, indexed by whether it's the hedge
+	var ctxs [2]context.Context
+	var cancels [2]context.CancelFunc
+	for i := range ctxs {
+		ctxs[i], cancels[i] = context.WithCancel(ctx)
+	}
+	index := func(r serverReply) int {
+		if r.hedge {
+			return 1
+		}
+		return 0
+	}
+	replies := make(chan serverReply, 2)
+	go func() { replies <- attempt(ctxs[0], false) }()
+	timer := time.NewTimer(hedgeAfter)
+	defer timer.Stop()
+	select {
+	case r := <-replies:
+		cancels[1]()
+		ph.add("pool_get", r.get)
+		ph.add("command", r.cmd)
+		return cancelConn{r.conn, cancels[0]}, r.out, r.plain, r.err
+	case <-timer.C:
+	}
+	hedgedRequests.Inc()
+	go func() { replies <- attempt(ctxs[1], true) }()
+
+	// Use the first successful reply, or the last reply when both fail.
+	r := <-replies
+	if r.err != nil {
+		cancels[index(r)]()
+		r.conn.Close()
+		r = <-replies
+	} else {
+		// Cancelling the loser aborts its command, closing its
+		// connection at once rather than once a stalled server replies
+		cancels[1-index(r)]()
+		go func() {
+			loser := <-replies
+			loser.conn.Close()
+		}()
+	}
+	if r.hedge && r.err == nil {
+		hedgeWins.Inc()
+	}
+	// The hedge delay counts towards the command phase, as that is the time
+	// spent waiting on Tile38.
+	ph.add("pool_get", r.get)
+	ph.add("command", r.cmd)
+	if r.hedge {
+		ph.add("command", hedgeAfter)
+	}
+	return cancelConn{r.conn, cancels[index(r)]}, r.out, r.plain, r.err
+}
+
+// replicationKeys are the stats of a follower reported by plain SERVER only
+var replicationKeys = []string{"following", "caught_up", "caught_up_once"}
+
+// serverCommands runs SERVER ext and plain SERVER on the connection, failing
+// when either fails
+func serverCommands(conn redis.Conn) (ext, plain string, err error) {
+	replies := doAll(conn, []command{{"SERVER", []interface{}{"ext"}}, {"SERVER", nil}})
+	for _, r := range replies {
+		if r.Err != nil {
+			return "", "", r.Err
+		}
+	}
+	return replies[0].Out, replies[1].Out, nil
+}
+
+// parseStats returns the stats of the SERVER ext reply, along with the
+// replication fields of the plain SERVER reply
+func parseStats(ext, plain string) map[string]gjson.Result {
+	stats := gjson.Get(ext, "stats").Map()
+	for _, key := range replicationKeys {
+		if v := gjson.Get(plain, "stats."+key); v.Exists() {
+			stats[key] = v
+		}
+	}
+	return stats
+}
+
+// cancelConn is the connection of the winning attempt of a hedged request,
+// whose context is released once the connection is closed
+type cancelConn struct {
+	redis.Conn
+	cancel context.CancelFunc
+}
+
+func (c cancelConn) Close() error {
+	err := c.Conn.Close()
+	c.cancel()
+	return err
+}
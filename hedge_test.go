@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHedgeCancelsLoser(t *testing.T) {
+	defer func(d time.Duration) { hedgeAfter = d }(hedgeAfter)
+	hedgeAfter = 20 * time.Millisecond
+
+	f := newFakeTile38(t)
+	f.stallServer(1)
+	tg := newTestTarget(t, f)
+	conn, out, _, err := tg.serverStats(context.Background(), make(phases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if out == "" {
+		t.Fatal("empty reply")
+	}
+	// The stalled attempt is aborted as soon as the hedge wins, leaving
+	// only the connection of the winner open
+	deadline := time.Now().Add(time.Second)
+	for f.open() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections open, want the loser closed", f.open())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+// tile38HTTPClient is the client of the Tile38 servers scraped over HTTP,
+// shared so that their connections are reused
+var tile38HTTPClient struct {
+	once   sync.Once
+	client *http.Client
+}
+
+// httpClient returns the shared client, which connects like the pool does
+func httpClient() *http.Client {
+	tile38HTTPClient.once.Do(func() {
+		dialer := newDialer()
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if tile38Proxy != nil {
+					return dialProxy(dialer, network, addr)
+				}
+				return dialer.DialContext(ctx, network, addr)
+			},
+			TLSClientConfig:     tile38TLS,
+			TLSHandshakeTimeout: timeouts.Connect,
+			MaxIdleConnsPerHost: poolOpts.MaxIdle,
+			IdleConnTimeout:     poolOpts.IdleTimeout,
+		}
+		tile38HTTPClient.client = &http.Client{Transport: transport}
+	})
+	return tile38HTTPClient.client
+}
+
+// isHTTPAddr reports whether the target address is the URL of the HTTP
+// transport of Tile38
+func isHTTPAddr(addr string) bool {
+	return strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://")
+}
+
+// httpConn runs the commands of a target over the HTTP transport of Tile38,
+// one request per command, replying with the JSON body as a bulk string
+// would. AUTH is kept and sent along with every request, and OUTPUT is a
+// no-op, as replies over HTTP are always JSON.
+type httpConn struct {
+	url      string
+	password string
+	queued   []command
+}
+
+var _ redis.ConnWithTimeout = (*httpConn)(nil)
+
+// dialHTTP returns a connection to the target over HTTP, authenticated
+// like connections over RESP are
+func (t *target) dialHTTP() (redis.Conn, error) {
+	conn := &httpConn{url: t.Addr}
+	if err := t.creds.authenticate(conn, t.Addr); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *httpConn) Close() error { return nil }
+
+func (c *httpConn) Err() error { return nil }
+
+func (c *httpConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.DoWithTimeout(timeouts.ReadWrite, cmd, args...)
+}
+
+// DoWithTimeout runs the command, or the queued commands when cmd is empty,
+// giving up after the timeout unless it is 0
+func (c *httpConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	ctx := context.Background()
+	if len(args) > 0 {
+		if co, ok := args[0].(cancelOn); ok {
+			ctx, args = co.ctx, args[1:]
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if cmd == "" {
+		queued := c.queued
+		c.queued = nil
+		if len(queued) == 0 {
+			return nil, nil
+		}
+		replies := make([]interface{}, len(queued))
+		for i, q := range queued {
+			reply, err := c.request(ctx, q.Name, q.Args)
+			if err != nil {
+				return nil, err
+			}
+			replies[i] = reply
+		}
+		return replies, nil
+	}
+	return c.request(ctx, cmd, args)
+}
+
+func (c *httpConn) Send(cmd string, args ...interface{}) error {
+	c.queued = append(c.queued, command{cmd, args})
+	return nil
+}
+
+func (c *httpConn) Flush() error { return nil }
+
+func (c *httpConn) Receive() (interface{}, error) {
+	return nil, errors.New("http transport: receive is not supported")
+}
+
+func (c *httpConn) ReceiveWithTimeout(time.Duration) (interface{}, error) {
+	return c.Receive()
+}
+
+// request runs a command. The command and its arguments form the path,
+// separated by '+'.
+func (c *httpConn) request(ctx context.Context, cmd string, args []interface{}) (interface{}, error) {
+	switch strings.ToUpper(cmd) {
+	case "OUTPUT":
+		return []byte(`{"ok":true}`), nil
+	case "AUTH":
+		// The password is verified with a PING, and kept only when
+		// accepted
+		prev := c.password
+		c.password = fmt.Sprint(args...)
+		reply, err := c.request(ctx, "PING", nil)
+		if err != nil || !gjson.GetBytes(reply.([]byte), "ok").Bool() {
+			c.password = prev
+		}
+		return reply, err
+	}
+	parts := []string{escapeArg(cmd)}
+	for _, arg := range args {
+		parts = append(parts, escapeArg(fmt.Sprint(arg)))
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url+"/"+strings.Join(parts, "+"), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.password != "" {
+		req.Header.Set("Authorization", c.password)
+	}
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		// The error quotes the URL, which holds the arguments
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("http transport: %s", resp.Status)
+	}
+	return body, nil
+}
+
+// escapeArg escapes an argument for the path of a request
+func escapeArg(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), "+", "%2B")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxContentType is the content type of the InfluxDB line protocol
+const influxContentType = "text/plain; charset=utf-8"
+
+// influxEscaper escapes tag keys, tag values and field keys, which can't hold
+// newlines at all
+var influxEscaper = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+
+// influxMeasurementEscaper escapes measurement names
+var influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+
+// renderInflux produces InfluxDB line protocol from the passed sections. The
+// measurement is the namespace, or tile38 without one, and each line holds
+// the series of a label set as fields named after the metrics, without the
+// namespace, with the labels as tags. Lines are stamped with the timestamp of
+// their samples, or t, in nanoseconds. NaN and infinite values, which the line
+// protocol can't hold, are left out.
+func renderInflux(sections []section, n string, t time.Time) string {
+	measurement := n
+	if measurement == "" {
+		measurement = "tile38"
+	}
+	measurement = influxMeasurementEscaper.Replace(measurement)
+	type line struct {
+		tags   string
+		ts     int64
+		fields []string
+	}
+	var lines []*line
+	byKey := make(map[string]*line)
+	for _, s := range groupFamilies(sections, n) {
+		fams := append([]*family(nil), s.Families...)
+		sort.SliceStable(fams, func(i, j int) bool {
+			return fams[i].Name < fams[j].Name
+		})
+		for _, f := range fams {
+			field := strings.TrimPrefix(finalName(n, f.Name), n+"_")
+			for _, smp := range f.Samples {
+				if math.IsNaN(smp.Value) || math.IsInf(smp.Value, 0) {
+					continue
+				}
+				ts := t.UnixNano()
+				if smp.Timestamp != 0 {
+					ts = smp.Timestamp * int64(time.Millisecond)
+				}
+				tags := influxTags(smp.Labels)
+				key := tags + " " + strconv.FormatInt(ts, 10)
+				l, ok := byKey[key]
+				if !ok {
+					l = &line{tags: tags, ts: ts}
+					byKey[key] = l
+					lines = append(lines, l)
+				}
+				l.fields = append(l.fields, influxEscaper.Replace(field+smp.Suffix)+"="+
+					strconv.FormatFloat(smp.Value, 'f', -1, 64))
+			}
+		}
+	}
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.WriteString(measurement)
+		sb.WriteString(l.tags)
+		sb.WriteByte(' ')
+		sb.WriteString(strings.Join(l.fields, ","))
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.FormatInt(l.ts, 10))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// influxTags returns the labels as line protocol tags sorted by name, each
+// preceded by a comma. Labels with empty values are left out, as tags can't
+// be empty.
+func influxTags(labels []label) string {
+	sorted := append([]label(nil), labels...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	var sb strings.Builder
+	for _, l := range sorted {
+		if l.Value == "" {
+			continue
+		}
+		sb.WriteByte(',')
+		sb.WriteString(influxEscaper.Replace(l.Name))
+		sb.WriteByte('=')
+		sb.WriteString(influxEscaper.Replace(l.Value))
+	}
+	return sb.String()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// jsonMetrics serves the metrics as JSON on /metrics.json, for debugging
+var jsonMetrics bool
+
+// jsonDocument is the body of /metrics.json
+type jsonDocument struct {
+	Time    string       `json:"time"`
+	Targets []jsonTarget `json:"targets"`
+	Metrics []jsonMetric `json:"metrics"`
+}
+
+// jsonTarget describes the collection of a Tile38 server
+type jsonTarget struct {
+	Addr            string  `json:"addr"`
+	Up              bool    `json:"up"`
+	Error           string  `json:"error,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	ReplyBytes      int     `json:"reply_bytes"`
+}
+
+// jsonMetric is a single series. NaN and infinite values are null.
+type jsonMetric struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	Help   string            `json:"help"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  interface{}       `json:"value"`
+}
+
+// handleJSON serves the same snapshot as /metrics as JSON, with every series
+// under its exported name, along with the collection of each target
+func handleJSON(w http.ResponseWriter, r *http.Request, n string) {
+	snap := getSnapshot(r.Context())
+	var errs []string
+	for _, res := range snap.Results {
+		if res.Err != nil {
+			errs = append(errs, res.Err.Error())
+		}
+	}
+	if len(snap.Results) > 0 && len(errs) == len(snap.Results) {
+		http.Error(w, strings.Join(errs, "\n"), 500)
+		return
+	}
+	if err := checkStrict(snap); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	doc := jsonDocument{Time: snap.Time.UTC().Format(time.RFC3339Nano),
+		Targets: []jsonTarget{}, Metrics: []jsonMetric{}}
+	for _, res := range snap.Results {
+		t := jsonTarget{Addr: res.Target.Addr, Up: res.Err == nil, ReplyBytes: res.Bytes}
+		if res.Err != nil {
+			t.Error = res.Err.Error()
+		}
+		for _, d := range res.Phases {
+			t.DurationSeconds += d.Seconds()
+		}
+		doc.Targets = append(doc.Targets, t)
+	}
+	sections, _ := relabel(servedSections(snap))
+	for _, s := range groupFamilies(sections, n) {
+		fams := append([]*family(nil), s.Families...)
+		sort.SliceStable(fams, func(i, j int) bool {
+			return fams[i].Name < fams[j].Name
+		})
+		for _, f := range fams {
+			name := finalName(n, f.Name)
+			samples := append([]sample(nil), f.Samples...)
+			sort.SliceStable(samples, func(i, j int) bool {
+				return sampleLess(samples[i], samples[j])
+			})
+			for _, smp := range samples {
+				m := jsonMetric{Name: name + smp.Suffix, Type: f.Type, Help: f.Help}
+				if len(smp.Labels) > 0 {
+					m.Labels = make(map[string]string, len(smp.Labels))
+					for _, l := range smp.Labels {
+						m.Labels[l.Name] = l.Value
+					}
+				}
+				if !math.IsNaN(smp.Value) && !math.IsInf(smp.Value, 0) {
+					m.Value = smp.Value
+				}
+				doc.Metrics = append(doc.Metrics, m)
+			}
+		}
+	}
+	data, _ := json.MarshalIndent(doc, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	writeBody(w, r, append(data, '\n'))
+}
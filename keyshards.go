@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// shardConfig maps a shard of a dataset, sharded by key prefix, to the
+// Tile38 server holding it. The series of the server are labeled with the
+// shard name.
+type shardConfig struct {
+	Name     string   `yaml:"name"`
+	Target   string   `yaml:"target"`
+	Prefixes []string `yaml:"prefixes"`
+}
+
+// validate checks the shard for errors
+func (sc shardConfig) validate() error {
+	if sc.Name == "" {
+		return errors.New("missing name")
+	}
+	if sc.Target == "" {
+		return errors.New("missing target")
+	}
+	return nil
+}
+
+// shardOf returns the shard held by the target with the passed address, if
+// any
+func (c *config) shardOf(addr string) (shardConfig, bool) {
+	for _, sc := range c.Shards {
+		if sc.Target == addr {
+			return sc, true
+		}
+	}
+	return shardConfig{}, false
+}
+
+// unexpectedKeys returns the number of keys that match none of the
+// declared prefixes of the shard
+func (sc shardConfig) unexpectedKeys(keys []string) int {
+	n := 0
+	for _, key := range keys {
+		expected := false
+		for _, prefix := range sc.Prefixes {
+			if strings.HasPrefix(key, prefix) {
+				expected = true
+				break
+			}
+		}
+		if !expected {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kubernetesOpts configures the discovery of targets from the pods of the
+// Kubernetes cluster the exporter runs in
+var kubernetesOpts struct {
+	Enabled   bool
+	Selector  string
+	Namespace string
+	Port      string
+	Resync    time.Duration
+}
+
+// serviceAccountDir holds the credentials of the pod's service account
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubePod is the part of a pod used for discovery
+type kubePod struct {
+	Metadata struct {
+		Name, Namespace, ResourceVersion string
+	}
+	Spec struct {
+		Containers []struct {
+			Ports []struct {
+				Name          string
+				ContainerPort int
+			}
+		}
+	}
+	Status struct {
+		PodIP      string
+		Conditions []struct{ Type, Status string }
+	}
+}
+
+// kubeClient talks to the API server of the cluster the exporter runs in
+type kubeClient struct {
+	base      string
+	token     string
+	namespace string // namespace of the exporter's pod
+	client    *http.Client
+}
+
+// newKubeClient returns a client using the pod's service account
+func newKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a cluster")
+	}
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in ca.crt")
+	}
+	return &kubeClient{
+		base:      "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		namespace: strings.TrimSpace(string(namespace)),
+		client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}, nil
+}
+
+// get requests a path of the API server
+func (c *kubeClient) get(path string, q url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.base+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+// watchKubernetes keeps the targets in sync with the ready pods matching the
+// selector. The pods are listed and then watched until the resync interval
+// passes, when they are listed again. Errors are retried, keeping the
+// current targets.
+func watchKubernetes() {
+	c, err := newKubeClient()
+	for err != nil {
+		log.Printf("kubernetes: %v", err)
+		sdErrors.Inc("kubernetes")
+		time.Sleep(5 * time.Second)
+		c, err = newKubeClient()
+	}
+	for {
+		if err := c.sync(); err != nil {
+			log.Printf("kubernetes: %v", err)
+			sdErrors.Inc("kubernetes")
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// sync lists the pods and then applies the watch events until the resync
+// interval passes. Pods are looked up in the exporter's own namespace unless
+// another one is configured.
+func (c *kubeClient) sync() error {
+	namespace := kubernetesOpts.Namespace
+	if namespace == "" {
+		namespace = c.namespace
+	}
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods"
+	q := url.Values{}
+	if kubernetesOpts.Selector != "" {
+		q.Set("labelSelector", kubernetesOpts.Selector)
+	}
+
+	resp, err := c.get(path, q)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Metadata struct{ ResourceVersion string }
+		Items    []kubePod
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	pods := make(map[string]kubePod)
+	for _, pod := range list.Items {
+		pods[pod.Metadata.Namespace+"/"+pod.Metadata.Name] = pod
+	}
+	updateDiscovered("kubernetes", podTargets(pods))
+
+	q.Set("watch", "1")
+	q.Set("resourceVersion", list.Metadata.ResourceVersion)
+	q.Set("timeoutSeconds", strconv.Itoa(int(kubernetesOpts.Resync.Seconds())))
+	resp, err = c.get(path, q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string
+			Object json.RawMessage
+		}
+		if err := dec.Decode(&event); err != nil {
+			// The watch ends on the timeout, time to resync
+			return nil
+		}
+		if event.Type == "ERROR" {
+			// Usually an expired resource version, resync
+			return nil
+		}
+		var pod kubePod
+		if err := json.Unmarshal(event.Object, &pod); err != nil {
+			return err
+		}
+		key := pod.Metadata.Namespace + "/" + pod.Metadata.Name
+		if event.Type == "DELETED" {
+			delete(pods, key)
+		} else {
+			pods[key] = pod
+		}
+		updateDiscovered("kubernetes", podTargets(pods))
+	}
+}
+
+// podTargets returns the targets of the ready pods with an IP and the
+// configured port, sorted by pod
+func podTargets(pods map[string]kubePod) []discovered {
+	keys := make([]string, 0, len(pods))
+	for key := range pods {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var found []discovered
+	for _, key := range keys {
+		pod := pods[key]
+		port := podPort(pod)
+		if pod.Status.PodIP == "" || port == 0 || !podReady(pod) {
+			continue
+		}
+		found = append(found, discovered{
+			Addr: net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port)),
+			Labels: []label{{"pod", pod.Metadata.Name},
+				{"namespace", pod.Metadata.Namespace}},
+		})
+	}
+	return found
+}
+
+// podPort returns the configured port of a pod, which is either a port name
+// or number
+func podPort(pod kubePod) int {
+	if n, err := strconv.Atoi(kubernetesOpts.Port); err == nil {
+		return n
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == kubernetesOpts.Port {
+				return p.ContainerPort
+			}
+		}
+	}
+	return 0
+}
+
+// podReady returns true when the pod's Ready condition is true
+func podReady(pod kubePod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// labelNameRE matches valid prometheus label names
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// checkLabelName returns an error when the name is not a valid label name,
+// or is reserved to Prometheus
+func checkLabelName(name string) error {
+	if !labelNameRE.MatchString(name) {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	if strings.HasPrefix(name, "__") {
+		return fmt.Errorf("label name %q is reserved", name)
+	}
+	return nil
+}
+
+// flagLabels are the constant labels of --label
+var flagLabels []label
+
+// parseLabelFlags parses the key=value pairs of --label
+func parseLabelFlags(pairs []string) ([]label, error) {
+	var labels []label
+	seen := make(map[string]bool)
+	for _, pair := range pairs {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("--label %q: expected name=value", pair)
+		}
+		name, value := strings.TrimSpace(pair[:i]), pair[i+1:]
+		if err := checkLabelName(name); err != nil {
+			return nil, fmt.Errorf("--label %q: %v", pair, err)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("--label %q: label %s is given twice", pair, name)
+		}
+		seen[name] = true
+		labels = append(labels, label{name, value})
+	}
+	return labels, nil
+}
+
+// constLabels returns the labels added to every exported series: those of
+// the configuration file, overridden by those of --label, sorted by name
+func constLabels() []label {
+	byName := make(map[string]string)
+	for name, value := range currentConfig().Labels {
+		byName[name] = value
+	}
+	for _, l := range flagLabels {
+		byName[l.Name] = l.Value
+	}
+	labels := make([]label, 0, len(byName))
+	for name, value := range byName {
+		labels = append(labels, label{name, value})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	return labels
+}
+
+// withConstLabels adds the constant labels to every series of the sections.
+// The labels of a series itself, such as addr or collection, take precedence
+// over constant labels of the same name. The passed families are not
+// modified.
+func withConstLabels(sections []section) []section {
+	labels := constLabels()
+	if len(labels) == 0 {
+		return sections
+	}
+	out := make([]section, 0, len(sections))
+	for _, s := range sections {
+		fams := make([]*family, 0, len(s.Families))
+		for _, f := range s.Families {
+			nf := &family{Name: f.Name, Type: f.Type, Help: f.Help,
+				Samples: make([]sample, 0, len(f.Samples))}
+			for _, smp := range f.Samples {
+				smp.Labels = mergeLabels(smp.Labels, labels)
+				nf.Samples = append(nf.Samples, smp)
+			}
+			fams = append(fams, nf)
+		}
+		out = append(out, section{Name: s.Name, Families: fams})
+	}
+	return out
+}
+
+// mergeLabels returns the labels followed by the extra labels they don't
+// have
+func mergeLabels(labels, extra []label) []label {
+	merged := append([]label(nil), labels...)
+	for _, e := range extra {
+		found := false
+		for _, l := range labels {
+			if l.Name == e.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, e)
+		}
+	}
+	return merged
+}
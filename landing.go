@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// telemetryPath is the path of the metrics endpoint
+var telemetryPath string
+
+// routePrefix is prepended to the paths of every endpoint, for reverse
+// proxies serving the exporter under a path. It is empty or starts with a
+// slash, without a trailing one.
+var routePrefix string
+
+// normalizeRoutePrefix returns the prefix with a leading slash and without a
+// trailing one, or empty for the root
+func normalizeRoutePrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// route returns the path of an endpoint under the route prefix
+func route(path string) string {
+	return routePrefix + path
+}
+
+var landingTmpl = template.Must(template.ParseFS(templatesFS, "templates/landing.html"))
+
+// landingLink is a link of the landing page
+type landingLink struct{ Name, Path string }
+
+// checkTelemetryPath validates --web-telemetry-path
+func checkTelemetryPath() error {
+	if !strings.HasPrefix(telemetryPath, "/") || telemetryPath == "/" {
+		return errors.New("--web-telemetry-path must start with a slash and not be /")
+	}
+	switch telemetryPath {
+	case "/status", "/metadata", "/stream", "/-/healthy", "/-/ready", "/-/reload":
+		return fmt.Errorf("--web-telemetry-path %s is taken by another endpoint", telemetryPath)
+	}
+	return nil
+}
+
+// handleLanding links to the endpoints of the exporter from / under the
+// route prefix, and answers 404 to every other path not served
+func handleLanding(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != route("/") {
+		http.NotFound(w, r)
+		return
+	}
+	links := []landingLink{
+		{"Metrics", route(telemetryPath)},
+		{"Metrics as CSV", route(telemetryPath + ".csv")},
+		{"Metric metadata", route("/metadata")},
+		{"Status", route("/status")},
+		{"Health", route("/-/healthy")},
+		{"Readiness", route("/-/ready")},
+	}
+	if jsonMetrics {
+		links = append(links, landingLink{"Metrics as JSON", route(telemetryPath + ".json")})
+	}
+	if streamOpts.Enabled {
+		links = append(links, landingLink{"Live metrics", route("/stream")})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := landingTmpl.Execute(w, links); err != nil {
+		log.Printf("landing page: %v", err)
+	}
+}
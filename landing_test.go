@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestNormalizeRoutePrefix(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"":                    "",
+		"/":                   "",
+		"//":                  "",
+		" / ":                 "",
+		"tile38":              "/tile38",
+		"/tile38":             "/tile38",
+		"/tile38/":            "/tile38",
+		"exporters/tile38/":   "/exporters/tile38",
+		"/exporters/tile38//": "/exporters/tile38",
+	} {
+		if got := normalizeRoutePrefix(prefix); got != want {
+			t.Errorf("normalizeRoutePrefix(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
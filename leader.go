@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+// leaderOpts configures the election of a single exporter replica to run the
+// expensive collectors of a target. The lock is a string object with an
+// expiration stored in the target itself.
+var leaderOpts struct {
+	Enabled bool
+	Key     string // collection key of the lock object
+	ID      string // identity of this replica
+	TTL     time.Duration
+}
+
+// leaderLockID is the object id of the lock within its collection
+const leaderLockID = "expensive-leader"
+
+// defaultLeaderID identifies this replica by host name and process id
+func defaultLeaderID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// leaderLoop acquires or renews the lock of every target three times per
+// lock TTL, so that losing a lock is noticed well before it expires.
+func leaderLoop() {
+	for {
+		for _, t := range currentTargets() {
+			t.setLeader(t.renewLeader())
+		}
+		time.Sleep(leaderOpts.TTL / 3)
+	}
+}
+
+// renewLeader extends the lock of the target when it's held by this
+// replica, or tries to acquire it when it's free. It returns whether this
+// replica holds the lock.
+func (t *target) renewLeader() bool {
+	conn := t.Pool.Get()
+	defer conn.Close()
+	ttl := int(leaderOpts.TTL.Seconds())
+	holder, err := leaderHolder(conn)
+	if err != nil {
+		log.Printf("leader lock on %s: %v", t.Addr, err)
+		return false
+	}
+	switch holder {
+	case leaderOpts.ID:
+		if _, err := do(conn, "EXPIRE", leaderOpts.Key, leaderLockID, ttl); err != nil {
+			log.Printf("leader lock on %s: %v", t.Addr, err)
+			return false
+		}
+		return true
+	case "":
+		// Another replica may win the race, so the holder is read back
+		do(conn, "SET", leaderOpts.Key, leaderLockID, "EX", ttl, "NX",
+			"STRING", leaderOpts.ID)
+		holder, err = leaderHolder(conn)
+		return err == nil && holder == leaderOpts.ID
+	}
+	return false
+}
+
+// leaderHolder returns the replica holding the lock, or an empty string when
+// the lock is free
+func leaderHolder(conn redis.Conn) (string, error) {
+	out, err := do(conn, "GET", leaderOpts.Key, leaderLockID)
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return gjson.Get(out, "object").String(), nil
+}
+
+// setLeader records whether this replica holds the lock of the target
+func (t *target) setLeader(leader bool) {
+	t.mu.Lock()
+	changed := t.leader != leader
+	t.leader = leader
+	t.mu.Unlock()
+	if changed && leader {
+		log.Printf("Running the expensive collectors of %s", t.Addr)
+	} else if changed {
+		log.Printf("Another replica runs the expensive collectors of %s", t.Addr)
+	}
+}
+
+// isLeader returns whether this replica holds the lock of the target
+func (t *target) isLeader() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.leader
+}
+
+// releaseLeaderLocks deletes the locks held by this replica, so that another
+// replica takes over right away
+func releaseLeaderLocks() {
+	if !leaderOpts.Enabled {
+		return
+	}
+	for _, t := range currentTargets() {
+		if !t.isLeader() {
+			continue
+		}
+		conn := t.Pool.Get()
+		if holder, err := leaderHolder(conn); err == nil && holder == leaderOpts.ID {
+			do(conn, "DEL", leaderOpts.Key, leaderLockID)
+		}
+		conn.Close()
+		t.setLeader(false)
+	}
+}
+
+var leaderMetric = metric{"gauge", "tile38_exporter_is_expensive_leader", "Whether or not this replica runs the expensive collectors of the Tile38 server"}
+
+// leaderFamily returns whether this replica runs the expensive collectors of
+// the target
+func (t *target) leaderFamily() *family {
+	v := 0.0
+	if t.isLeader() {
+		v = 1
+	}
+	return leaderMetric.family(v)
+}
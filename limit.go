@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// maxScrapesInFlight is the maximum number of concurrent scrapes, or 0 for
+// no limit
+var maxScrapesInFlight int
+
+// scrapesInFlight is the number of scrapes being served
+var scrapesInFlight int64
+
+var (
+	scrapesInFlightGauge = newSelfGaugeFunc("tile38_exporter_scrapes_in_flight",
+		"Number of scrapes being served", func() float64 {
+			return float64(atomic.LoadInt64(&scrapesInFlight))
+		})
+	scrapesRejected = newSelfMetric("counter", "tile38_exporter_scrapes_rejected_total",
+		"Total number of scrapes rejected by --web-max-requests-in-flight")
+)
+
+// limitInFlight answers 503 to the scrapes beyond --web-max-requests-in-flight
+// rather than queueing them on the Tile38 servers
+func limitInFlight(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&scrapesInFlight, 1)
+		defer atomic.AddInt64(&scrapesInFlight, -1)
+		if maxScrapesInFlight > 0 && n > int64(maxScrapesInFlight) {
+			scrapesRejected.Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many scrapes in flight", http.StatusServiceUnavailable)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpSocketMode is the file mode of the unix socket of the http server, in
+// octal
+var httpSocketMode string
+
+// listen binds the listener of the http server. The address is host:port,
+// or unix:///path/to/socket for a unix socket, which is created with the
+// file mode of --http-socket-mode and removed when the listener is closed.
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix://") {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, "unix://")
+	if path == "" {
+		return nil, fmt.Errorf("%s: expected unix:///path/to/socket", addr)
+	}
+	mode, err := strconv.ParseUint(httpSocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("--http-socket-mode must be an octal file mode, not %q", httpSocketMode)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket left at path by a previous run that
+// did not shut down cleanly. Sockets still served by another process and
+// files other than sockets are left alone.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s: exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s: in use by another process", path)
+	}
+	return os.Remove(path)
+}
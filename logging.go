@@ -0,0 +1,13 @@
+package main
+
+import "log"
+
+// logDebug enables debug logging
+var logDebug bool
+
+// debugf logs a debug message, when debug logging is enabled
+func debugf(format string, args ...interface{}) {
+	if logDebug {
+		log.Printf("level=debug "+format, args...)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -8,16 +9,15 @@ import (
 	"math"
 	"net/http"
 	"os"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/tidwall/gjson"
 )
 
-// metrics is the slice of all data desired in the metrics output
-var metrics = []metric{
-	// Go/Memory Stats
+// goMetrics are the Go runtime and memory stats of the Tile38 process
+var goMetrics = []metric{
 	metric{"gauge", "go_goroutines", "Number of goroutines that currently exist"},
 	metric{"gauge", "go_threads", "Number of OS threads created"},
 	metric{"gauge", "alloc_bytes", "Number of bytes allocated and still in use"},
@@ -45,7 +45,10 @@ var metrics = []metric{
 	metric{"gauge", "next_gc_bytes", "Number of heap bytes when next garbage collection will take place"},
 	metric{"gauge", "last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection"},
 	metric{"gauge", "gc_cpu_fraction", "The fraction of this program's available CPU time used by the GC since the program started"},
-	// Tile38 Stats
+}
+
+// tile38Metrics are the Tile38 server stats
+var tile38Metrics = []metric{
 	metric{"gauge", "tile38_pid", "The process ID of the server"},
 	metric{"gauge", "tile38_max_heap_size", "Maximum heap size allowed"},
 	metric{"gauge", "tile38_read_only", "Whether or not the server is read-only"},
@@ -72,108 +75,761 @@ var metrics = []metric{
 	metric{"gauge", "tile38_in_memory_size", "Total in memory size of all collections"},
 }
 
-var pool *redis.Pool
+// upMetric reports whether a Tile38 server could be scraped
+var upMetric = metric{"gauge", "tile38_up", "Whether or not the Tile38 server could be scraped"}
+
+// caughtUpMetric reports whether a follower has caught up with its leader
+var caughtUpMetric = metric{"gauge", "tile38_follower_caught_up", "Whether or not the follower has caught up with its leader"}
+
+// fragmentationMetric is derived from the Go stats of the server
+var fragmentationMetric = metric{"gauge", "tile38_memory_fragmentation_ratio", "Ratio of bytes obtained from system to heap bytes allocated"}
+
+// collectors produce the sections of the metrics output, in order. Optional
+// collectors are appended at startup when enabled.
+var collectors = []collector{
+	{"go", collectGo},
+	{"tile38", collectTile38},
+}
+
+// expensiveCollectors are the optional collectors that are skipped while
+// Tile38 rewrites its AOF, when reducing load during rewrites, and that only
+// the leader runs when replicas elect one
+var expensiveCollectors = map[string]bool{"collections": true, "queries": true}
+
+// reduceLoadDuringRewrite skips the expensive collectors during AOF rewrites
+var reduceLoadDuringRewrite bool
+
+// pidFile is the path of the pid file, if any, written once the listeners
+// are bound. pidFileForce overwrites the file of a running process.
+var (
+	pidFile      string
+	pidFileForce bool
+)
 
 func main() {
-	var tile38Auth string
+	var tile38Auth stringList
+	var labelFlags stringList
+	var tile38AuthFile string
+	var logLevel string
 	var tile38Addr string
+	var tile38ProxyURI string
+	var shadowAuth string
+	var shadowAddr string
 	var httpAddr string
-	var namespace string
 
-	flag.StringVar(&tile38Auth, "tile38-auth", "", "tile38 auth")
-	flag.StringVar(&tile38Addr, "tile38-addr", ":9851", "address to tile38 server")
-	flag.StringVar(&httpAddr, "http-addr", ":8080", "http server address")
+	flag.Var(&tile38Auth, "tile38-auth", "tile38 auth, may be repeated to try multiple passwords")
+	flag.StringVar(&tile38AuthFile, "tile38-auth-file", "", "file of tile38 auth passwords, one per line")
+	flag.StringVar(&tile38Addr, "tile38-addr", ":9851", "address or uri of tile38 server, or a comma separated list of them")
+	flag.BoolVar(&tlsOpts.Enabled, "tile38-tls", false, "connect to tile38 over tls")
+	flag.StringVar(&tlsOpts.CAFile, "tile38-tls-ca-file", "", "ca certificates verifying tile38, implies --tile38-tls")
+	flag.BoolVar(&tlsOpts.SkipVerify, "tile38-tls-skip-verify", false, "do not verify the tile38 certificate, implies --tile38-tls")
+	flag.StringVar(&tlsOpts.CertFile, "tile38-tls-cert-file", "", "client certificate presented to tile38, implies --tile38-tls")
+	flag.StringVar(&tlsOpts.KeyFile, "tile38-tls-key-file", "", "key of the client certificate")
+	flag.StringVar(&tile38ProxyURI, "tile38-proxy", "", "socks5 proxy through which tile38 is reached, socks5://[user:password@]host:port")
+	flag.DurationVar(&timeouts.Connect, "tile38-connect-timeout", 5*time.Second, "timeout of connecting to tile38")
+	flag.DurationVar(&timeouts.ReadWrite, "tile38-timeout", 10*time.Second, "timeout of reading and writing tile38 commands")
+	flag.DurationVar(&timeouts.KeepAlive, "tile38-keepalive", 30*time.Second, "period of TCP keepalive probes on tile38 connections, 0 to disable")
+	flag.IntVar(&backoffOpts.After, "dial-backoff-after", 3, "consecutive failures to connect to a tile38 server before backing off, 0 to disable")
+	flag.DurationVar(&backoffOpts.Max, "dial-backoff-max", time.Minute, "longest time connecting to a failing tile38 server is not attempted")
+	flag.IntVar(&breakerOpts.Failures, "breaker-failures", 0, "consecutive scrape failures of a tile38 server opening its circuit breaker, 0 to disable")
+	flag.DurationVar(&breakerOpts.Cooldown, "breaker-cooldown", 30*time.Second, "time the circuit breaker stays open before probing the tile38 server")
+	flag.BoolVar(&pipelineCommands, "pipeline", true, "pipeline the independent commands of a collector, such as BOUNDS and GET")
+	flag.IntVar(&poolOpts.MaxIdle, "pool-max-idle", 5, "maximum number of idle connections per tile38 server")
+	flag.IntVar(&poolOpts.MaxActive, "pool-max-active", 0, "maximum number of connections per tile38 server, 0 for no limit")
+	flag.DurationVar(&poolOpts.IdleTimeout, "pool-idle-timeout", 0, "close connections idle for this long, 0 to keep them")
+	flag.BoolVar(&poolOpts.Wait, "pool-wait", false, "wait for a connection when the pool is exhausted instead of failing")
+	flag.DurationVar(&poolOpts.MaxConnLifetime, "pool-max-conn-lifetime", 0, "close connections older than this instead of reusing them, 0 to keep them")
+	flag.DurationVar(&poolOpts.TestIdle, "pool-test-idle", 30*time.Second, "PING connections idle for longer than this before reusing them, 0 to disable")
+	flag.StringVar(&shadowAddr, "shadow-addr", "", "address to a tile38 server to compare against")
+	flag.StringVar(&shadowAuth, "shadow-auth", "", "shadow tile38 auth")
+	flag.StringVar(&consulOpts.Addr, "consul-addr", "", "discover tile38 servers from this consul agent")
+	flag.StringVar(&consulOpts.Service, "consul-service", "tile38", "consul service of the tile38 servers")
+	flag.StringVar(&consulOpts.Tag, "consul-tag", "", "only discover consul services with this tag")
+	flag.StringVar(&consulOpts.Datacenter, "consul-dc", "", "consul datacenter to discover services in")
+	flag.BoolVar(&kubernetesOpts.Enabled, "kubernetes-sd", false, "discover tile38 pods from the kubernetes api")
+	flag.StringVar(&kubernetesOpts.Selector, "kubernetes-selector", "", "label selector of the tile38 pods")
+	flag.StringVar(&kubernetesOpts.Namespace, "kubernetes-namespace", "", "namespace of the tile38 pods, defaults to the exporter's own")
+	flag.StringVar(&kubernetesOpts.Port, "kubernetes-port", "tile38", "name or number of the tile38 port of the pods")
+	flag.DurationVar(&kubernetesOpts.Resync, "kubernetes-resync", 5*time.Minute, "interval of listing all pods again")
+	flag.IntVar(&shardOpts.Index, "shard-index", 0, "shard of the targets collected by this exporter")
+	flag.IntVar(&shardOpts.Count, "shard-count", 1, "number of exporters sharing the targets")
+	flag.StringVar(&httpAddr, "http-addr", ":8080", "comma separated http server addresses, or unix:///path/to/socket")
+	flag.StringVar(&telemetryPath, "web-telemetry-path", "/metrics", "path of the metrics endpoint")
+	flag.BoolVar(&readyAllowLagging, "ready-allow-lagging", false, "report followers that have not caught up as ready")
+	flag.DurationVar(&webTimeouts.ReadHeader, "web-read-header-timeout", 5*time.Second, "time to read the headers of a request")
+	flag.DurationVar(&webTimeouts.Read, "web-read-timeout", 10*time.Second, "time to read a request")
+	flag.DurationVar(&webTimeouts.Write, "web-write-timeout", 30*time.Second, "time to serve a request, must exceed the scrape timeout")
+	flag.DurationVar(&webTimeouts.Idle, "web-idle-timeout", 120*time.Second, "time an idle keep-alive connection is kept open")
+	flag.DurationVar(&shutdownTimeout, "web-shutdown-timeout", 5*time.Second, "time in-flight requests have to complete on shutdown")
+	flag.BoolVar(&webEnableLifecycle, "web-enable-lifecycle", false, "reload the configuration file on POST /-/reload")
+	flag.StringVar(&routePrefix, "web-route-prefix", "", "path prefix of every endpoint, for reverse proxies")
+	flag.BoolVar(&webProxyProtocol, "web-proxy-protocol", false, "expect a proxy protocol header on every http connection")
+	flag.IntVar(&maxScrapesInFlight, "web-max-requests-in-flight", 0, "maximum number of concurrent scrapes, 0 for no limit")
+	flag.Float64Var(&rateLimitOpts.Rate, "web-rate-limit", 0, "scrapes per second allowed per client address, 0 for no limit")
+	flag.IntVar(&rateLimitOpts.Burst, "web-rate-burst", 5, "scrapes a client may make at once beyond --web-rate-limit")
+	flag.BoolVar(&webAccessLog, "web-access-log", false, "log every http request")
+	flag.StringVar(&selfTelemetryAddr, "web-self-telemetry-addr", "", "address serving the metrics of the exporter itself, apart from tile38's")
+	flag.StringVar(&httpSocketMode, "http-socket-mode", "0660", "file mode of the unix socket of the http server")
+	flag.StringVar(&webTLSOpts.CertFile, "web-tls-cert-file", "", "certificate of the https server, enables https")
+	flag.StringVar(&webTLSOpts.KeyFile, "web-tls-key-file", "", "key of the https server certificate")
+	flag.StringVar(&webTLSOpts.MinVersion, "web-tls-min-version", "1.2", "minimum tls version of the https server, 1.2 or 1.3")
+	flag.StringVar(&webTLSOpts.ClientCA, "web-tls-client-ca", "", "ca certificates verifying the client certificates required from scrapers")
+	flag.StringVar(&webTLSOpts.AllowedCN, "web-tls-allowed-cn", "", "comma separated common names of the client certificates allowed to scrape")
+	flag.BoolVar(&webTLSOpts.ExemptHealth, "web-tls-exempt-health", false, "serve the health endpoints without a client certificate")
+	flag.StringVar(&webAuthOpts.UsersFile, "web-basic-auth-users", "", "yaml file of the users allowed to scrape, with bcrypt hashed passwords")
+	flag.StringVar(&webConfigFile, "web.config.file", "", "yaml web configuration file in the format of the prometheus exporter toolkit")
+	flag.StringVar(&webAuthOpts.Token, "web-bearer-token", "", "bearer token required from scrapers")
+	flag.StringVar(&webAuthOpts.TokenFile, "web-bearer-token-file", "", "file of the bearer token required from scrapers")
+	flag.BoolVar(&webAuthOpts.ExemptHealth, "web-auth-exempt-health", false, "serve the health endpoints without basic auth or bearer token")
 	flag.StringVar(&namespace, "namespace", "", "metrics namespace")
+	flag.Var(&labelFlags, "label", "name=value label added to every series, may be repeated")
+	flag.BoolVar(&serviceManaged, "service-managed", false, "started by the service manager")
+	flag.StringVar(&pidFile, "pid-file", "", "write the process id to this file")
+	flag.BoolVar(&pidFileForce, "pid-file-force", false, "overwrite a pid file of a running process")
+	flag.StringVar(&runAs.User, "user", "", "user to run as after binding the listener")
+	flag.StringVar(&runAs.Group, "group", "", "group to run as after binding the listener")
+	flag.DurationVar(&hedgeAfter, "hedge-after", 0, "issue a second request when tile38 is slower than this")
+	flag.DurationVar(&slowScrapeThreshold, "slow-scrape-threshold", 0, "log a warning for collections slower than this")
+	flag.BoolVar(&warmBeforeReady, "warm-before-ready", false, "notify systemd of readiness after the first successful collection")
+	flag.BoolVar(&statChangesOpts.Enabled, "log-stat-changes", false, "log the stats that changed after each background collection")
+	flag.Float64Var(&statChangesOpts.Threshold, "log-stat-changes-threshold", 0, "minimum relative change of logged stats")
+	flag.IntVar(&statChangesOpts.Max, "log-stat-changes-max", 20, "maximum number of stat changes logged per collection")
+	flag.BoolVar(&topOpts.Enabled, "top", false, "show a refreshing overview in the terminal instead of serving metrics")
+	flag.DurationVar(&topOpts.Interval, "top-interval", 2*time.Second, "refresh interval of --top")
+	flag.StringVar(&heartbeatOpts.URL, "heartbeat-url", "", "url to ping after successful collections")
+	flag.StringVar(&heartbeatOpts.FailURL, "heartbeat-fail-url", "", "url to ping after failed collections")
+	flag.StringVar(&heartbeatOpts.Method, "heartbeat-method", "GET", "http method of heartbeat pings")
+	flag.DurationVar(&heartbeatOpts.Interval, "heartbeat-interval", time.Minute, "minimum interval between heartbeat pings")
+	flag.StringVar(&webhookOpts.URL, "notify-webhook-url", "", "url to post target up/down transitions to")
+	flag.DurationVar(&webhookOpts.Debounce, "notify-debounce", 30*time.Second, "time a target must stay up or down to be notified")
+	flag.IntVar(&webhookOpts.Retries, "notify-retries", 3, "number of retries of failed webhook deliveries")
+	flag.StringVar(&graphiteOpts.Addr, "graphite-addr", "", "push the metrics to the graphite plaintext listener at this address")
+	flag.DurationVar(&graphiteOpts.Interval, "graphite-interval", time.Minute, "interval of graphite pushes")
+	flag.StringVar(&logLevel, "log-level", "info", "log level, info or debug")
+	flag.StringVar(&nativeMetricsURL, "merge-native-metrics-url", "", "url of tile38's own metrics to merge into the output")
+	flag.StringVar(&configPath, "config", "", "path to yaml configuration file")
+	flag.DurationVar(&scrapeTimeout, "scrape-timeout", 0, "time a scrape may take when prometheus sends no scrape timeout")
+	flag.DurationVar(&collectInterval, "collect-interval", 0, "collect in the background on this interval")
+	flag.BoolVar(&noStagger, "collect-no-stagger", false, "refresh all targets at once instead of spreading them over the interval")
+	flag.BoolVar(&metricsTimestamps, "metrics-timestamps", false, "stamp the samples of the background collection with their collection time")
+	flag.BoolVar(&streamOpts.Enabled, "web-stream", false, "serve live metric values on /stream")
+	flag.BoolVar(&jsonMetrics, "web-json-metrics", false, "serve the metrics as JSON on /metrics.json, for debugging")
+	flag.IntVar(&streamOpts.MaxClients, "web-stream-max-clients", 10, "maximum number of /stream clients")
+	flag.BoolVar(&reduceLoadDuringRewrite, "reduce-load-during-rewrite", false, "skip expensive collectors while tile38 rewrites its aof")
+	flag.BoolVar(&leaderOpts.Enabled, "expensive-leader-lock", false, "only run expensive collectors on the replica holding a lock in tile38")
+	flag.StringVar(&leaderOpts.Key, "leader-lock-key", "tile38-prometheus", "collection key of the leader lock")
+	flag.StringVar(&leaderOpts.ID, "leader-lock-id", defaultLeaderID(), "identity of this replica in the leader lock")
+	flag.DurationVar(&leaderOpts.TTL, "leader-lock-ttl", 30*time.Second, "expiration of the leader lock")
+	flag.BoolVar(&omitZeroDynamic, "omit-zero-dynamic-series", false, "omit the per-collection series whose value is zero")
+	flag.BoolVar(&missingAsNaN, "missing-as-nan", false, "export the stats missing from tile38's replies as NaN instead of leaving them out")
+	flag.BoolVar(&strictMode, "strict", false, "fail scrapes for which stats are missing from tile38's replies")
+	flag.BoolVar(&validateOutput, "validate-output", false, "parse every rendered metrics document back and fail the scrapes prometheus would refuse")
+	flag.StringVar(&namingMode, "compat", "legacy", "naming of the metrics, legacy, prometheus or client_golang")
+	flag.BoolVar(&keepLegacyNames, "compat-keep-legacy-names", false, "also export the metrics renamed by --compat under their legacy names")
+	flag.BoolVar(&collectionsOpts.Enabled, "collections", false, "export per-collection metrics")
+	flag.StringVar(&collectionsOpts.Match, "collections-match", "*", "pattern of collections to export")
+	flag.IntVar(&collectionsOpts.Max, "collections-max", 1000, "maximum number of collections to export")
+	flag.BoolVar(&collectionsOpts.Bounds, "collections-bounds", false, "export the bounding box area of each collection")
+	flag.BoolVar(&collectionsOpts.BoundsVerbose, "collections-bounds-verbose", false, "also export the raw bounding box of each collection")
 
 	flag.Usage = func() {
 		fmt.Printf("Usage: ./tile38-prometheus [--tile38-addr addr] [options]\n")
+		fmt.Printf("       ./tile38-prometheus service <install|uninstall|start|stop|restart> [options]\n")
+		fmt.Printf("       ./tile38-prometheus generate-config [--job-name name] [--targets addrs] [options]\n")
+		fmt.Printf("       ./tile38-prometheus bench [--duration d] [--concurrency n] [--collectors list]\n")
+		fmt.Printf("                                 [--output table|json] [options]\n")
+		fmt.Printf("       ./tile38-prometheus check [options]\n")
 		fmt.Printf("\n")
 		fmt.Printf("Options:\n")
 		fmt.Printf("    --tile38-auth auth  : Tile38 AUTH password (default \"\")\n")
+		fmt.Printf("                          May be repeated; passwords are tried in order\n")
+		fmt.Printf("    --tile38-auth-file path : File of Tile38 AUTH passwords, one per line, read again\n")
+		fmt.Printf("                          when none is accepted; takes precedence over --tile38-auth\n")
 		fmt.Printf("    --tile38-addr addr  : Address to Tile38 instance (default \":9851\")\n")
-		fmt.Printf("    --http-addr addr    : HTTP server listening address (default \":8080\")\n")
+		fmt.Printf("                          or a URI: tile38://[:password@]host[:port], redis://,\n")
+		fmt.Printf("                          rediss:// for TLS, http[s]:// for the HTTP transport,\n")
+		fmt.Printf("                          ws[s]:// for WebSockets, or unix:///path/to/socket\n")
+		fmt.Printf("                          Multiple instances may be comma separated\n")
+		fmt.Printf("    --shadow-addr addr  : Address to a Tile38 instance to compare against the primary,\n")
+		fmt.Printf("                          exporting the differences (default \"\", disabled)\n")
+		fmt.Printf("    --tile38-tls        : Connect to Tile38 over TLS (default false)\n")
+		fmt.Printf("    --tile38-tls-ca-file path : CA certificates verifying Tile38, implies --tile38-tls\n")
+		fmt.Printf("                          (default \"\", the system roots)\n")
+		fmt.Printf("    --tile38-tls-skip-verify : Do not verify the Tile38 certificate, implies\n")
+		fmt.Printf("                          --tile38-tls (default false)\n")
+		fmt.Printf("    --tile38-tls-cert-file path : Client certificate presented to Tile38, implies\n")
+		fmt.Printf("                          --tile38-tls; reloaded on SIGHUP (default \"\")\n")
+		fmt.Printf("    --tile38-tls-key-file path : Key of the client certificate (default \"\")\n")
+		fmt.Printf("    --tile38-proxy uri  : SOCKS5 proxy through which Tile38 is reached,\n")
+		fmt.Printf("                          socks5://[user:password@]host:port (default \"\", direct)\n")
+		fmt.Printf("    --tile38-connect-timeout d : Timeout of connecting to Tile38 (default 5s)\n")
+		fmt.Printf("    --tile38-timeout d  : Timeout of reading and writing Tile38 commands (default 10s)\n")
+		fmt.Printf("    --tile38-keepalive d : Period of TCP keepalive probes on Tile38 connections\n")
+		fmt.Printf("                          (default 30s, 0 to disable)\n")
+		fmt.Printf("    --dial-backoff-after n : Consecutive failures to connect to a Tile38 instance\n")
+		fmt.Printf("                          before backing off (default 3, 0 to disable)\n")
+		fmt.Printf("    --dial-backoff-max d : Longest time connecting to a failing Tile38 instance\n")
+		fmt.Printf("                          is not attempted (default 1m)\n")
+		fmt.Printf("    --breaker-failures n : Consecutive scrape failures of a Tile38 instance opening its\n")
+		fmt.Printf("                          circuit breaker (default 0, disabled)\n")
+		fmt.Printf("    --breaker-cooldown d : Time the circuit breaker stays open before probing the\n")
+		fmt.Printf("                          Tile38 instance again (default 30s)\n")
+		fmt.Printf("    --pipeline          : Send the independent commands of a collector at once\n")
+		fmt.Printf("                          (default true, --pipeline=false to disable)\n")
+		fmt.Printf("    --pool-max-idle n   : Maximum number of idle connections per Tile38 instance (default 5)\n")
+		fmt.Printf("    --pool-max-active n : Maximum number of connections per Tile38 instance\n")
+		fmt.Printf("                          (default 0, no limit)\n")
+		fmt.Printf("    --pool-idle-timeout d : Close connections idle for this long (default 0, keep them)\n")
+		fmt.Printf("    --pool-wait         : Wait for a connection when --pool-max-active is reached,\n")
+		fmt.Printf("                          instead of failing the scrape (default false)\n")
+		fmt.Printf("    --pool-max-conn-lifetime d : Close connections older than this instead of reusing\n")
+		fmt.Printf("                          them (default 0, keep them)\n")
+		fmt.Printf("    --pool-test-idle d  : PING connections idle for longer than this before reusing\n")
+		fmt.Printf("                          them (default 30s, 0 to disable)\n")
+		fmt.Printf("    --shadow-auth auth  : AUTH password of the shadow instance (default \"\")\n")
+		fmt.Printf("    --hedge-after d     : Issue a second request on another connection when Tile38\n")
+		fmt.Printf("                          has not replied within this duration (default 0, disabled)\n")
+		fmt.Printf("    --slow-scrape-threshold d : Log a warning for collections slower than this\n")
+		fmt.Printf("                          (default 0, disabled)\n")
+		fmt.Printf("    --shard-index n     : Shard of the targets collected by this exporter, unless\n")
+		fmt.Printf("                          set by sharding in --config (default 0)\n")
+		fmt.Printf("    --shard-count n     : Number of exporters sharing the targets, unless set by\n")
+		fmt.Printf("                          sharding in --config (default 1)\n")
+		fmt.Printf("    --http-addr addrs   : Comma separated HTTP server listening addresses, each\n")
+		fmt.Printf("                          host:port or unix:///path/to/socket (default \":8080\")\n")
+		fmt.Printf("    --web-telemetry-path path : Path of the metrics endpoint, and of the CSV one\n")
+		fmt.Printf("                          with .csv appended (default /metrics)\n")
+		fmt.Printf("    --ready-allow-lagging : Report followers that have not caught up with their\n")
+		fmt.Printf("                          leader as ready on /-/ready (default false)\n")
+		fmt.Printf("    --web-read-header-timeout d : Time to read the headers of a request (default 5s)\n")
+		fmt.Printf("    --web-read-timeout d : Time to read a request (default 10s)\n")
+		fmt.Printf("    --web-write-timeout d : Time to serve a request; scrapes time out before it\n")
+		fmt.Printf("                          (default 30s)\n")
+		fmt.Printf("    --web-idle-timeout d : Time an idle keep-alive connection is kept open\n")
+		fmt.Printf("                          (default 2m)\n")
+		fmt.Printf("    --web-shutdown-timeout d : Time in-flight requests have to complete on shutdown,\n")
+		fmt.Printf("                          before exiting with an error (default 5s)\n")
+		fmt.Printf("    --web-enable-lifecycle : Reload the configuration file on POST /-/reload\n")
+		fmt.Printf("                          (default false)\n")
+		fmt.Printf("    --web-route-prefix path : Path prefix of every endpoint, for reverse proxies\n")
+		fmt.Printf("                          serving the exporter under a path (default \"\")\n")
+		fmt.Printf("    --web-proxy-protocol : Expect a PROXY protocol v1 or v2 header on every HTTP\n")
+		fmt.Printf("                          connection, from a load balancer (default false)\n")
+		fmt.Printf("    --web-max-requests-in-flight n : Maximum number of concurrent scrapes; others\n")
+		fmt.Printf("                          answer 503 (default 0, no limit)\n")
+		fmt.Printf("    --web-rate-limit r  : Scrapes per second allowed per client address on the\n")
+		fmt.Printf("                          metrics endpoint; others answer 429 (default 0, no limit)\n")
+		fmt.Printf("    --web-rate-burst n  : Scrapes a client may make at once (default 5)\n")
+		fmt.Printf("    --web-access-log    : Log the method, path, status, size, duration and client\n")
+		fmt.Printf("                          of every HTTP request (default false)\n")
+		fmt.Printf("    --web-self-telemetry-addr addr : Serve the metrics of the exporter itself,\n")
+		fmt.Printf("                          such as its pools and runtime, on this address rather\n")
+		fmt.Printf("                          than with the Tile38 metrics (default \"\", merged)\n")
+		fmt.Printf("    --http-socket-mode mode : File mode of the unix socket of the HTTP server\n")
+		fmt.Printf("                          (default 0660)\n")
+		fmt.Printf("    --web-tls-cert-file path : Certificate of the HTTPS server; serves HTTPS when set\n")
+		fmt.Printf("                          (default \"\", plain HTTP)\n")
+		fmt.Printf("    --web-tls-key-file path : Key of the HTTPS server certificate (default \"\")\n")
+		fmt.Printf("    --web-tls-min-version v : Minimum TLS version of the HTTPS server, 1.2 or 1.3\n")
+		fmt.Printf("                          (default 1.2)\n")
+		fmt.Printf("    --web-tls-client-ca path : CA certificates verifying the client certificates\n")
+		fmt.Printf("                          required from scrapers (default \"\", none required)\n")
+		fmt.Printf("    --web-tls-allowed-cn names : Comma separated common names of the client certificates\n")
+		fmt.Printf("                          allowed to scrape (default \"\", any)\n")
+		fmt.Printf("    --web-tls-exempt-health : Serve the health endpoints without a client\n")
+		fmt.Printf("                          certificate (default false)\n")
+		fmt.Printf("    --web-basic-auth-users path : YAML file of the users allowed to scrape, with bcrypt\n")
+		fmt.Printf("                          hashed passwords (default \"\", no basic auth)\n")
+		fmt.Printf("    --web.config.file path : Web configuration file in the format of the Prometheus\n")
+		fmt.Printf("                          exporter toolkit, in place of the --web-tls-* flags and\n")
+		fmt.Printf("                          --web-basic-auth-users (default \"\")\n")
+		fmt.Printf("    --web-bearer-token token : Bearer token required from scrapers (default \"\")\n")
+		fmt.Printf("    --web-bearer-token-file path : File of the bearer token required from scrapers,\n")
+		fmt.Printf("                          read again on SIGHUP (default \"\")\n")
+		fmt.Printf("    --web-auth-exempt-health : Serve the health endpoints without basic auth or\n")
+		fmt.Printf("                          bearer token (default false)\n")
 		fmt.Printf("    --namespace namespace    : optional metrics namespace (default \"\")\n")
+		fmt.Printf("    --label name=value       : Label added to every series, may be repeated\n")
+		fmt.Printf("    --top               : Show a refreshing overview of the Tile38 instances in the\n")
+		fmt.Printf("                          terminal instead of serving metrics (default false)\n")
+		fmt.Printf("    --top-interval d    : Refresh interval of --top (default 2s)\n")
+		fmt.Printf("    --heartbeat-url url : Ping this URL after successful collections (default \"\")\n")
+		fmt.Printf("    --heartbeat-fail-url url : Ping this URL after failed collections (default \"\")\n")
+		fmt.Printf("    --heartbeat-method m : HTTP method of heartbeat pings, GET or POST (default \"GET\")\n")
+		fmt.Printf("    --heartbeat-interval d : Minimum interval between heartbeat pings (default 1m)\n")
+		fmt.Printf("    --notify-webhook-url url : POST a JSON event to this URL when a Tile38 instance\n")
+		fmt.Printf("                          becomes reachable or unreachable (default \"\")\n")
+		fmt.Printf("    --notify-debounce d : Time an instance must stay up or down to be notified (default 30s)\n")
+		fmt.Printf("    --notify-retries n  : Number of retries of failed webhook deliveries (default 3)\n")
+		fmt.Printf("    --graphite-addr addr : Push the metrics to the Graphite plaintext listener at this\n")
+		fmt.Printf("                          address, such as carbon:2003 (default \"\", disabled)\n")
+		fmt.Printf("    --graphite-interval d : Interval of Graphite pushes (default 1m)\n")
+		fmt.Printf("    --log-level level   : Log level, info or debug (default \"info\")\n")
+		fmt.Printf("    --merge-native-metrics-url url : Merge the metrics served by Tile38 itself at this URL\n")
+		fmt.Printf("                          into the output (default \"\")\n")
+		fmt.Printf("    --config path       : Path to YAML configuration file (default \"\")\n")
+		fmt.Printf("    --user name         : User to run as after binding the listener (default \"\")\n")
+		fmt.Printf("    --group name        : Group to run as after binding the listener (default \"\")\n")
+		fmt.Printf("    --pid-file path     : Write the process id to this file (default \"\")\n")
+		fmt.Printf("    --pid-file-force    : Overwrite a pid file of a running process (default false)\n")
+		fmt.Printf("    --warm-before-ready : Notify systemd of readiness only after the first successful\n")
+		fmt.Printf("                          collection (default false)\n")
+		fmt.Printf("    --scrape-timeout d  : Time a scrape may take when Prometheus does not send its\n")
+		fmt.Printf("                          scrape timeout (default 0, no limit)\n")
+		fmt.Printf("    --collect-interval d : Collect in the background on this interval and\n")
+		fmt.Printf("                          serve the latest results (default 0, collect per scrape)\n")
+		fmt.Printf("    --collect-no-stagger : Refresh all targets at once instead of spreading them\n")
+		fmt.Printf("                          over the collect interval (default false)\n")
+		fmt.Printf("    --metrics-timestamps : Stamp the samples with the time they were collected,\n")
+		fmt.Printf("                          requires --collect-interval (default false)\n")
+		fmt.Printf("    --log-stat-changes  : Log the stats that changed after each background collection,\n")
+		fmt.Printf("                          requires --collect-interval (default false)\n")
+		fmt.Printf("    --log-stat-changes-threshold r : Minimum relative change of logged stats,\n")
+		fmt.Printf("                          e.g. 0.1 for 10%% (default 0, any change)\n")
+		fmt.Printf("    --log-stat-changes-max n : Maximum number of stat changes logged per\n")
+		fmt.Printf("                          collection (default 20)\n")
+		fmt.Printf("    --web-stream        : Serve live metric values as Server-Sent Events on\n")
+		fmt.Printf("                          /stream, requires --collect-interval (default false)\n")
+		fmt.Printf("    --web-stream-max-clients n : Maximum number of /stream clients (default 10)\n")
+		fmt.Printf("    --web-json-metrics  : Serve the metrics as JSON on /metrics.json, for debugging\n")
+		fmt.Printf("                          (default false)\n")
+		fmt.Printf("    --validate-output   : Parse every rendered metrics document back with the parsers\n")
+		fmt.Printf("                          of Prometheus, answering 500 to the scrapes it would refuse,\n")
+		fmt.Printf("                          for debugging (default false)\n")
+		fmt.Printf("\n")
+		fmt.Printf("Discovery options:\n")
+		fmt.Printf("    --consul-addr addr     : Discover Tile38 instances from this Consul agent,\n")
+		fmt.Printf("                             instead of --tile38-addr (default \"\")\n")
+		fmt.Printf("    --consul-service name  : Consul service of the Tile38 instances (default \"tile38\")\n")
+		fmt.Printf("    --consul-tag tag       : Only discover services with this tag (default \"\")\n")
+		fmt.Printf("    --consul-dc dc         : Consul datacenter to discover services in (default \"\")\n")
+		fmt.Printf("    --kubernetes-sd                 : Discover Tile38 pods from the Kubernetes API of the\n")
+		fmt.Printf("                                      cluster, instead of --tile38-addr (default false)\n")
+		fmt.Printf("    --kubernetes-selector selector  : Label selector of the Tile38 pods (default \"\")\n")
+		fmt.Printf("    --kubernetes-namespace ns       : Namespace of the Tile38 pods (default: the exporter's own)\n")
+		fmt.Printf("    --kubernetes-port port          : Name or number of the Tile38 port (default \"tile38\")\n")
+		fmt.Printf("    --kubernetes-resync d           : Interval of listing all pods again (default 5m)\n")
+		fmt.Printf("\n")
+		fmt.Printf("Collector options:\n")
+		fmt.Printf("    --reduce-load-during-rewrite : Skip the per-collection and query collectors while\n")
+		fmt.Printf("                                   Tile38 rewrites its AOF (default false)\n")
+		fmt.Printf("    --expensive-leader-lock      : Only run the per-collection and query collectors on the\n")
+		fmt.Printf("                                   replica holding a lock stored in Tile38 (default false)\n")
+		fmt.Printf("    --leader-lock-key key        : Collection key of the lock (default \"tile38-prometheus\")\n")
+		fmt.Printf("    --leader-lock-id id          : Identity of this replica (default host:pid)\n")
+		fmt.Printf("    --leader-lock-ttl d          : Expiration of the lock, renewed at a third of it (default 30s)\n")
+		fmt.Printf("    --omit-zero-dynamic-series   : Omit the per-collection series whose value is zero,\n")
+		fmt.Printf("                                   such as those of empty collections (default false)\n")
+		fmt.Printf("    --missing-as-nan             : Export the stats missing from the replies of Tile38,\n")
+		fmt.Printf("                                   such as those of older versions, as NaN instead of\n")
+		fmt.Printf("                                   leaving them out (default false)\n")
+		fmt.Printf("    --strict                     : Answer 500 to scrapes for which stats are missing from\n")
+		fmt.Printf("                                   the replies of Tile38, listing them (default false)\n")
+		fmt.Printf("    --compat mode                : Naming of the metrics: legacy, prometheus to follow the\n")
+		fmt.Printf("                                   Prometheus naming conventions, or client_golang to also\n")
+		fmt.Printf("                                   name the Go stats go_memstats_* (default \"legacy\")\n")
+		fmt.Printf("    --compat-keep-legacy-names   : Also export the metrics renamed by --compat\n")
+		fmt.Printf("                                   under their legacy names, while migrating (default false)\n")
+		fmt.Printf("    --collections                : Export per-collection metrics (default false)\n")
+		fmt.Printf("    --collections-match pattern  : Pattern of collections to export (default \"*\")\n")
+		fmt.Printf("    --collections-max n          : Maximum number of collections to export (default 1000)\n")
+		fmt.Printf("    --collections-bounds         : Export the bounding box area of each collection (default false)\n")
+		fmt.Printf("    --collections-bounds-verbose : Also export the raw bounding box of each collection (default false)\n")
 		fmt.Printf("\n")
 		fmt.Printf("Environment variables:\n")
 		fmt.Printf("    TILE38_AUTH=<auth>\n")
+		fmt.Printf("    TILE38_AUTH_FILE=<path>\n")
 		fmt.Printf("    TILE38_ADDR=<addr>\n")
+		fmt.Printf("    CONSUL_HTTP_TOKEN=<token>\n")
 		fmt.Printf("\n")
 		fmt.Printf("Examples:\n")
 		fmt.Printf("    ./tile38-prometheus --tile38-addr 10.43.12.45:9851\n")
 		fmt.Printf("    TILE38_ADDR=10.43.12.45:9851 ./tile38-prometheus\n")
+		fmt.Printf("    ./tile38-prometheus --tile38-addr 10.43.12.45:9851,10.43.12.46:9851\n")
+		fmt.Printf("    ./tile38-prometheus --top --tile38-addr 10.43.12.45:9851\n")
+		fmt.Printf("    ./tile38-prometheus service install --tile38-addr 10.43.12.45:9851\n")
+		fmt.Printf("    ./tile38-prometheus generate-config --targets exporter-1:8080,exporter-2:8080\n")
+		fmt.Printf("    ./tile38-prometheus bench --duration 60s --concurrency 3 --collectors server,collections\n")
+		fmt.Printf("    ./tile38-prometheus check --tile38-addr 10.43.12.45:9851 --compat prometheus\n")
 		fmt.Printf("\n")
 	}
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		serviceCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "generate-config" {
+		generateConfig(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		benchFlags()
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		checkMode = true
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	}
 	flag.Parse()
 	if v := os.Getenv("TILE38_AUTH"); v != "" {
-		tile38Auth = v
+		tile38Auth = stringList{v}
+	}
+	if v := os.Getenv("TILE38_AUTH_FILE"); v != "" {
+		tile38AuthFile = v
+	}
+	if tile38AuthFile != "" && len(tile38Auth) > 0 {
+		log.Printf("level=warn msg=\"both a password and a password file are set, using the file\"")
+		tile38Auth = nil
+	}
+	switch logLevel {
+	case "info":
+	case "debug":
+		logDebug = true
+	default:
+		log.Fatalf("--log-level must be info or debug")
+	}
+	// The configuration is loaded first, as it applies to the targets
+	if configPath != "" {
+		c, err := loadConfig(configPath)
+		if err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		setConfig(c)
+	}
+	if err := checkWebTimeouts(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := checkRunAs(runAs.User, runAs.Group); err != nil {
+		log.Fatalf("--user/--group: %v", err)
+	}
+	if shutdownTimeout <= 0 {
+		log.Fatalf("--web-shutdown-timeout must be positive")
+	}
+	if maxScrapesInFlight < 0 {
+		log.Fatalf("--web-max-requests-in-flight must not be negative")
+	}
+	if rateLimitOpts.Rate < 0 || rateLimitOpts.Burst < 1 {
+		log.Fatalf("--web-rate-limit must not be negative and --web-rate-burst must be positive")
+	}
+	if timeouts.KeepAlive < 0 {
+		log.Fatalf("--tile38-keepalive must not be negative")
+	}
+	if breakerOpts.Failures < 0 || breakerOpts.Cooldown < 0 {
+		log.Fatalf("--breaker-failures and --breaker-cooldown must not be negative")
+	}
+	if backoffOpts.After < 0 || backoffOpts.Max < backoffBase {
+		log.Fatalf("--dial-backoff-after must not be negative, and --dial-backoff-max must be at least %s", backoffBase)
+	}
+	if poolOpts.MaxIdle < 0 || poolOpts.MaxActive < 0 || poolOpts.IdleTimeout < 0 ||
+		poolOpts.MaxConnLifetime < 0 || poolOpts.TestIdle < 0 {
+		log.Fatalf("--pool-max-idle, --pool-max-active, --pool-idle-timeout, --pool-max-conn-lifetime and --pool-test-idle must not be negative")
+	}
+	if tile38ProxyURI != "" {
+		u, err := parseProxy(tile38ProxyURI)
+		if err != nil {
+			log.Fatalf("--tile38-proxy: %v", err)
+		}
+		tile38Proxy = u
+	}
+	if err := checkTelemetryPath(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := checkNamingMode(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := checkNamespace(namespace); err != nil {
+		log.Fatalf("%v", err)
+	}
+	labels, err := parseLabelFlags(labelFlags)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	flagLabels = labels
+	routePrefix = normalizeRoutePrefix(routePrefix)
+	if err := applyWebConfig(); err != nil {
+		log.Fatalf("web config: %v", err)
+	}
+	webTLS, err := newWebTLSConfig()
+	if err != nil {
+		log.Fatalf("web tls: %v", err)
+	}
+	if err := setupWebAuth(); err != nil {
+		log.Fatalf("web auth: %v", err)
+	}
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		log.Fatalf("tls: %v", err)
+	}
+	tile38TLS = tlsConfig
+	if tile38ClientCert != nil || webServerCert != nil || bearerToken != nil {
+		go reloadOnHangup()
+	}
+	creds, err := newCredentials(tile38Auth, tile38AuthFile)
+	if err != nil {
+		log.Fatalf("auth file: %v", err)
 	}
 	if v := os.Getenv("TILE38_ADDR"); v != "" {
 		tile38Addr = v
 	}
 
-	// Create the Tile38 connection pooler, which is responsible for
-	// maintaining stable connections to the Tile38 server.
-	pool = redis.NewPool(func() (redis.Conn, error) {
-		conn, err := redis.Dial("tcp", tile38Addr)
+	if err := validateShard(shardOpts.Index, shardOpts.Count); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if consulOpts.Addr != "" && kubernetesOpts.Enabled {
+		log.Fatalf("--consul-addr and --kubernetes-sd are mutually exclusive")
+	}
+	if discoveryEnabled() {
+		// The targets are maintained by discovery
+		discoveryCreds = creds
+		if consulOpts.Addr != "" {
+			go watchConsul()
+		} else {
+			if kubernetesOpts.Resync <= 0 {
+				log.Fatalf("--kubernetes-resync must be positive")
+			}
+			go watchKubernetes()
+		}
+	} else {
+		// Create a target for every Tile38 server, each with its own
+		// connection pooler.
+		var all []*target
+		for _, raw := range parseAddrs(tile38Addr) {
+			ta, err := parseTargetAddr(raw)
+			if err != nil {
+				log.Fatalf("--tile38-addr: %v", err)
+			}
+			all = append(all, ta.newTarget(creds))
+		}
+		if len(all) == 0 {
+			log.Fatalf("no tile38 address provided")
+		}
+		setTargets(all)
+	}
+	if shadowAddr != "" {
+		if discoveryEnabled() || len(currentTargets()) != 1 {
+			log.Fatalf("--shadow-addr requires a single --tile38-addr")
+		}
+		ta, err := parseTargetAddr(shadowAddr)
 		if err != nil {
-			return nil, err
+			log.Fatalf("--shadow-addr: %v", err)
+		}
+		shadowCreds, _ := newCredentials([]string{shadowAuth}, "")
+		shadow = ta.newTarget(shadowCreds)
+	}
+	if collectionsOpts.Enabled {
+		collectors = append(collectors, collector{"collections", collectCollections})
+	}
+	// With a configuration file, queries and strings may be added by a
+	// reload
+	if configPath != "" {
+		collectors = append(collectors, collector{"queries", collectQueries},
+			collector{"strings", collectStrings})
+		if err := currentConfig().checkMetrics(namespace); err != nil {
+			log.Fatalf("config: %s: %v", configPath, err)
 		}
-		if _, err := do(conn, "OUTPUT", "json"); err != nil {
-			conn.Close()
-			return nil, err
+	}
+	if benchOpts.Enabled {
+		if discoveryEnabled() || len(currentTargets()) != 1 {
+			log.Fatalf("bench requires a single --tile38-addr")
 		}
-		if tile38Auth != "" {
-			if _, err := do(conn, "auth", tile38Auth); err != nil {
-				conn.Close()
-				return nil, err
-			}
+		runBench(currentTargets()[0])
+		return
+	}
+	if checkMode {
+		if discoveryEnabled() {
+			log.Fatalf("check requires --tile38-addr")
 		}
-		return conn, nil
-	}, 5)
+		os.Exit(runCheck(namespace))
+	}
+	if topOpts.Enabled {
+		if topOpts.Interval <= 0 {
+			log.Fatalf("--top-interval must be positive")
+		}
+		runTop()
+		return
+	}
+	if streamOpts.Enabled {
+		if collectInterval <= 0 {
+			log.Fatalf("--web-stream requires --collect-interval")
+		}
+		stream = newStreamHub(namespace)
+		onCollect = append(onCollect, stream.publish)
+		http.HandleFunc(route("/stream"), handleStream)
+	}
+	if m := heartbeatOpts.Method; m != "GET" && m != "POST" {
+		log.Fatalf("--heartbeat-method must be GET or POST")
+	}
+	if leaderOpts.Enabled {
+		if leaderOpts.TTL < 3*time.Second {
+			log.Fatalf("--leader-lock-ttl must be at least 3s")
+		}
+		go leaderLoop()
+	}
+	if metricsTimestamps && collectInterval <= 0 {
+		log.Fatalf("--metrics-timestamps requires --collect-interval")
+	}
+	if statChangesOpts.Enabled {
+		if collectInterval <= 0 {
+			log.Fatalf("--log-stat-changes requires --collect-interval")
+		}
+		onCollect = append(onCollect, newStatLogger().logChanges)
+	}
+	if collectInterval > 0 {
+		go collectLoop()
+	}
+	if graphiteOpts.Addr != "" {
+		if graphiteOpts.Interval <= 0 {
+			log.Fatalf("--graphite-interval must be positive")
+		}
+		go graphiteLoop(namespace)
+	}
 
 	// create an http HandleFunc that retrieves statistics from Tile38
 	// and produces a valid prometheus metrics output.
-	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc(route(telemetryPath), getOrHead(rateLimit(limitInFlight(func(w http.ResponseWriter, r *http.Request) {
 		handle(w, r, namespace)
-	})
+	}))))
+	http.HandleFunc(route(telemetryPath+".csv"), getOrHead(limitInFlight(func(w http.ResponseWriter, r *http.Request) {
+		handleCSV(w, r, namespace)
+	})))
+	if jsonMetrics {
+		http.HandleFunc(route(telemetryPath+".json"), getOrHead(limitInFlight(func(w http.ResponseWriter, r *http.Request) {
+			handleJSON(w, r, namespace)
+		})))
+	}
+	http.HandleFunc(route("/metadata"), getOrHead(func(w http.ResponseWriter, r *http.Request) {
+		handleMetadata(w, r, namespace)
+	}))
+	http.HandleFunc(route("/status"), handleStatus)
+	http.HandleFunc(route("/-/healthy"), handleHealthy)
+	http.HandleFunc(route("/-/ready"), handleReady)
+	if webEnableLifecycle {
+		http.HandleFunc(route("/-/reload"), handleReload)
+	}
+	http.HandleFunc(route("/"), handleLanding)
 
 	go func() {
 		time.Sleep(time.Second)
-		log.Printf("Server started at %v", httpAddr)
-		log.Printf("Pointing to Tile38 server at %v", tile38Addr)
+		if webTLS != nil {
+			log.Printf("Server started at %v over https, metrics at %s", strings.Join(parseAddrs(httpAddr), ", "), route(telemetryPath))
+		} else {
+			log.Printf("Server started at %v, metrics at %s", strings.Join(parseAddrs(httpAddr), ", "), route(telemetryPath))
+		}
+		if selfTelemetryAddr != "" {
+			log.Printf("Exporter metrics at %s%s", selfTelemetryAddr, route(telemetryPath))
+		}
+		if consulOpts.Addr != "" {
+			log.Printf("Discovering Tile38 servers from Consul at %v", consulOpts.Addr)
+		} else if kubernetesOpts.Enabled {
+			log.Printf("Discovering Tile38 pods from Kubernetes")
+		} else {
+			var addrs []string
+			for _, raw := range parseAddrs(tile38Addr) {
+				ta, _ := parseTargetAddr(raw)
+				addrs = append(addrs, ta.String())
+			}
+			log.Printf("Pointing to Tile38 server at %v", strings.Join(addrs, ", "))
+		}
+		if tile38Proxy != nil {
+			log.Printf("Connecting to Tile38 through the proxy at %s", tile38Proxy.Redacted())
+		}
+		log.Printf("Connection pool: max-idle=%d max-active=%d idle-timeout=%s wait=%t max-conn-lifetime=%s test-idle=%s",
+			poolOpts.MaxIdle, poolOpts.MaxActive, poolOpts.IdleTimeout, poolOpts.Wait,
+			poolOpts.MaxConnLifetime, poolOpts.TestIdle)
 	}()
-	log.Printf("%s", http.ListenAndServe(httpAddr, nil))
+	var selfServer *http.Server
+	if selfTelemetryAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc(route(telemetryPath), getOrHead(func(w http.ResponseWriter, r *http.Request) {
+			handleSelfTelemetry(w, r, namespace)
+		}))
+		selfServer = newWebServer(selfTelemetryAddr, webTLS, requireClientCert(requireAuth(mux)))
+	}
+	runService(newWebServer(httpAddr, webTLS,
+		requireClientCert(requireAuth(http.DefaultServeMux))), selfServer)
 }
 
+// expositionContentType is the content type of the text exposition format.
+// Errors are sent as plain text.
+const expositionContentType = "text/plain; version=0.0.4; charset=utf-8"
+
 func handle(w http.ResponseWriter, rd *http.Request, n string) {
-	conn := pool.Get()
-	defer conn.Close()
+	start := time.Now()
+	ctx := rd.Context()
+	timeout := requestTimeout(rd)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	snap := getSnapshot(ctx)
+	if timeout > 0 && time.Since(start) >= timeout {
+		http.Error(w, fmt.Sprintf("scrape timed out after %s waiting on Tile38", timeout),
+			http.StatusGatewayTimeout)
+		return
+	}
+	results := snap.Results
 
-	out, err := do(conn, "SERVER", "ext")
-	if err != nil {
+	// Only fail when no target could be scraped at all; partial failures
+	// are reported through tile38_up.
+	var errs []string
+	for _, res := range results {
+		if res.Err != nil {
+			errs = append(errs, res.Err.Error())
+		}
+	}
+	if len(results) > 0 && len(errs) == len(results) {
+		http.Error(w, strings.Join(errs, "\n"), 500)
+		return
+	}
+	if err := checkStrict(snap); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
-	m := gjson.Get(out, "stats").Map()
-
 	// Produce a fully populated prometheus metrics output
-	var promOutput string
-	for _, metric := range metrics {
-		promOutput += metric.promString(get(m, metric.Key), n)
+	renderStart := time.Now()
+	sections, dropped := relabel(servedSections(snap))
+	relabelDropped.Set(float64(dropped))
+	out, contentType := renderNegotiated(rd, sections, n, snap.Time)
+	observePhase("render", time.Since(renderStart))
+	if err := checkRendered(out, contentType); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
 	}
 
 	// Return a fully populated prometheus document
-	w.Write([]byte(promOutput))
+	writeStart := time.Now()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
+	writeBody(w, rd, []byte(out))
+	observePhase("write", time.Since(writeStart))
+	lastScrapeDuration.Set(time.Since(start).Seconds())
+}
+
+// getOrHead answers 405 to the requests other than GET and HEAD
+func getOrHead(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
 }
 
 func do(conn redis.Conn, cmd string, args ...interface{}) (string, error) {
-	out, err := redis.String(conn.Do(cmd, args...))
+	return checkReply(conn.Do(cmd, args...))
+}
+
+// checkReply returns the JSON reply of a command, or the error of the reply
+func checkReply(reply interface{}, err error) (string, error) {
+	out, err := redis.String(reply, err)
 	if err != nil {
-		return "", err
+		return "", asAuthError(err)
 	}
 	if !gjson.Get(out, "ok").Bool() {
-		return "", errors.New(gjson.Get(out, "err").String())
+		return "", asAuthError(errors.New(gjson.Get(out, "err").String()))
 	}
 	return out, err
 }
@@ -181,31 +837,79 @@ func do(conn redis.Conn, cmd string, args ...interface{}) (string, error) {
 // metric is a type of struct used to store a metrics type, key and description
 type metric struct{ Type, Key, Desc string }
 
-// promString returns the prometheus string representation of the metric given
-// a float64 value
-func (m metric) promString(val float64, n string) string {
-	if len(n) > 0 {
-		return fmt.Sprintf("# HELP %s_%s %s\n", n, m.Key, m.Desc) +
-			fmt.Sprintf("# TYPE %s_%s %s\n", n, m.Key, m.Type) +
-			fmt.Sprintf("%s_%s %s\n", n, m.Key, strconv.FormatFloat(val, 'f', -1, 64))
-	}
-	return fmt.Sprintf("# HELP %s %s\n", m.Key, m.Desc) +
-		fmt.Sprintf("# TYPE %s %s\n", m.Key, m.Type) +
-		fmt.Sprintf("%s %s\n", m.Key, strconv.FormatFloat(val, 'f', -1, 64))
+// family returns the metric as a family with a single unlabeled sample
+func (m metric) family(val float64) *family {
+	return &family{Name: m.Key, Type: m.Type, Help: m.Desc,
+		Samples: []sample{{Value: val}}}
+}
+
+// emptyFamily returns the metric as a family without samples
+func (m metric) emptyFamily() *family {
+	return &family{Name: m.Key, Type: m.Type, Help: m.Desc}
+}
+
+// collector is a named producer of metric families. Collect is passed the
+// target and a connection to it, along with the already retrieved SERVER
+// stats.
+type collector struct {
+	Name    string
+	Collect collectFunc
+}
+
+// collectFunc produces the metric families of a collector
+type collectFunc func(t *target, conn redis.Conn, stats map[string]gjson.Result) ([]*family, error)
+
+// statsCollector returns a collect function that reads each of the passed
+// metrics from the SERVER stats
+func statsCollector(metrics []metric) collectFunc {
+	return func(_ *target, _ redis.Conn, stats map[string]gjson.Result) ([]*family, error) {
+		fams := make([]*family, 0, len(metrics)+1)
+		missing := 0
+		for _, metric := range metrics {
+			v, ok := lookup(stats, metric.Key)
+			if !ok && !missingAsNaN {
+				missing++
+				continue
+			}
+			fams = append(fams, metric.family(v))
+		}
+		return append(fams, missingFamily(missing)), nil
+	}
+}
+
+// collectTile38 exports the Tile38 server stats, along with whether the
+// server has caught up with its leader when it is a follower
+func collectTile38(t *target, conn redis.Conn, stats map[string]gjson.Result) ([]*family, error) {
+	fams, err := statsCollector(tile38Metrics)(t, conn, stats)
+	if err != nil {
+		return nil, err
+	}
+	if stats["following"].String() != "" {
+		fams = append(fams, caughtUpMetric.family(get(stats, "caught_up")))
+	}
+	return fams, nil
+}
+
+// collectGo exports the Go runtime and memory stats, along with the metrics
+// derived from them
+func collectGo(t *target, conn redis.Conn, stats map[string]gjson.Result) ([]*family, error) {
+	fams, err := statsCollector(goMetrics)(t, conn, stats)
+	if err != nil {
+		return nil, err
+	}
+	// The fragmentation ratio is omitted when either of its inputs is
+	// missing or when nothing is allocated on the heap.
+	sys, heap := get(stats, "sys_bytes"), get(stats, "heap_alloc_bytes")
+	if !math.IsNaN(sys) && !math.IsNaN(heap) && heap != 0 {
+		fams = append(fams, fragmentationMetric.family(sys/heap))
+	}
+	return fams, nil
 }
 
 // get retrieves a value by its passed json key and returns it as a float64. If
 // it fails to find the key or fails to assert it to a float64 9999.9999 is
 // returned as an obvious error
 func get(m map[string]gjson.Result, key string) float64 {
-	switch m[key].Type {
-	case gjson.True:
-		return 1
-	case gjson.False:
-		return 0
-	case gjson.Number:
-		return m[key].Num
-	default:
-		return math.NaN()
-	}
+	v, _ := lookup(m, key)
+	return v
 }
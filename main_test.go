@@ -0,0 +1,198 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// testStats returns the stats of the SERVER reply in testdata/server.json
+func testStats(t *testing.T) map[string]gjson.Result {
+	t.Helper()
+	data, err := ioutil.ReadFile("testdata/server.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gjson.GetBytes(data, "stats").Map()
+}
+
+// collectSection runs a collector against the stats, returning its section
+// as scrape would
+func collectSection(t *testing.T, c collector, stats map[string]gjson.Result) section {
+	t.Helper()
+	fams, err := c.Collect(nil, nil, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fams, _ = takeMissing(fams)
+	return section{Name: c.Name, Families: renameFamilies(fams)}
+}
+
+func TestCollectGoGolden(t *testing.T) {
+	out := render([]section{collectSection(t, collector{"go", collectGo}, testStats(t))}, "")
+	checkGolden(t, "go.golden", out)
+}
+
+func TestFragmentationRatio(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		sys, heap string  // JSON values, empty when missing
+		want      float64 // 0 when omitted
+	}{
+		{"ratio", "3000", "1200", 2.5},
+		{"zero heap", "3000", "0", 0},
+		{"missing heap", "3000", "", 0},
+		{"missing sys", "", "1200", 0},
+		{"non-numeric heap", "3000", `"1200"`, 0},
+	} {
+		stats := make(map[string]gjson.Result)
+		for key, v := range map[string]string{"sys_bytes": tc.sys, "heap_alloc_bytes": tc.heap} {
+			if v != "" {
+				stats[key] = gjson.Parse(v)
+			}
+		}
+		fams, err := collectGo(nil, nil, stats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got *family
+		for _, f := range fams {
+			if f.Name == fragmentationMetric.Key {
+				got = f
+			}
+		}
+		switch {
+		case tc.want == 0 && got != nil:
+			t.Errorf("%s: got ratio %v, want it omitted", tc.name, got.Samples[0].Value)
+		case tc.want != 0 && got == nil:
+			t.Errorf("%s: ratio omitted, want %v", tc.name, tc.want)
+		case tc.want != 0 && got.Samples[0].Value != tc.want:
+			t.Errorf("%s: got ratio %v, want %v", tc.name, got.Samples[0].Value, tc.want)
+		}
+	}
+}
+
+// metricsServer serves /metrics as main does, from a snapshot of a fake
+// server
+func metricsServer(t *testing.T, n string) *httptest.Server {
+	useSnapshot(t, newTestTarget(t, newFakeTile38(t)))
+	srv := httptest.NewServer(getOrHead(func(w http.ResponseWriter, r *http.Request) {
+		handle(w, r, n)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// request sends a request to the server, without the transparent gzip
+// decompression of the client
+func request(t *testing.T, srv *httptest.Server, method, path, encoding string) (*http.Response, []byte) {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	req, _ := http.NewRequest(method, srv.URL+path, nil)
+	if encoding != "" {
+		req.Header.Set("Accept-Encoding", encoding)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestHeadLength(t *testing.T) {
+	// The body is fixed, so that GET and HEAD can be compared
+	body := []byte(strings.Repeat(render(renderSections(), ""), 4))
+	srv := httptest.NewServer(getOrHead(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", expositionContentType)
+		writeBody(w, r, body)
+	}))
+	defer srv.Close()
+	for _, encoding := range []string{"", "gzip"} {
+		get, got := request(t, srv, "GET", "/metrics", encoding)
+		head, empty := request(t, srv, "HEAD", "/metrics", encoding)
+		if len(empty) != 0 {
+			t.Errorf("encoding %q: HEAD has a body of %d bytes", encoding, len(empty))
+		}
+		for _, h := range []string{"Content-Type", "Content-Encoding", "Content-Length"} {
+			if hv, gv := head.Header.Get(h), get.Header.Get(h); hv != gv {
+				t.Errorf("encoding %q: HEAD has %s %q, GET %q", encoding, h, hv, gv)
+			}
+		}
+		if cl := head.Header.Get("Content-Length"); cl != strconv.Itoa(len(got)) {
+			t.Errorf("encoding %q: Content-Length %s, want the %d bytes of the GET body", encoding, cl, len(got))
+		}
+		if encoding == "gzip" && len(got) >= len(body) {
+			t.Errorf("GET body of %d bytes, want it compressed", len(got))
+		}
+	}
+}
+
+func TestMetricsHead(t *testing.T) {
+	srv := metricsServer(t, "")
+	for _, encoding := range []string{"", "gzip"} {
+		resp, body := request(t, srv, "HEAD", "/metrics", encoding)
+		if resp.StatusCode != 200 || len(body) != 0 {
+			t.Errorf("encoding %q: HEAD answered %d with %d bytes, want 200 without a body",
+				encoding, resp.StatusCode, len(body))
+		}
+		if n, err := strconv.Atoi(resp.Header.Get("Content-Length")); err != nil || n < gzipMinSize/2 {
+			t.Errorf("encoding %q: Content-Length %q, want the length of the body",
+				encoding, resp.Header.Get("Content-Length"))
+		}
+		if got := resp.Header.Get("Content-Type"); got != expositionContentType {
+			t.Errorf("encoding %q: Content-Type %q, want %q", encoding, got, expositionContentType)
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != encoding {
+			t.Errorf("Content-Encoding %q, want %q", got, encoding)
+		}
+	}
+
+	resp, _ := request(t, srv, "POST", "/metrics", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, HEAD" {
+		t.Errorf("POST answered %d with Allow %q, want 405 with GET, HEAD",
+			resp.StatusCode, resp.Header.Get("Allow"))
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for _, n := range []string{"", "tile38"} {
+		t.Run("namespace "+n, func(t *testing.T) {
+			srv := metricsServer(t, n)
+			resp, body := request(t, srv, "GET", "/metrics", "")
+			if got := resp.Header.Get("Content-Type"); got != expositionContentType {
+				t.Errorf("Content-Type %q, want %q", got, expositionContentType)
+			}
+			name := namespaced(n, "heap_alloc_bytes")
+			if !strings.Contains(string(body), "\n"+name+" ") {
+				t.Errorf("%s is missing from the output", name)
+			}
+		})
+	}
+
+	// An error when no target can be scraped
+	f := newFakeTile38(t)
+	tg := newTestTarget(t, f)
+	f.ln.Close()
+	useSnapshot(t, tg)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handle(w, r, "")
+	}))
+	defer srv.Close()
+	resp, _ := request(t, srv, "GET", "/metrics", "")
+	if resp.StatusCode != 500 {
+		t.Fatalf("answered %d, want 500", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain;") || strings.Contains(got, "version=") {
+		t.Errorf("error Content-Type %q, want plain text", got)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// metricInfo describes a metric family the metrics endpoint may contain
+type metricInfo struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Help      string `json:"help"`
+	Collector string `json:"collector"`
+}
+
+// describeMetrics returns every metric family the metrics endpoint may
+// contain with the current configuration, in collector order, under their
+// exported names
+func describeMetrics(n string) []metricInfo {
+	c := currentConfig()
+	infos := c.catalog()
+	for i := range infos {
+		if typ, ok := c.Types[infos[i].Name]; ok {
+			infos[i].Type = typ
+		}
+		infos[i].Name = finalName(n, infos[i].Name)
+	}
+	return infos
+}
+
+// catalog returns every metric family the metrics endpoint may contain with
+// the configuration, named as matched by relabel rules and renames: in the
+// naming mode, without the namespace. Families only exported in some states,
+// such as while a server is a follower, are included. Those of the native
+// metrics of Tile38 are not, as they are only known once fetched.
+func (c *config) catalog() []metricInfo {
+	var infos []metricInfo
+	add := func(collector string, ms ...metric) {
+		for _, m := range ms {
+			infos = append(infos, metricInfo{Name: m.Key, Type: m.Type,
+				Help: m.Desc, Collector: collector})
+		}
+	}
+	for _, col := range collectors {
+		switch col.Name {
+		case "go":
+			add(col.Name, goMetrics...)
+			add(col.Name, fragmentationMetric)
+		case "tile38":
+			add(col.Name, tile38Metrics...)
+			add(col.Name, caughtUpMetric, upMetric)
+		case "collections":
+			if len(c.Shards) > 0 {
+				add(col.Name, shardUnexpectedKeysMetric)
+			}
+			add(col.Name, collectionsDroppedMetric)
+			for _, m := range collectionMetrics {
+				add(col.Name, metric{m.Type, "tile38_collection_" + m.Key, m.Desc})
+			}
+			if collectionsOpts.Bounds {
+				add(col.Name, boundsAreaMetric)
+				if collectionsOpts.BoundsVerbose {
+					add(col.Name, boundsMetrics...)
+				}
+			}
+		case "queries":
+			if len(c.Queries) > 0 {
+				add(col.Name, queryCountMetric, queryDurationMetric,
+					querySuccessMetric, queryFailuresMetric)
+			}
+		case "strings":
+			for _, sc := range c.Strings {
+				add(col.Name, metric{"gauge", sc.Metric, sc.help()})
+			}
+			if len(c.Strings) > 0 {
+				add(col.Name, stringParseFailuresMetric)
+			}
+		}
+	}
+	// The exporter metrics are served apart with --web-self-telemetry-addr
+	if selfTelemetryAddr == "" {
+		add("exporter", collectorSuccessMetric, collectorSkippedMetric,
+			protectedModeMetric, readyMetric, missingStatsMetric,
+			poolActiveMetric, poolIdleMetric, poolMaxActiveMetric,
+			poolWaitMetric, poolWaitDurationMetric,
+			dialBackoffMetric, dialFailuresMetric, snapshotAgeMetric)
+		if breakerOpts.Failures > 0 {
+			add("exporter", breakerStateMetric, breakerTripsMetric)
+		}
+		if leaderOpts.Enabled {
+			add("exporter", leaderMetric)
+		}
+		selfMetrics.Lock()
+		for _, m := range selfMetrics.list {
+			add("exporter", metric{m.Type, m.Name, m.Help})
+		}
+		selfMetrics.Unlock()
+	}
+	if shadow != nil {
+		add("shadow", shadowUpMetric, shadowDiffMetric, shadowObjectsDiffMetric)
+	}
+
+	// The rules with label matchers are left to the series they match
+	rules := nameRules(c.Relabel)
+	var out []metricInfo
+	for _, info := range infos {
+		info.Help = exportHelp(info.Name, info.Help)
+		for _, name := range exportNames(info.Name) {
+			name, ok := relabelSeries(rules, name, nil)
+			if !ok {
+				continue
+			}
+			info.Name = name
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+// handleMetadata serves the description of every metric family as JSON
+func handleMetadata(w http.ResponseWriter, r *http.Request, n string) {
+	data, _ := json.MarshalIndent(describeMetrics(n), "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	writeBody(w, r, append(data, '\n'))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+
+	"github.com/tidwall/gjson"
+)
+
+// missingAsNaN exports the stats missing from the replies of Tile38 as NaN,
+// as older versions did, instead of leaving them out
+var missingAsNaN bool
+
+// missingStatsMetric is the family through which collectors count the stats
+// they left out. It's replaced by a single family per target on scrape.
+var missingStatsMetric = metric{"gauge", "tile38_exporter_missing_stats", "Number of stats missing from the replies of the last scrape, and left out"}
+
+// lookup returns the value of a stat, and whether it's present and numeric.
+// Booleans count as 0 and 1, and an explicit zero is present.
+func lookup(m map[string]gjson.Result, key string) (float64, bool) {
+	switch m[key].Type {
+	case gjson.True:
+		return 1, true
+	case gjson.False:
+		return 0, true
+	case gjson.Number:
+		return m[key].Num, true
+	default:
+		return math.NaN(), false
+	}
+}
+
+// missingFamily returns the family counting n stats left out by a collector
+func missingFamily(n int) *family {
+	return missingStatsMetric.family(float64(n))
+}
+
+// takeMissing removes the missing stats families from fams, returning the
+// number of stats they count
+func takeMissing(fams []*family) ([]*family, int) {
+	n := 0
+	var out []*family
+	for _, f := range fams {
+		if f.Name == missingStatsMetric.Key {
+			for _, s := range f.Samples {
+				n += int(s.Value)
+			}
+			continue
+		}
+		out = append(out, f)
+	}
+	return out, n
+}
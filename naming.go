@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// namingMode selects the names of the exported metrics: "legacy" keeps the
+// names of earlier versions, "prometheus" follows the naming conventions of
+// Prometheus, and "client_golang" also names the Go runtime stats of Tile38
+// after those of the Go collector of client_golang
+var namingMode = "legacy"
+
+// namespace prefixes the names of the exported metrics, see namespaced
+var namespace string
+
+// keepLegacyNames also exports the renamed metrics under their legacy names
+// in the prometheus and client_golang naming modes, while dashboards migrate
+var keepLegacyNames bool
+
+// prometheusNames maps the legacy names of the metrics whose unit is not in
+// their name, or not in its base unit, and of the counters without the _total
+// suffix, to their names in the prometheus naming mode
+var prometheusNames = map[string]string{
+	"tile38_max_heap_size":                "tile38_max_heap_size_bytes",
+	"tile38_pointer_size":                 "tile38_pointer_size_bytes",
+	"tile38_uptime_in_seconds":            "tile38_uptime_seconds_total",
+	"tile38_total_connections_received":   "tile38_connections_received_total",
+	"tile38_total_commands_processed":     "tile38_commands_processed_total",
+	"tile38_expired_keys":                 "tile38_expired_keys_total",
+	"tile38_aof_last_rewrite_time_sec":    "tile38_aof_last_rewrite_time_seconds",
+	"tile38_aof_current_rewrite_time_sec": "tile38_aof_current_rewrite_time_seconds",
+	"tile38_aof_size":                     "tile38_aof_size_bytes",
+	"tile38_avg_point_size":               "tile38_avg_point_size_bytes",
+	"tile38_in_memory_size":               "tile38_in_memory_size_bytes",
+	"tile38_collection_in_memory_size":    "tile38_collection_in_memory_size_bytes",
+	"last_gc_time_seconds":                "last_gc_timestamp_seconds",
+}
+
+// clientGolangNames maps the legacy names of the Go runtime stats of Tile38 to
+// their names in the client_golang naming mode, which take precedence over
+// prometheusNames. Those already named alike are listed so that their help
+// text is scoped to Tile38 too.
+var clientGolangNames = map[string]string{
+	"go_goroutines":        "go_goroutines",
+	"go_threads":           "go_threads",
+	"alloc_bytes":          "go_memstats_alloc_bytes",
+	"alloc_bytes_total":    "go_memstats_alloc_bytes_total",
+	"sys_bytes":            "go_memstats_sys_bytes",
+	"lookups_total":        "go_memstats_lookups_total",
+	"mallocs_total":        "go_memstats_mallocs_total",
+	"frees_total":          "go_memstats_frees_total",
+	"heap_alloc_bytes":     "go_memstats_heap_alloc_bytes",
+	"heap_sys_bytes":       "go_memstats_heap_sys_bytes",
+	"heap_idle_bytes":      "go_memstats_heap_idle_bytes",
+	"heap_inuse_bytes":     "go_memstats_heap_inuse_bytes",
+	"heap_released_bytes":  "go_memstats_heap_released_bytes",
+	"heap_objects":         "go_memstats_heap_objects",
+	"stack_inuse_bytes":    "go_memstats_stack_inuse_bytes",
+	"stack_sys_bytes":      "go_memstats_stack_sys_bytes",
+	"mspan_inuse_bytes":    "go_memstats_mspan_inuse_bytes",
+	"mspan_sys_bytes":      "go_memstats_mspan_sys_bytes",
+	"mcache_inuse_bytes":   "go_memstats_mcache_inuse_bytes",
+	"mcache_sys_bytes":     "go_memstats_mcache_sys_bytes",
+	"buck_hash_sys_bytes":  "go_memstats_buck_hash_sys_bytes",
+	"gc_sys_bytes":         "go_memstats_gc_sys_bytes",
+	"other_sys_bytes":      "go_memstats_other_sys_bytes",
+	"next_gc_bytes":        "go_memstats_next_gc_bytes",
+	"last_gc_time_seconds": "go_memstats_last_gc_time_seconds",
+	"gc_cpu_fraction":      "go_memstats_gc_cpu_fraction",
+}
+
+// checkNamingMode validates --compat and --compat-keep-legacy-names
+func checkNamingMode() error {
+	switch namingMode {
+	case "legacy", "prometheus", "client_golang":
+	default:
+		return fmt.Errorf("--compat must be legacy, prometheus or client_golang, not %q", namingMode)
+	}
+	if keepLegacyNames && namingMode == "legacy" {
+		return errors.New("--compat-keep-legacy-names requires --compat prometheus or client_golang")
+	}
+	return nil
+}
+
+// invalidNamespaceRE matches the runs of characters not allowed in metric names
+var invalidNamespaceRE = regexp.MustCompile(`[^a-zA-Z0-9_:]+`)
+
+// checkNamespace validates --namespace, which prefixes every metric name and
+// must be valid as one. Invalid namespaces are rejected rather than changed,
+// suggesting a valid one when there is any.
+func checkNamespace(n string) error {
+	if n == "" || metricNameRE.MatchString(n) {
+		return nil
+	}
+	const rule = "letters, digits, underscores and colons, not starting with a digit"
+	suggested := strings.Trim(invalidNamespaceRE.ReplaceAllString(n, "_"), "_")
+	if !metricNameRE.MatchString(suggested) {
+		return fmt.Errorf("--namespace %q is not a valid metric name: use %s", n, rule)
+	}
+	return fmt.Errorf("--namespace %q is not a valid metric name: use %s, such as %q", n, rule, suggested)
+}
+
+// exportName returns the name of a metric in the naming mode
+func exportName(name string) string {
+	if namingMode == "client_golang" {
+		if renamed, ok := clientGolangNames[name]; ok {
+			return renamed
+		}
+	}
+	if namingMode != "legacy" {
+		if renamed, ok := prometheusNames[name]; ok {
+			return renamed
+		}
+	}
+	return name
+}
+
+// exportHelp returns the help text of a metric in the naming mode. The Go
+// runtime stats named like those of client_golang are told apart from the
+// runtime of the exporter by their help text.
+func exportHelp(name, help string) string {
+	if _, ok := clientGolangNames[name]; ok && namingMode == "client_golang" {
+		return help + " (Tile38 process)"
+	}
+	return help
+}
+
+// exportNames returns every name a metric is exported under in the naming
+// mode, which is two while keeping the legacy names of renamed metrics
+func exportNames(name string) []string {
+	renamed := exportName(name)
+	if keepLegacyNames && renamed != name {
+		return []string{renamed, name}
+	}
+	return []string{renamed}
+}
+
+// renameFamilies names the families of a collector after the naming mode,
+// copying those also kept under their legacy names
+func renameFamilies(fams []*family) []*family {
+	out := fams[:0:0]
+	for _, f := range fams {
+		help := exportHelp(f.Name, f.Help)
+		for i, name := range exportNames(f.Name) {
+			nf := f
+			if i > 0 {
+				nf = &family{Type: f.Type, Samples: f.Samples}
+			}
+			nf.Name, nf.Help = name, help
+			out = append(out, nf)
+		}
+	}
+	return out
+}
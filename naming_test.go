@@ -0,0 +1,207 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// setNaming sets the naming mode until the end of the test
+func setNaming(t *testing.T, mode string, keepLegacy bool) {
+	prevMode, prevKeep := namingMode, keepLegacyNames
+	namingMode, keepLegacyNames = mode, keepLegacy
+	t.Cleanup(func() { namingMode, keepLegacyNames = prevMode, prevKeep })
+}
+
+// statsSections returns the sections of the go and tile38 collectors run
+// against testdata/server.json
+func statsSections(t *testing.T) []section {
+	t.Helper()
+	stats := testStats(t)
+	return []section{
+		collectSection(t, collector{"go", collectGo}, stats),
+		collectSection(t, collector{"tile38", collectTile38}, stats),
+	}
+}
+
+// parseOutput parses a text document with the parser of Prometheus
+func parseOutput(t *testing.T, out string) map[string]*dto.MetricFamily {
+	t.Helper()
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("parsing the output: %v\n%s", err, out)
+	}
+	return mfs
+}
+
+func TestNamingModes(t *testing.T) {
+	for _, tc := range []struct {
+		legacy, renamed, help string
+	}{
+		{"tile38_aof_last_rewrite_time_sec", "tile38_aof_last_rewrite_time_seconds", "Length of time the last AOF shrink took"},
+		{"tile38_total_connections_received", "tile38_connections_received_total", "Number of connections accepted by the server"},
+		{"tile38_uptime_in_seconds", "tile38_uptime_seconds_total", "Uptime of the Tile38 server in seconds"},
+		{"tile38_aof_size", "tile38_aof_size_bytes", "Total size of the AOF in bytes"},
+		{"last_gc_time_seconds", "last_gc_timestamp_seconds", "Number of seconds since 1970 of last garbage collection"},
+	} {
+		for _, mode := range []string{"legacy", "prometheus"} {
+			setNaming(t, mode, false)
+			mfs := parseOutput(t, render(statsSections(t), ""))
+			name, other := tc.legacy, tc.renamed
+			if mode == "prometheus" {
+				name, other = tc.renamed, tc.legacy
+			}
+			mf, ok := mfs[name]
+			if !ok {
+				t.Errorf("%s mode: %s is missing", mode, name)
+				continue
+			}
+			if _, ok := mfs[other]; ok {
+				t.Errorf("%s mode: %s is exported too", mode, other)
+			}
+			if mf.GetHelp() != tc.help {
+				t.Errorf("%s mode: %s has HELP %q, want %q", mode, name, mf.GetHelp(), tc.help)
+			}
+		}
+	}
+	// Names without a rename are the same in both modes
+	for _, mode := range []string{"legacy", "prometheus"} {
+		setNaming(t, mode, false)
+		if _, ok := parseOutput(t, render(statsSections(t), ""))["tile38_num_points"]; !ok {
+			t.Errorf("%s mode: tile38_num_points is missing", mode)
+		}
+	}
+}
+
+func TestKeepLegacyNames(t *testing.T) {
+	setNaming(t, "prometheus", true)
+	// Two targets, so that every family holds several series
+	var results []targetResult
+	for _, addr := range []string{"10.0.0.1:9851", "10.0.0.2:9851"} {
+		results = append(results, targetResult{Target: &target{Addr: addr}, Sections: statsSections(t)})
+	}
+	out := render(mergeSections(results, true, false), "")
+	mfs := parseOutput(t, out)
+	for _, name := range []string{
+		"tile38_total_connections_received", "tile38_connections_received_total",
+		"tile38_total_commands_processed", "tile38_commands_processed_total",
+		"tile38_uptime_in_seconds", "tile38_uptime_seconds_total",
+	} {
+		mf, ok := mfs[name]
+		if !ok {
+			t.Errorf("%s is missing", name)
+			continue
+		}
+		if mf.GetType() != dto.MetricType_COUNTER || len(mf.GetMetric()) != 2 {
+			t.Errorf("%s is a %s with %d series, want a counter with one per target",
+				name, mf.GetType(), len(mf.GetMetric()))
+		}
+		for _, header := range []string{"# HELP ", "# TYPE "} {
+			if n := strings.Count(out, header+name+" "); n != 1 {
+				t.Errorf("%d %sblocks of %s, want 1", n, header, name)
+			}
+		}
+	}
+}
+
+func TestClientGolangNamesGolden(t *testing.T) {
+	setNaming(t, "client_golang", false)
+	stats := testStats(t)
+	out := render([]section{collectSection(t, collector{"go", collectGo}, stats)}, "")
+	checkGolden(t, "client_golang.golden", out)
+
+	mf, ok := parseOutput(t, out)["go_memstats_heap_inuse_bytes"]
+	if !ok {
+		t.Fatal("go_memstats_heap_inuse_bytes is missing")
+	}
+	if !strings.HasSuffix(mf.GetHelp(), " (Tile38 process)") {
+		t.Errorf("HELP %q isn't scoped to Tile38", mf.GetHelp())
+	}
+}
+
+func TestCheckNamespace(t *testing.T) {
+	for _, tc := range []struct {
+		n         string
+		ok        bool
+		suggested string // empty when none is valid
+	}{
+		{n: "", ok: true},
+		{n: "tile38", ok: true},
+		{n: "my_app:prod", ok: true},
+		{n: "_private", ok: true},
+		{n: "my-app", suggested: "my_app"},
+		{n: "my.app", suggested: "my_app"},
+		{n: "-my--app-", suggested: "my_app"},
+		{n: "café", suggested: "caf"},
+		{n: "1app"},
+		{n: "日本"},
+		{n: "---"},
+		{n: " "},
+	} {
+		err := checkNamespace(tc.n)
+		switch {
+		case tc.ok && err != nil:
+			t.Errorf("%q: %v", tc.n, err)
+		case tc.ok:
+		case err == nil:
+			t.Errorf("%q: accepted", tc.n)
+		case tc.suggested != "" && !strings.HasSuffix(err.Error(), `such as "`+tc.suggested+`"`):
+			t.Errorf("%q: %v, want %q suggested", tc.n, err, tc.suggested)
+		case tc.suggested == "" && strings.Contains(err.Error(), "such as"):
+			t.Errorf("%q: %v, want no suggestion", tc.n, err)
+		}
+	}
+}
+
+func TestCheckMetricName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"tile38_num_points": true,
+		"_private":          true,
+		":recording:rule":   true,
+		"tile38-num-points": false,
+		"tile38.num.points": false,
+		"tile38_café":       false,
+		"日本":                false,
+		"1st_metric":        false,
+		"":                  false,
+		"with space":        false,
+	} {
+		if got := checkMetricName(name); got != want {
+			t.Errorf("checkMetricName(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestNamespaceCollapse(t *testing.T) {
+	for _, tc := range []struct {
+		n            string
+		want, absent []string
+	}{
+		{"", []string{"tile38_num_points", "go_goroutines", "heap_alloc_bytes"}, nil},
+		{"tile38",
+			[]string{"tile38_num_points", "tile38_pid", "tile38_go_goroutines", "tile38_heap_alloc_bytes"},
+			[]string{"tile38_tile38_num_points", "go_goroutines"}},
+		{"app",
+			[]string{"app_tile38_num_points", "app_go_goroutines", "app_heap_alloc_bytes"},
+			[]string{"tile38_num_points"}},
+	} {
+		out := render(statsSections(t), tc.n)
+		if again := render(statsSections(t), tc.n); again != out {
+			t.Errorf("namespace %q: the output differs between renders", tc.n)
+		}
+		mfs := parseOutput(t, out)
+		for _, name := range tc.want {
+			if _, ok := mfs[name]; !ok {
+				t.Errorf("namespace %q: %s is missing", tc.n, name)
+			}
+		}
+		for _, name := range tc.absent {
+			if _, ok := mfs[name]; ok {
+				t.Errorf("namespace %q: %s is exported", tc.n, name)
+			}
+		}
+	}
+}
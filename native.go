@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// nativeMetricsURL is the metrics endpoint of Tile38 itself, merged into the
+// output when set
+var nativeMetricsURL string
+
+var nativeClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	nativeUp = newSelfMetric("gauge", "tile38_exporter_native_metrics_up",
+		"Whether or not the native Tile38 metrics could be fetched")
+	nativeDropped = newSelfMetric("counter", "tile38_exporter_native_duplicates_dropped_total",
+		"Number of native Tile38 metric families dropped as they conflict with the exporter's own")
+)
+
+// native caches the merged native families, so that they are fetched at most
+// once per collect interval
+var native struct {
+	sync.Mutex
+	time     time.Time
+	families []*family
+}
+
+// nativeFamilies returns the native families that don't conflict with the
+// families of the passed results or the exporter's own. On failure no native
+// families are returned, leaving the exporter's own untouched.
+func nativeFamilies(results []targetResult) []*family {
+	if nativeMetricsURL == "" {
+		return nil
+	}
+	native.Lock()
+	defer native.Unlock()
+	if collectInterval > 0 && time.Since(native.time) < collectInterval {
+		return native.families
+	}
+	native.time = time.Now()
+	native.families = nil
+	fams, err := fetchNative(nativeMetricsURL)
+	if err != nil {
+		log.Printf("native metrics: %v", err)
+		nativeUp.Set(0)
+		return nil
+	}
+	nativeUp.Set(1)
+
+	own := make(map[string]bool)
+	for _, s := range mergeSections(results, false, false) {
+		for _, f := range s.Families {
+			own[f.Name] = true
+		}
+	}
+	for _, f := range selfFamilies() {
+		own[f.Name] = true
+	}
+	for _, f := range fams {
+		if own[f.Name] {
+			nativeDropped.Inc()
+			continue
+		}
+		native.families = append(native.families, f)
+	}
+	return native.families
+}
+
+// fetchNative fetches and parses a metrics document in the text format
+func fetchNative(url string) ([]*family, error) {
+	resp, err := nativeClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	fams := make([]*family, 0, len(mfs))
+	for _, mf := range mfs {
+		fams = append(fams, fromMetricFamily(mf))
+	}
+	return fams, nil
+}
+
+// fromMetricFamily converts a parsed metric family
+func fromMetricFamily(mf *dto.MetricFamily) *family {
+	f := &family{Name: mf.GetName(), Help: mf.GetHelp(),
+		Type: strings.ToLower(mf.GetType().String())}
+	for _, m := range mf.GetMetric() {
+		var labels []label
+		for _, lp := range m.GetLabel() {
+			labels = append(labels, label{lp.GetName(), lp.GetValue()})
+		}
+		with := func(name, value string) []label {
+			return append(labels[:len(labels):len(labels)], label{name, value})
+		}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			f.Samples = append(f.Samples, sample{Labels: labels, Value: m.GetCounter().GetValue()})
+		case dto.MetricType_GAUGE:
+			f.Samples = append(f.Samples, sample{Labels: labels, Value: m.GetGauge().GetValue()})
+		case dto.MetricType_SUMMARY:
+			s := m.GetSummary()
+			for _, q := range s.GetQuantile() {
+				f.Samples = append(f.Samples, sample{
+					Labels: with("quantile", strconv.FormatFloat(q.GetQuantile(), 'f', -1, 64)),
+					Value:  q.GetValue()})
+			}
+			f.Samples = append(f.Samples,
+				sample{Suffix: "_sum", Labels: labels, Value: s.GetSampleSum()},
+				sample{Suffix: "_count", Labels: labels, Value: float64(s.GetSampleCount())})
+		case dto.MetricType_HISTOGRAM:
+			h := m.GetHistogram()
+			for _, b := range h.GetBucket() {
+				f.Samples = append(f.Samples, sample{Suffix: "_bucket",
+					Labels: with("le", strconv.FormatFloat(b.GetUpperBound(), 'f', -1, 64)),
+					Value:  float64(b.GetCumulativeCount())})
+			}
+			f.Samples = append(f.Samples,
+				sample{Suffix: "_sum", Labels: labels, Value: h.GetSampleSum()},
+				sample{Suffix: "_count", Labels: labels, Value: float64(h.GetSampleCount())})
+		default:
+			f.Type = "untyped"
+			f.Samples = append(f.Samples, sample{Labels: labels, Value: m.GetUntyped().GetValue()})
+		}
+	}
+	return f
+}
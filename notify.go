@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// warmBeforeReady delays the readiness notification until the first
+// successful collection
+var warmBeforeReady bool
+
+// sdNotify sends a state to the systemd service manager. It does nothing
+// when the exporter isn't started by systemd with a notify socket.
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	if path[0] == '@' {
+		// abstract socket
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		log.Printf("sd_notify: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("sd_notify: %v", err)
+	}
+}
+
+// notifyReady tells systemd that the exporter is ready, after the first
+// successful collection when warmBeforeReady is set, and enables the
+// watchdog
+func notifyReady() {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	if warmBeforeReady {
+		for !warm() {
+			time.Sleep(time.Second)
+		}
+	}
+	sdNotify("READY=1")
+	startWatchdog()
+}
+
+// warm returns true when a collection has succeeded for any target. It only
+// looks at the outcome of past collections, as collecting here would have
+// the side effects of a scrape.
+func warm() bool {
+	health.Lock()
+	defer health.Unlock()
+	return health.Warm
+}
+
+// watchdog holds the interval of the systemd watchdog, zero when disabled,
+// and when it was last petted
+var watchdog struct {
+	sync.Mutex
+	Interval time.Duration
+	Last     time.Time
+}
+
+// startWatchdog pets the systemd watchdog at half of its interval, when
+// enabled for this process, for as long as the exporter is alive. Petting
+// doesn't wait for scrapes, which may not come for a while when targets are
+// collected on demand.
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond
+	watchdog.Lock()
+	watchdog.Interval = interval
+	watchdog.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !petWatchdog(time.Now()) {
+				return
+			}
+		}
+	}()
+}
+
+// petWatchdog pets the systemd watchdog unless the exporter is stuck at now.
+// It returns false once the watchdog is disabled.
+func petWatchdog(now time.Time) bool {
+	watchdog.Lock()
+	defer watchdog.Unlock()
+	if watchdog.Interval <= 0 {
+		return false
+	}
+	if stuck(now, watchdog.Interval) {
+		return true
+	}
+	watchdog.Last = now
+	sdNotify("WATCHDOG=1")
+	return true
+}
+
+// stuck returns true when a collection has been running for longer than the
+// watchdog interval, or when the background collection has not collected
+// anything for that long on top of its own interval. systemd then restarts
+// the exporter.
+func stuck(now time.Time, interval time.Duration) bool {
+	if start, ok := oldestCollection(); ok && now.Sub(start) > interval {
+		return true
+	}
+	if collectInterval <= 0 {
+		return false
+	}
+	health.Lock()
+	last := health.LastCollect
+	health.Unlock()
+	return !last.IsZero() && now.Sub(last) > collectInterval+interval
+}
+
+// collections holds the start of the collections in progress, by sequence
+var collections struct {
+	sync.Mutex
+	next   int
+	starts map[int]time.Time
+}
+
+// trackCollection records a collection starting now, until the returned
+// function is called once it's done
+func trackCollection() (done func()) {
+	collections.Lock()
+	defer collections.Unlock()
+	if collections.starts == nil {
+		collections.starts = make(map[int]time.Time)
+	}
+	id := collections.next
+	collections.next++
+	collections.starts[id] = time.Now()
+	return func() {
+		collections.Lock()
+		delete(collections.starts, id)
+		collections.Unlock()
+	}
+}
+
+// oldestCollection returns the start of the oldest collection in progress,
+// if any
+func oldestCollection() (time.Time, bool) {
+	collections.Lock()
+	defer collections.Unlock()
+	var oldest time.Time
+	for _, start := range collections.starts {
+		if oldest.IsZero() || start.Before(oldest) {
+			oldest = start
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// notifySocket listens on a NOTIFY_SOCKET for the states sent to systemd
+func notifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	prev := os.Getenv("NOTIFY_SOCKET")
+	t.Cleanup(func() {
+		conn.Close()
+		os.Setenv("NOTIFY_SOCKET", prev)
+	})
+	os.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// pets returns the number of WATCHDOG=1 states received
+func pets(t *testing.T, conn *net.UnixConn) int {
+	t.Helper()
+	n := 0
+	buf := make([]byte, 64)
+	for {
+		conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		size, err := conn.Read(buf)
+		if err != nil {
+			return n
+		}
+		if string(buf[:size]) != "WATCHDOG=1" {
+			t.Fatalf("got state %q", buf[:size])
+		}
+		n++
+	}
+}
+
+func TestPetWatchdog(t *testing.T) {
+	conn := notifySocket(t)
+	defer func() { watchdog.Interval, watchdog.Last = 0, time.Time{} }()
+	defer func(d time.Duration) { collectInterval = d }(collectInterval)
+	defer func(last time.Time) { health.LastCollect = last }(health.LastCollect)
+
+	// Disabled until started
+	if petWatchdog(time.Now()) {
+		t.Fatal("petting while disabled")
+	}
+	if n := pets(t, conn); n != 0 {
+		t.Fatalf("petted %d times while disabled", n)
+	}
+	watchdog.Interval = 200 * time.Millisecond
+
+	// Without any scrape, as when collecting on demand
+	petWatchdog(time.Now())
+	if n := pets(t, conn); n != 1 {
+		t.Fatalf("petted %d times while idle, want 1", n)
+	}
+	done := trackCollection()
+	petWatchdog(time.Now().Add(watchdog.Interval / 2))
+	if n := pets(t, conn); n != 1 {
+		t.Fatalf("petted %d times during a collection, want 1", n)
+	}
+	petWatchdog(time.Now().Add(2 * watchdog.Interval))
+	if n := pets(t, conn); n != 0 {
+		t.Fatalf("petted %d times during a stuck collection, want 0", n)
+	}
+	done()
+	petWatchdog(time.Now().Add(2 * watchdog.Interval))
+	if n := pets(t, conn); n != 1 {
+		t.Fatalf("petted %d times once the collection is done, want 1", n)
+	}
+
+	// A background collection loop that stopped collecting
+	collectInterval = time.Second
+	health.LastCollect = time.Now()
+	petWatchdog(time.Now().Add(collectInterval))
+	if n := pets(t, conn); n != 1 {
+		t.Fatalf("petted %d times between background collections, want 1", n)
+	}
+	petWatchdog(time.Now().Add(collectInterval + 2*watchdog.Interval))
+	if n := pets(t, conn); n != 0 {
+		t.Fatalf("petted %d times after background collections stopped, want 0", n)
+	}
+}
+
+func TestStartWatchdog(t *testing.T) {
+	conn := notifySocket(t)
+	for key, value := range map[string]string{
+		"WATCHDOG_USEC": "40000",
+		"WATCHDOG_PID":  strconv.Itoa(os.Getpid()),
+	} {
+		prev := os.Getenv(key)
+		defer os.Setenv(key, prev)
+		os.Setenv(key, value)
+	}
+	defer func() {
+		watchdog.Lock()
+		watchdog.Interval, watchdog.Last = 0, time.Time{}
+		watchdog.Unlock()
+	}()
+
+	startWatchdog()
+	time.Sleep(100 * time.Millisecond)
+	if n := pets(t, conn); n < 2 {
+		t.Fatalf("petted %d times without scrapes, want every 20ms", n)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	defer func(w bool) { health.Warm = w }(health.Warm)
+	health.Warm = false
+
+	f := newFakeTile38(t)
+	useTargets(t, newTestTarget(t, f))
+	if warm() {
+		t.Fatal("warm before any collection")
+	}
+	if n := len(f.received()); n != 0 {
+		t.Fatalf("checking warmth sent %d commands", n)
+	}
+	collect(context.Background())
+	if !warm() {
+		t.Fatal("not warm after a successful collection")
+	}
+}
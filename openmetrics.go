@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The exposition formats, negotiated with the Accept header of scrapes
+const (
+	formatText = iota
+	formatOpenMetrics
+	formatProtobuf
+)
+
+// openMetricsContentType is the content type of the OpenMetrics format
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// negotiateFormat returns the format preferred by the Accept header of the
+// request. The text format is served when none is given, and for wildcards;
+// of formats of the same quality, the first listed wins.
+func negotiateFormat(r *http.Request) int {
+	format, best := formatText, 0.0
+	for _, h := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(h, ",") {
+			params := strings.Split(part, ";")
+			var f int
+			switch strings.ToLower(strings.TrimSpace(params[0])) {
+			case "application/openmetrics-text":
+				f = formatOpenMetrics
+			case "application/vnd.google.protobuf":
+				// Only the delimited MetricFamily messages are served
+				if !hasParam(params[1:], "proto", "io.prometheus.client.MetricFamily") ||
+					!hasParam(params[1:], "encoding", "delimited") {
+					continue
+				}
+				f = formatProtobuf
+			case "text/plain", "text/*", "*/*":
+				f = formatText
+			default:
+				continue
+			}
+			if q := qValue(params[1:]); q > best {
+				format, best = f, q
+			}
+		}
+	}
+	return format
+}
+
+// qValue returns the quality of the parameters of an Accept or
+// Accept-Encoding entry, 1 when none is given
+func qValue(params []string) float64 {
+	for _, p := range params {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "q=") {
+			q, err := strconv.ParseFloat(p[2:], 64)
+			if err != nil {
+				return 0
+			}
+			return q
+		}
+	}
+	return 1
+}
+
+// hasParam reports whether the parameters of an Accept entry hold the
+// name=value pair
+func hasParam(params []string, name, value string) bool {
+	for _, p := range params {
+		if k, v := splitParam(p); k == name && v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// splitParam splits a parameter into its lowercase name and its value
+func splitParam(p string) (string, string) {
+	i := strings.IndexByte(p, '=')
+	if i < 0 {
+		return strings.ToLower(strings.TrimSpace(p)), ""
+	}
+	return strings.ToLower(strings.TrimSpace(p[:i])), strings.Trim(strings.TrimSpace(p[i+1:]), `"`)
+}
+
+// renderNegotiated renders the sections in the format negotiated with the
+// client, returning the document and its content type. The InfluxDB line
+// protocol is served for ?format=influx, stamped with t.
+func renderNegotiated(r *http.Request, sections []section, n string, t time.Time) (string, string) {
+	if r.URL.Query().Get("format") == "influx" {
+		return renderInflux(sections, n, t), influxContentType
+	}
+	switch negotiateFormat(r) {
+	case formatOpenMetrics:
+		return renderOpenMetrics(sections, n), openMetricsContentType
+	case formatProtobuf:
+		return renderProtobuf(sections, n), protobufContentType
+	}
+	return render(sections, n), expositionContentType
+}
+
+// renderOpenMetrics produces an OpenMetrics document from the passed
+// sections, in the order of render. OpenMetrics has no free comments, so the
+// collectors are not named, and the document ends with "# EOF".
+func renderOpenMetrics(sections []section, n string) string {
+	sections = groupFamilies(sections, n)
+	names := make(map[string]string)
+	for _, s := range sections {
+		for _, f := range s.Families {
+			names[finalName(n, f.Name)] = f.Type
+		}
+	}
+	var sb strings.Builder
+	for _, s := range sections {
+		fams := append([]*family(nil), s.Families...)
+		sort.SliceStable(fams, func(i, j int) bool {
+			return fams[i].Name < fams[j].Name
+		})
+		for _, f := range fams {
+			sb.WriteString(f.openMetricsString(n, names))
+		}
+	}
+	sb.WriteString("# EOF\n")
+	return sb.String()
+}
+
+// openMetricsString returns the OpenMetrics representation of the family.
+// The family of a counter is named without the _total suffix of its samples,
+// unless that is the name of another of the passed families, and untyped
+// families are of the unknown type. A counter named after another counter
+// with _total, as kept by --compat-keep-legacy-names, has the same samples
+// in OpenMetrics and is left out.
+func (f *family) openMetricsString(n string, names map[string]string) string {
+	typ := f.Type
+	name := finalName(n, f.Name)
+	suffix := ""
+	switch typ {
+	case "counter":
+		trimmed := strings.TrimSuffix(name, "_total")
+		switch {
+		case trimmed != name && names[trimmed] == "counter":
+			return ""
+		case names[trimmed] == "":
+			name = trimmed
+		}
+		suffix = "_total"
+	case "untyped", "":
+		typ = "unknown"
+	}
+	if !checkMetricName(name) {
+		return ""
+	}
+	samples := append([]sample(nil), f.Samples...)
+	sort.SliceStable(samples, func(i, j int) bool {
+		return sampleLess(samples[i], samples[j])
+	})
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# TYPE %s %s\n", name, typ)
+	fmt.Fprintf(&sb, "# HELP %s %s\n", name, openMetricsHelpEscaper.Replace(f.Help))
+	for _, s := range samples {
+		fmt.Fprintf(&sb, "%s%s%s%s %s", name, s.Suffix, suffix, labelsString(s.Labels),
+			strconv.FormatFloat(s.Value, 'f', -1, 64))
+		if s.Timestamp != 0 {
+			// OpenMetrics timestamps are in seconds
+			fmt.Fprintf(&sb, " %s", strconv.FormatFloat(float64(s.Timestamp)/1000, 'f', -1, 64))
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+var openMetricsHelpEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderNegotiated(t *testing.T) {
+	const delimited = "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"
+	for _, tc := range []struct {
+		url, accept string
+		want        string
+	}{
+		{"/metrics", "", expositionContentType},
+		{"/metrics", "*/*", expositionContentType},
+		{"/metrics", "text/*", expositionContentType},
+		{"/metrics", "text/plain; version=0.0.4", expositionContentType},
+		{"/metrics", "application/json", expositionContentType},
+		{"/metrics", "application/openmetrics-text", openMetricsContentType},
+		{"/metrics", "application/openmetrics-text; version=1.0.0; charset=utf-8", openMetricsContentType},
+		{"/metrics", "APPLICATION/OPENMETRICS-TEXT", openMetricsContentType},
+		{"/metrics", "application/openmetrics-text;q=0", expositionContentType},
+		{"/metrics", "application/openmetrics-text;q=0.5, text/plain;q=0.8", expositionContentType},
+		{"/metrics", "text/plain;q=0.5, application/openmetrics-text;q=0.8", openMetricsContentType},
+		// Of the same quality, the first listed wins
+		{"/metrics", "application/openmetrics-text, */*", openMetricsContentType},
+		{"/metrics", "*/*, application/openmetrics-text", expositionContentType},
+		// The Accept header of Prometheus
+		{"/metrics", delimited + ";q=0.7,text/plain;version=0.0.4;q=0.3,*/*;q=0.1", protobufContentType},
+		{"/metrics", "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily", expositionContentType},
+		{"/metrics?format=influx", "application/openmetrics-text", influxContentType},
+	} {
+		r := httptest.NewRequest("GET", tc.url, nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		out, contentType := renderNegotiated(r, renderSections(), "", time.Unix(1600000000, 0))
+		if contentType != tc.want {
+			t.Errorf("%s with Accept %q: got %q, want %q", tc.url, tc.accept, contentType, tc.want)
+			continue
+		}
+		if err := checkOutput(out, contentType); err != nil {
+			t.Errorf("Accept %q: %v", tc.accept, err)
+		}
+		if contentType == openMetricsContentType && !strings.HasSuffix(out, "\n# EOF\n") {
+			t.Errorf("Accept %q: the OpenMetrics document doesn't end with # EOF", tc.accept)
+		}
+	}
+}
+
+func TestRenderOpenMetricsCounter(t *testing.T) {
+	sections := []section{{Name: "exporter", Families: []*family{
+		{Name: "tile38_exporter_panics_total", Type: "counter", Help: "Total number of panics", Samples: []sample{{Value: 2}}},
+		{Name: "tile38_exporter_last_scrape_duration_seconds", Type: "gauge", Samples: []sample{{Value: 0.5}}},
+	}}}
+	got := renderOpenMetrics(sections, "")
+	for _, line := range []string{
+		"# TYPE tile38_exporter_panics counter",
+		"# HELP tile38_exporter_panics Total number of panics",
+		"tile38_exporter_panics_total 2",
+		"# TYPE tile38_exporter_last_scrape_duration_seconds gauge",
+		"tile38_exporter_last_scrape_duration_seconds 0.5",
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, got)
+		}
+	}
+}
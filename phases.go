@@ -0,0 +1,47 @@
+package main
+
+import "time"
+
+// phases holds the time spent in each phase of a scrape: pool_get, command,
+// parse, render and write
+type phases map[string]time.Duration
+
+// add adds d to the time spent in phase
+func (ph phases) add(phase string, d time.Duration) {
+	ph[phase] += d
+}
+
+// phaseBuckets are the histogram buckets of phase durations, in seconds
+var phaseBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	lastPhaseDuration = newSelfMetric("gauge", "tile38_exporter_last_phase_duration_seconds",
+		"Duration of each phase of the most recent scrape", "phase")
+	phaseDuration = newSelfHistogram("tile38_exporter_phase_duration_seconds",
+		"Duration of each phase of scrapes", phaseBuckets, "phase")
+	lastScrapeDuration = newSelfMetric("gauge", "tile38_exporter_last_scrape_duration_seconds",
+		"Duration of the most recent scrape")
+)
+
+// observePhase records the duration of a phase of the current scrape
+func observePhase(phase string, d time.Duration) {
+	lastPhaseDuration.Set(d.Seconds(), phase)
+	phaseDuration.Observe(d.Seconds(), phase)
+}
+
+// observeCollectPhases records the collection phases of all targets. Targets
+// are collected concurrently, so the slowest target of each phase is the one
+// that counts towards the scrape.
+func observeCollectPhases(results []targetResult) {
+	slowest := make(phases)
+	for _, res := range results {
+		for phase, d := range res.Phases {
+			if d > slowest[phase] {
+				slowest[phase] = d
+			}
+		}
+	}
+	for _, phase := range []string{"pool_get", "command", "parse"} {
+		observePhase(phase, slowest[phase])
+	}
+}
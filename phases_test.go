@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfValue returns the value of a self metric for the label values
+func selfValue(m *selfMetric, labelValues ...string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[strings.Join(labelValues, "\xff")]
+}
+
+func TestPhasesAddUp(t *testing.T) {
+	f := newFakeTile38(t)
+	f.delayServer(50 * time.Millisecond)
+	useTargets(t, newTestTarget(t, f))
+
+	rec := httptest.NewRecorder()
+	handle(rec, httptest.NewRequest("GET", "/metrics", nil), "")
+	if rec.Code != 200 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "tile38_num_points") {
+		t.Fatalf("the fake server wasn't scraped:\n%s", rec.Body)
+	}
+	var sum float64
+	for _, phase := range []string{"pool_get", "command", "parse", "render", "write"} {
+		sum += selfValue(lastPhaseDuration, phase)
+	}
+	total := selfValue(lastScrapeDuration)
+	if total < 0.05 {
+		t.Fatalf("scrape took %.4fs, less than the reply delay", total)
+	}
+	// The phases cover the whole scrape but for bookkeeping between them
+	if sum > total || sum < total*0.8 {
+		t.Errorf("phases add up to %.4fs, want about the scrape duration of %.4fs", sum, total)
+	}
+}
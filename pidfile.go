@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// writePidFile writes the pid of the exporter to path. It refuses to
+// overwrite a file pointing at a process that is still running, unless force
+// is set. Files of processes that are no longer running are replaced.
+func writePidFile(path string, force bool) error {
+	if data, err := ioutil.ReadFile(path); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		switch {
+		case err != nil:
+			log.Printf("Replacing invalid pid file %s", path)
+		case pid != os.Getpid() && processAlive(pid):
+			if !force {
+				return fmt.Errorf("%s: process %d is still running "+
+					"(use --pid-file-force to override)", path, pid)
+			}
+			log.Printf("Overwriting pid file %s of running process %d", path, pid)
+		default:
+			log.Printf("Replacing stale pid file %s", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePidFile removes the pid file at path, as long as it still belongs to
+// this process
+func removePidFile(path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		log.Printf("pid file: %v", err)
+	}
+}
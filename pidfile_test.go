@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// exitedPid returns the pid of a process that has exited
+func exitedPid(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+// readPid returns the content of a pid file
+func readPid(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestWritePidFile(t *testing.T) {
+	own := strconv.Itoa(os.Getpid())
+	for _, tc := range []struct {
+		name     string
+		existing string // content of the file, none when empty
+		force    bool
+		wantErr  bool
+	}{
+		{name: "no file"},
+		{name: "stale", existing: strconv.Itoa(exitedPid(t))},
+		{name: "invalid", existing: "not a pid"},
+		{name: "own", existing: own},
+		{name: "running", existing: strconv.Itoa(os.Getppid()), wantErr: true},
+		{name: "running forced", existing: strconv.Itoa(os.Getppid()), force: true},
+	} {
+		path := filepath.Join(t.TempDir(), "tile38-prometheus.pid")
+		if tc.existing != "" {
+			if err := ioutil.WriteFile(path, []byte(tc.existing+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := writePidFile(path, tc.force)
+		switch {
+		case tc.wantErr && err == nil:
+			t.Errorf("%s: replaced the pid file of a running process", tc.name)
+		case tc.wantErr:
+			if got := readPid(t, path); got != tc.existing {
+				t.Errorf("%s: pid file holds %s, want it left as %s", tc.name, got, tc.existing)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		default:
+			if got := readPid(t, path); got != own {
+				t.Errorf("%s: pid file holds %s, want %s", tc.name, got, own)
+			}
+		}
+	}
+}
+
+func TestRemovePidFile(t *testing.T) {
+	dir := t.TempDir()
+	own := filepath.Join(dir, "own.pid")
+	other := filepath.Join(dir, "other.pid")
+	ioutil.WriteFile(own, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	ioutil.WriteFile(other, []byte(strconv.Itoa(os.Getppid())+"\n"), 0644)
+	removePidFile(own)
+	removePidFile(other)
+	removePidFile(filepath.Join(dir, "missing.pid"))
+	if _, err := os.Stat(own); !os.IsNotExist(err) {
+		t.Errorf("the pid file of this process was not removed")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("the pid file of another process was removed")
+	}
+}
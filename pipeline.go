@@ -0,0 +1,52 @@
+package main
+
+import "github.com/gomodule/redigo/redis"
+
+// pipelineCommands sends the independent commands of a collector at once,
+// rather than waiting for the reply of each before sending the next
+var pipelineCommands = true
+
+// command is a Tile38 command and its arguments
+type command struct {
+	Name string
+	Args []interface{}
+}
+
+// reply is the outcome of a command
+type reply struct {
+	Out string
+	Err error
+}
+
+// doAll runs the commands on the connection and returns their replies in
+// order. An error replied to a command only fails that command, while a
+// failure of the connection fails all of them.
+func doAll(conn redis.Conn, cmds []command) []reply {
+	replies := make([]reply, len(cmds))
+	if !pipelineCommands || len(cmds) < 2 {
+		for i, c := range cmds {
+			replies[i].Out, replies[i].Err = do(conn, c.Name, c.Args...)
+		}
+		return replies
+	}
+	var values []interface{}
+	var err error
+	for _, c := range cmds {
+		if err = conn.Send(c.Name, c.Args...); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		// An empty command flushes the pipeline and receives all of
+		// its replies, within the timeouts of the connection
+		values, err = redis.Values(conn.Do(""))
+	}
+	for i := range replies {
+		if err != nil {
+			replies[i].Err = err
+			continue
+		}
+		replies[i].Out, replies[i].Err = checkReply(values[i], nil)
+	}
+	return replies
+}
@@ -0,0 +1,43 @@
+package main
+
+import "time"
+
+// poolWaiting reports whether getting a connection from the pool of the
+// target is about to wait for another connection to be released
+func (t *target) poolWaiting() bool {
+	if !poolOpts.Wait || poolOpts.MaxActive <= 0 {
+		return false
+	}
+	st := t.Pool.Stats()
+	return st.IdleCount == 0 && st.ActiveCount >= poolOpts.MaxActive
+}
+
+// recordPoolWait counts a wait for a pooled connection that took d
+func (t *target) recordPoolWait(d time.Duration) {
+	t.mu.Lock()
+	t.counters["pool_waits"]++
+	t.counters["pool_wait_seconds"] += d.Seconds()
+	t.mu.Unlock()
+}
+
+// poolMetrics are the state of the connection pool of each target
+var (
+	poolActiveMetric       = metric{"gauge", "tile38_exporter_pool_active_connections", "Number of connections to the Tile38 server, in use or idle"}
+	poolIdleMetric         = metric{"gauge", "tile38_exporter_pool_idle_connections", "Number of idle connections to the Tile38 server"}
+	poolMaxActiveMetric    = metric{"gauge", "tile38_exporter_pool_max_active_connections", "Maximum number of connections to the Tile38 server, 0 for no limit"}
+	poolWaitMetric         = metric{"counter", "tile38_exporter_pool_wait_total", "Total number of waits for a connection to the Tile38 server"}
+	poolWaitDurationMetric = metric{"counter", "tile38_exporter_pool_wait_duration_seconds_total", "Total time spent waiting for a connection to the Tile38 server"}
+)
+
+// poolFamilies returns the state of the connection pool of the target. The
+// pool of redigo does not track waits, so they are counted by the exporter.
+func (t *target) poolFamilies() []*family {
+	st := t.Pool.Stats()
+	return []*family{
+		poolActiveMetric.family(float64(st.ActiveCount)),
+		poolIdleMetric.family(float64(st.IdleCount)),
+		poolMaxActiveMetric.family(float64(poolOpts.MaxActive)),
+		poolWaitMetric.family(t.counter("pool_waits")),
+		poolWaitDurationMetric.family(t.counter("pool_wait_seconds")),
+	}
+}
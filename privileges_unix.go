@@ -0,0 +1,79 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// checkRunAs checks that the user and group to run as exist, before any
+// side effect of starting the exporter
+func checkRunAs(username, group string) error {
+	_, _, err := lookupRunAs(username, group)
+	return err
+}
+
+// lookupRunAs returns the ids of the user and group to run as, -1 for those
+// that are not changed. When the group is empty the primary group of the user
+// is used.
+func lookupRunAs(username, group string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return -1, -1, err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return -1, -1, err
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return -1, -1, err
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return -1, -1, err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return -1, -1, err
+		}
+	}
+	return uid, gid, nil
+}
+
+// dropPrivileges switches the process to the passed user and group. When the
+// group is empty the primary group of the user is used.
+func dropPrivileges(username, group string) error {
+	uid, gid, err := lookupRunAs(username, group)
+	if err != nil {
+		return err
+	}
+	// The group must be changed first, as changing the user removes the
+	// permission to do so.
+	if gid != -1 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups: %v", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid: %v", err)
+		}
+		if os.Getgid() != gid || os.Getegid() != gid {
+			return fmt.Errorf("setgid: group is still %d", os.Getegid())
+		}
+	}
+	if uid != -1 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid: %v", err)
+		}
+		if os.Getuid() != uid || os.Geteuid() != uid {
+			return fmt.Errorf("setuid: user is still %d", os.Geteuid())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import "errors"
+
+// errRunAsUnsupported rejects --user and --group
+var errRunAsUnsupported = errors.New("--user and --group are not supported on windows")
+
+// checkRunAs rejects --user and --group, which are not supported on Windows,
+// before any side effect of starting the exporter
+func checkRunAs(username, group string) error {
+	if username != "" || group != "" {
+		return errRunAsUnsupported
+	}
+	return nil
+}
+
+// dropPrivileges is not supported on Windows
+func dropPrivileges(username, group string) error {
+	return errRunAsUnsupported
+}
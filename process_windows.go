@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// processAlive returns true when a process with the pid exists
+func processAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == 259 // STILL_ACTIVE
+}
+
+// processCPUTime returns the user and kernel CPU time used by the process
+func processCPUTime() time.Duration {
+	var creation, exit, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(windows.CurrentProcess(),
+		&creation, &exit, &kernel, &user); err != nil {
+		return 0
+	}
+	// Filetimes count 100 nanosecond intervals
+	ticks := func(ft windows.Filetime) int64 {
+		return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+	}
+	return time.Duration((ticks(kernel) + ticks(user)) * 100)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// isProtectedMode returns true when err is the rejection of a Tile38 server
+// running in protected mode without a password
+func isProtectedMode(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "protected mode")
+}
+
+// checkProtectedMode records whether the last dial was rejected by protected
+// mode. The first rejection of a target is logged with how to fix it.
+func (t *target) checkProtectedMode(err error) {
+	rejected := isProtectedMode(err)
+	t.mu.Lock()
+	t.protected = rejected
+	logIt := rejected && !t.protectedSeen
+	if rejected {
+		t.protectedSeen = true
+	}
+	t.mu.Unlock()
+	if logIt {
+		log.Printf("Tile38 protected-mode is rejecting this exporter at %s; "+
+			"set requirepass or protected-mode no on the Tile38 server", t.Addr)
+	}
+}
+
+var protectedModeMetric = metric{"gauge", "tile38_exporter_protected_mode_rejected", "Whether or not the Tile38 server rejects the exporter due to protected mode"}
+
+// protectedModeFamily returns whether the target rejects the exporter due to
+// protected mode
+func (t *target) protectedModeFamily() *family {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v := 0.0
+	if t.protected {
+		v = 1
+	}
+	return protectedModeMetric.family(v)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// protobufContentType is the content type of the delimited protobuf format
+const protobufContentType = "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"
+
+// renderProtobuf produces delimited MetricFamily messages from the passed
+// sections, in the order of render
+func renderProtobuf(sections []section, n string) string {
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.FmtProtoDelim)
+	for _, s := range groupFamilies(sections, n) {
+		fams := append([]*family(nil), s.Families...)
+		sort.SliceStable(fams, func(i, j int) bool {
+			return fams[i].Name < fams[j].Name
+		})
+		for _, f := range fams {
+			mf := f.toMetricFamily(n)
+			if checkMetricName(mf.GetName()) {
+				enc.Encode(mf)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// toMetricFamily converts the family to a protobuf message, the reverse of
+// fromMetricFamily. The samples of a histogram or summary are grouped by
+// label set, without the le or quantile label.
+func (f *family) toMetricFamily(n string) *dto.MetricFamily {
+	name := finalName(n, f.Name)
+	mf := &dto.MetricFamily{Name: &name, Help: &f.Help}
+	switch f.Type {
+	case "counter":
+		mf.Type = dto.MetricType_COUNTER.Enum()
+	case "gauge":
+		mf.Type = dto.MetricType_GAUGE.Enum()
+	case "histogram":
+		mf.Type = dto.MetricType_HISTOGRAM.Enum()
+	case "summary":
+		mf.Type = dto.MetricType_SUMMARY.Enum()
+	default:
+		mf.Type = dto.MetricType_UNTYPED.Enum()
+	}
+	samples := append([]sample(nil), f.Samples...)
+	sort.SliceStable(samples, func(i, j int) bool {
+		return sampleLess(samples[i], samples[j])
+	})
+	var m *dto.Metric
+	var key string
+	for _, s := range samples {
+		labels, bound := withoutLabel(s.Labels, "le")
+		if f.Type == "summary" {
+			labels, bound = withoutLabel(s.Labels, "quantile")
+		}
+		if k := labelsString(labels); m == nil || k != key {
+			m = &dto.Metric{Label: labelPairs(labels)}
+			if s.Timestamp != 0 {
+				ts := s.Timestamp
+				m.TimestampMs = &ts
+			}
+			key = k
+			mf.Metric = append(mf.Metric, m)
+		}
+		v := s.Value
+		switch f.Type {
+		case "counter":
+			m.Counter = &dto.Counter{Value: &v}
+		case "gauge":
+			m.Gauge = &dto.Gauge{Value: &v}
+		case "histogram":
+			if m.Histogram == nil {
+				m.Histogram = &dto.Histogram{}
+			}
+			switch s.Suffix {
+			case "_bucket":
+				ub, err := strconv.ParseFloat(bound, 64)
+				if err != nil || math.IsInf(ub, 1) {
+					// The +Inf bucket is the count
+					break
+				}
+				c := uint64(v)
+				m.Histogram.Bucket = append(m.Histogram.Bucket,
+					&dto.Bucket{UpperBound: &ub, CumulativeCount: &c})
+			case "_sum":
+				m.Histogram.SampleSum = &v
+			case "_count":
+				c := uint64(v)
+				m.Histogram.SampleCount = &c
+			}
+		case "summary":
+			if m.Summary == nil {
+				m.Summary = &dto.Summary{}
+			}
+			switch s.Suffix {
+			case "":
+				q, _ := strconv.ParseFloat(bound, 64)
+				m.Summary.Quantile = append(m.Summary.Quantile,
+					&dto.Quantile{Quantile: &q, Value: &v})
+			case "_sum":
+				m.Summary.SampleSum = &v
+			case "_count":
+				c := uint64(v)
+				m.Summary.SampleCount = &c
+			}
+		default:
+			m.Untyped = &dto.Untyped{Value: &v}
+		}
+	}
+	return mf
+}
+
+// labelPairs converts the labels, sorted by name
+func labelPairs(labels []label) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for i := range labels {
+		pairs = append(pairs, &dto.LabelPair{Name: &labels[i].Name, Value: &labels[i].Value})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+	return pairs
+}
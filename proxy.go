@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// tile38Proxy is the SOCKS5 proxy through which Tile38 is reached, or nil to
+// connect directly
+var tile38Proxy *url.URL
+
+// parseProxy parses --tile38-proxy, socks5://[user:password@]host:port
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// The error quotes the URI, password included
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("invalid URI: %v", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("%s: unsupported scheme %q, expected socks5", u.Redacted(), u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("%s: expected socks5://host:port", u.Redacted())
+	}
+	return u, nil
+}
+
+// proxyError is a failure to reach Tile38 through the proxy, reported apart
+// from the failures of Tile38 itself
+type proxyError struct{ err error }
+
+func (e proxyError) Error() string {
+	return fmt.Sprintf("proxy %s: %v", tile38Proxy.Host, e.err)
+}
+
+func (e proxyError) Unwrap() error { return e.err }
+
+// dialProxy connects to addr through the proxy, connecting to the proxy with
+// forward. The host name of addr is resolved by the proxy.
+func dialProxy(forward *net.Dialer, network, addr string) (net.Conn, error) {
+	d, err := proxy.FromURL(tile38Proxy, forward)
+	if err != nil {
+		return nil, proxyError{err}
+	}
+	ctx := context.Background()
+	if forward.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, forward.Timeout)
+		defer cancel()
+	}
+	nc, err := d.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, proxyError{err}
+	}
+	return nc, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webProxyProtocol enables the PROXY protocol on the listeners of the http
+// server
+var webProxyProtocol bool
+
+// proxyHeaderTimeout is the time a client has to send the PROXY header
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Sig starts the headers of version 2 of the PROXY protocol
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener accepts connections starting with a PROXY protocol header,
+// version 1 or 2, whose remote address is the client's given by the header
+type proxyListener struct{ net.Listener }
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// proxyConn reads the PROXY header on first use, in the goroutine of the
+// connection rather than in the accept loop. A connection with a malformed
+// header is closed.
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// init reads the header
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			debugf("msg=\"invalid proxy protocol header\" remote=%s err=%q", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a PROXY header, returning the address of the client,
+// or nil for connections of the proxy itself, such as health checks
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyV2Sig))
+	if err == nil && bytes.Equal(sig, proxyV2Sig) {
+		return readProxyV2(r)
+	}
+	if sig, err := r.Peek(6); err != nil || string(sig) != "PROXY " {
+		return nil, errors.New("missing proxy protocol header")
+	}
+	return readProxyV1(r)
+}
+
+// readProxyV1 reads a header of version 1, such as
+// "PROXY TCP4 192.0.2.1 192.0.2.2 56324 8080\r\n"
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// The longest header is 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy protocol v1 header too long")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads a header of version 2, which is binary
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported proxy protocol version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	switch hdr[12] & 0xf {
+	case 0: // LOCAL
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, fmt.Errorf("invalid proxy protocol v2 command %d", hdr[12]&0xf)
+	}
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short proxy protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short proxy protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	// Other families, such as unix sockets, carry no usable address
+	return nil, nil
+}
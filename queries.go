@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+// queryConfig defines a geo query whose result count is exported as a gauge.
+// Exactly one of Bounds, Circle or GeoJSON must be set, and NEARBY queries
+// require a Circle.
+type queryConfig struct {
+	Name    string        `yaml:"name"`
+	Type    string        `yaml:"type"`
+	Key     string        `yaml:"key"`
+	Where   []whereConfig `yaml:"where"`
+	Bounds  []float64     `yaml:"bounds"`
+	Circle  *circleConfig `yaml:"circle"`
+	GeoJSON string        `yaml:"geojson"`
+}
+
+// whereConfig is a WHERE clause of a query. Min and Max may be -inf or +inf.
+type whereConfig struct {
+	Field string `yaml:"field"`
+	Min   string `yaml:"min"`
+	Max   string `yaml:"max"`
+}
+
+// circleConfig is a point with a radius in meters
+type circleConfig struct {
+	Lat    float64 `yaml:"lat"`
+	Lon    float64 `yaml:"lon"`
+	Meters float64 `yaml:"meters"`
+}
+
+// validate checks the query for errors
+func (q queryConfig) validate() error {
+	if q.Name == "" {
+		return errors.New("missing name")
+	}
+	switch strings.ToLower(q.Type) {
+	case "within", "intersects", "nearby":
+	default:
+		return fmt.Errorf("query %s: invalid type %q, expected within, intersects or nearby",
+			q.Name, q.Type)
+	}
+	if q.Key == "" {
+		return fmt.Errorf("query %s: missing key", q.Name)
+	}
+	for _, w := range q.Where {
+		if w.Field == "" || w.Min == "" || w.Max == "" {
+			return fmt.Errorf("query %s: where clauses require field, min and max", q.Name)
+		}
+	}
+	var n int
+	if q.Bounds != nil {
+		if len(q.Bounds) != 4 {
+			return fmt.Errorf("query %s: bounds must be [minlat, minlon, maxlat, maxlon]", q.Name)
+		}
+		n++
+	}
+	if q.Circle != nil {
+		n++
+	}
+	if q.GeoJSON != "" {
+		if !gjson.Valid(q.GeoJSON) {
+			return fmt.Errorf("query %s: invalid geojson", q.Name)
+		}
+		n++
+	}
+	if n != 1 {
+		return fmt.Errorf("query %s: exactly one of bounds, circle or geojson is required", q.Name)
+	}
+	if strings.ToLower(q.Type) == "nearby" && q.Circle == nil {
+		return fmt.Errorf("query %s: nearby queries require a circle", q.Name)
+	}
+	return nil
+}
+
+// args returns the Tile38 command and arguments for a COUNT of the query
+func (q queryConfig) args() (string, []interface{}) {
+	args := []interface{}{q.Key}
+	for _, w := range q.Where {
+		args = append(args, "WHERE", w.Field, w.Min, w.Max)
+	}
+	args = append(args, "COUNT")
+	switch {
+	case q.Bounds != nil:
+		args = append(args, "BOUNDS", q.Bounds[0], q.Bounds[1], q.Bounds[2], q.Bounds[3])
+	case q.Circle != nil && strings.ToLower(q.Type) == "nearby":
+		args = append(args, "POINT", q.Circle.Lat, q.Circle.Lon, q.Circle.Meters)
+	case q.Circle != nil:
+		args = append(args, "CIRCLE", q.Circle.Lat, q.Circle.Lon, q.Circle.Meters)
+	default:
+		args = append(args, "OBJECT", q.GeoJSON)
+	}
+	return strings.ToUpper(q.Type), args
+}
+
+// queryMetrics are the families of the queries collector, labeled by query
+var (
+	queryCountMetric    = metric{"gauge", "tile38_query_result_count", "Number of objects matching the query"}
+	queryDurationMetric = metric{"gauge", "tile38_query_duration_seconds", "Duration of the last run of the query"}
+	querySuccessMetric  = metric{"gauge", "tile38_query_success", "Whether or not the last run of the query succeeded"}
+	queryFailuresMetric = metric{"counter", "tile38_query_failures_total", "Total number of failed runs of the query"}
+)
+
+// collectQueries runs every configured query against the target. A failing
+// query is reported through its own success and failure metrics and never
+// fails the collector.
+func collectQueries(t *target, conn redis.Conn, _ map[string]gjson.Result) ([]*family, error) {
+	count := queryCountMetric.emptyFamily()
+	duration := queryDurationMetric.emptyFamily()
+	success := querySuccessMetric.emptyFamily()
+	failures := queryFailuresMetric.emptyFamily()
+	// Queries are not pipelined, so that the duration of each is its own
+	for _, q := range currentConfig().Queries {
+		labels := []label{{"query", q.Name}}
+		cmd, args := q.args()
+		start := time.Now()
+		out, err := do(conn, cmd, args...)
+		duration.Samples = append(duration.Samples, sample{Labels: labels,
+			Value: time.Since(start).Seconds()})
+		if err != nil {
+			success.Samples = append(success.Samples, sample{Labels: labels})
+			t.inc("query_failures:" + q.Name)
+		} else {
+			success.Samples = append(success.Samples, sample{Labels: labels, Value: 1})
+			count.Samples = append(count.Samples, sample{Labels: labels,
+				Value: gjson.Get(out, "count").Float()})
+		}
+		failures.Samples = append(failures.Samples, sample{Labels: labels,
+			Value: t.counter("query_failures:" + q.Name)})
+	}
+	return []*family{count, duration, success, failures}, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitOpts configures the per client rate limit of the metrics endpoint
+var rateLimitOpts struct {
+	Rate  float64 // scrapes per second, or 0 for no limit
+	Burst int
+}
+
+// rateLimitSweepInterval is the minimum interval between two evictions of
+// idle clients
+const rateLimitSweepInterval = time.Minute
+
+var rateLimited = newSelfMetric("counter", "tile38_exporter_rate_limited_total",
+	"Total number of scrapes rejected by --web-rate-limit, by client address", "client")
+
+// rateLimitedMaxClients is the number of client addresses labeling
+// rateLimited. The scrapes of other clients are counted as "other", so that
+// many clients don't make for many series.
+const rateLimitedMaxClients = 10
+
+// rateLimiter is a token bucket per client address. The buckets of clients
+// idle long enough to be full again are evicted, as they hold no state.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	clients map[string]*tokenBucket
+	swept   time.Time
+	labeled map[string]bool // clients labeling rateLimited
+}
+
+// tokenBucket is the bucket of a client, as of last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst),
+		clients: make(map[string]*tokenBucket), swept: time.Now(),
+		labeled: make(map[string]bool)}
+}
+
+// allow takes a token from the bucket of the client, reporting whether one
+// was left
+func (l *rateLimiter) allow(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// clientLabel returns the label of the client in rateLimited: its address
+// for the first clients rejected, "other" for the rest
+func (l *rateLimiter) clientLabel(client string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.labeled[client] {
+		if len(l.labeled) >= rateLimitedMaxClients {
+			return "other"
+		}
+		l.labeled[client] = true
+	}
+	return client
+}
+
+// sweep evicts the buckets that are full again
+func (l *rateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.clients {
+		if now.Sub(b.last) >= full {
+			delete(l.clients, client)
+		}
+	}
+	l.swept = now
+}
+
+// rateLimit answers 429 to the clients scraping faster than --web-rate-limit
+// allows. Clients are told by their address, without the port.
+func rateLimit(h http.HandlerFunc) http.HandlerFunc {
+	if rateLimitOpts.Rate <= 0 {
+		return h
+	}
+	l := newRateLimiter(rateLimitOpts.Rate, rateLimitOpts.Burst)
+	retry := strconv.Itoa(int(math.Ceil(1 / rateLimitOpts.Rate)))
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if !l.allow(client, time.Now()) {
+			rateLimited.Inc(l.clientLabel(client))
+			w.Header().Set("Retry-After", retry)
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(1, 2)
+	now := time.Now()
+	for i, want := range []bool{true, true, false} {
+		if got := l.allow("10.0.0.1", now); got != want {
+			t.Errorf("scrape %d: allowed %t, want %t", i, got, want)
+		}
+	}
+	if !l.allow("10.0.0.2", now) {
+		t.Errorf("another client was limited")
+	}
+	if !l.allow("10.0.0.1", now.Add(time.Second)) {
+		t.Errorf("limited after a token was added back")
+	}
+}
+
+func TestRateLimitedCardinality(t *testing.T) {
+	defer func(rate float64, burst int) { rateLimitOpts.Rate, rateLimitOpts.Burst = rate, burst }(rateLimitOpts.Rate, rateLimitOpts.Burst)
+	rateLimitOpts.Rate, rateLimitOpts.Burst = 0.001, 1
+	rateLimited.Reset()
+	defer rateLimited.Reset()
+
+	h := rateLimit(func(w http.ResponseWriter, r *http.Request) {})
+	clients := rateLimitedMaxClients + 5
+	for i := 0; i < clients; i++ {
+		for j := 0; j < 2; j++ {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.RemoteAddr = fmt.Sprintf("10.0.0.%d:%d", i, 40000+j)
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			if want := []int{200, 429}[j]; rec.Code != want {
+				t.Fatalf("client %d, scrape %d: answered %d, want %d", i, j, rec.Code, want)
+			}
+		}
+	}
+	f := rateLimited.family()
+	if len(f.Samples) != rateLimitedMaxClients+1 {
+		t.Errorf("%d series, want %d clients and other", len(f.Samples), rateLimitedMaxClients)
+	}
+	if got := selfValue(rateLimited, "other"); got != 5 {
+		t.Errorf("other counted %v, want 5", got)
+	}
+	if got := selfValue(rateLimited, "10.0.0.0"); got != 1 {
+		t.Errorf("the first client counted %v, want 1", got)
+	}
+}
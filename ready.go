@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// readyAllowLagging makes followers that have not caught up ready
+var readyAllowLagging bool
+
+var readyMetric = metric{"gauge", "tile38_exporter_ready", "Whether or not the target is ready to serve good data, as told by /-/ready"}
+
+// notReady returns why a server of the SERVER stats is not ready, or nil.
+// Followers are only ready once caught up with their leader, unless lagging
+// is allowed.
+func notReady(stats map[string]gjson.Result) error {
+	following := stats["following"].String()
+	if following == "" || readyAllowLagging {
+		return nil
+	}
+	if caught, ok := stats["caught_up"]; ok && !caught.Bool() {
+		return fmt.Errorf("follower of %s has not caught up", following)
+	}
+	return nil
+}
+
+// readyFamily returns the readiness of the target on the scrape of the
+// result
+func readyFamily(res targetResult) *family {
+	f := readyMetric.family(0)
+	if res.Err == nil && notReady(res.Stats) == nil {
+		f.Samples[0].Value = 1
+	}
+	return f
+}
+
+// handleReady answers 200 when every target is connected and serves good
+// data, and 503 with the reasons otherwise
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+	all := currentTargets()
+	if len(all) == 0 {
+		http.Error(w, "no tile38 targets", http.StatusServiceUnavailable)
+		return
+	}
+	failed := checkTargets(all, func(t *target) error {
+		out, err := t.probe(ctx, "SERVER")
+		if err != nil {
+			return err
+		}
+		if !gjson.Get(out, "stats").Exists() {
+			return errors.New("no stats in the SERVER reply")
+		}
+		return notReady(gjson.Get(out, "stats").Map())
+	})
+	if len(failed) > 0 {
+		http.Error(w, strings.Join(failed, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{\"status\":\"ready\",\"targets\":%d}\n", len(all))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+var panicsTotal = newSelfMetric("counter", "tile38_exporter_panics_total",
+	"Total number of panics recovered from in the handlers and collectors")
+
+// recoverPanics answers 500 to the requests whose handler panics, logging the
+// stack, rather than leaving the server to drop the connection
+func recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// Aborts the response on purpose
+				panic(v)
+			}
+			panicsTotal.Inc()
+			log.Printf("level=error msg=\"panic serving request\" path=%s err=%q\n%s",
+				r.URL.Path, fmt.Sprint(v), debug.Stack())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// safeCollect runs a collector, returning a panic as its error. Collectors
+// run on goroutines of their own, where a panic would end the process.
+func safeCollect(c collector, t *target, conn redis.Conn, stats map[string]gjson.Result) (fams []*family, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			panicsTotal.Inc()
+			log.Printf("level=error msg=\"panic in collector\" collector=%s target=%s err=%q\n%s",
+				c.Name, t.Addr, fmt.Sprint(v), debug.Stack())
+			fams, err = nil, fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return c.Collect(t, conn, stats)
+}
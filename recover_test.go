@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		var fams []*family
+		w.Write([]byte(fams[0].Name))
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	srv := httptest.NewServer(recoverPanics(mux))
+	defer srv.Close()
+
+	before := selfValue(panicsTotal)
+	for i := 0; i < 2; i++ {
+		resp, _ := request(t, srv, "GET", "/panic", "")
+		if resp.StatusCode != 500 {
+			t.Errorf("panicking handler answered %d, want 500", resp.StatusCode)
+		}
+		resp, body := request(t, srv, "GET", "/ok", "")
+		if resp.StatusCode != 200 || string(body) != "ok" {
+			t.Errorf("answered %d %q after a panic, want the server still serving", resp.StatusCode, body)
+		}
+	}
+	if got := selfValue(panicsTotal) - before; got != 2 {
+		t.Errorf("counted %v panics, want 2", got)
+	}
+}
+
+func TestSafeCollect(t *testing.T) {
+	defer func(cs []collector) { collectors = cs }(collectors)
+	collectors = append(collectors[:len(collectors):len(collectors)], collector{"panics",
+		func(*target, redis.Conn, map[string]gjson.Result) ([]*family, error) {
+			panic("unexpected reply")
+		}})
+	res := newTestTarget(t, newFakeTile38(t)).scrape(context.Background())
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	success := make(map[string]float64)
+	for _, s := range res.Sections {
+		for _, f := range s.Families {
+			if f.Name == collectorSuccessMetric.Key {
+				for _, smp := range f.Samples {
+					success[smp.Labels[0].Value] = smp.Value
+				}
+			}
+		}
+	}
+	if success["panics"] != 0 || success["tile38"] != 1 {
+		t.Errorf("collector success %v, want only the panicking collector failed", success)
+	}
+}
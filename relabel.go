@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// relabelRule drops, keeps or renames the series whose metric name, and
+// optionally label values, match its regular expressions. Expressions are
+// anchored at both ends, as in Prometheus.
+type relabelRule struct {
+	Name        string            `yaml:"name"`
+	Labels      map[string]string `yaml:"labels"`
+	Action      string            `yaml:"action"`
+	Replacement string            `yaml:"replacement"`
+
+	name   *regexp.Regexp
+	labels map[string]*regexp.Regexp
+}
+
+var relabelDropped = newSelfMetric("gauge", "tile38_exporter_relabel_dropped_series",
+	"Number of series dropped by the relabel rules in the most recent scrape")
+
+// compile validates the rule and compiles its regular expressions
+func (r *relabelRule) compile() error {
+	switch r.Action {
+	case "drop", "keep":
+	case "rename":
+		if r.Replacement == "" {
+			return errors.New("rename requires a replacement")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	expr := r.Name
+	if expr == "" {
+		expr = ".*"
+	}
+	re, err := compileAnchored(expr)
+	if err != nil {
+		return fmt.Errorf("name: %v", err)
+	}
+	r.name = re
+	r.labels = make(map[string]*regexp.Regexp, len(r.Labels))
+	for name, expr := range r.Labels {
+		re, err := compileAnchored(expr)
+		if err != nil {
+			return fmt.Errorf("labels.%s: %v", name, err)
+		}
+		r.labels[name] = re
+	}
+	return nil
+}
+
+// compileAnchored compiles a regular expression anchored at both ends.
+// Errors refer to the expression as written.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if _, err := regexp.Compile(expr); err != nil {
+		return nil, err
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// matches returns true when the rule applies to the series. A missing label
+// has an empty value.
+func (r *relabelRule) matches(name string, labels []label) bool {
+	if !r.name.MatchString(name) {
+		return false
+	}
+	for ln, re := range r.labels {
+		v := ""
+		for _, l := range labels {
+			if l.Name == ln {
+				v = l.Value
+				break
+			}
+		}
+		if !re.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// relabelSeries applies the rules in order to a series, returning its final
+// metric name, or false when the series is dropped. A rename that does not
+// produce a valid metric name leaves the name unchanged.
+func relabelSeries(rules []relabelRule, name string, labels []label) (string, bool) {
+	for i := range rules {
+		r := &rules[i]
+		match := r.matches(name, labels)
+		switch {
+		case r.Action == "drop" && match, r.Action == "keep" && !match:
+			return "", false
+		case r.Action == "rename" && match:
+			if renamed := r.name.ReplaceAllString(name, r.Replacement); metricNameRE.MatchString(renamed) {
+				name = renamed
+			}
+		}
+	}
+	return name, true
+}
+
+// nameRules returns the rules without label matchers, which apply to whole
+// families
+func nameRules(rules []relabelRule) []relabelRule {
+	var out []relabelRule
+	for _, r := range rules {
+		if len(r.labels) == 0 {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// relabel applies the configured relabel rules to every series of the
+// sections, returning the relabeled sections along with the number of series
+// dropped. Metric names are matched without the namespace. Renamed series
+// join the family of their new name in the same section. The constant labels
+// are added last.
+func relabel(sections []section) ([]section, int) {
+	rules := currentConfig().Relabel
+	if len(rules) == 0 {
+		return withConstLabels(sections), 0
+	}
+	dropped := 0
+	out := make([]section, 0, len(sections))
+	for _, s := range sections {
+		var fams []*family
+		byName := make(map[string]*family)
+		for _, f := range s.Families {
+			for _, smp := range f.Samples {
+				name, ok := relabelSeries(rules, f.Name, smp.Labels)
+				if !ok {
+					dropped++
+					continue
+				}
+				nf, ok := byName[name]
+				if !ok {
+					nf = &family{Name: name, Type: f.Type, Help: f.Help}
+					byName[name] = nf
+					fams = append(fams, nf)
+				}
+				nf.Samples = append(nf.Samples, smp)
+			}
+		}
+		out = append(out, section{Name: s.Name, Families: fams})
+	}
+	return withConstLabels(out), dropped
+}
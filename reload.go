@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// webEnableLifecycle enables the /-/reload endpoint
+var webEnableLifecycle bool
+
+// reloadConfig reads the configuration file again and makes it the active
+// one, splitting the targets again when the shard assignment changed. The
+// active configuration is kept when the file fails to load.
+func reloadConfig() error {
+	if configPath == "" {
+		return errors.New("no --config file to reload")
+	}
+	c, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := c.checkMetrics(namespace); err != nil {
+		return fmt.Errorf("%s: %v", configPath, err)
+	}
+	prev := currentShard()
+	setConfig(c)
+	if sa := currentShard(); sa != prev {
+		log.Printf("Shard assignment changed from %d of %d to %d of %d", prev.Index, prev.Count, sa.Index, sa.Count)
+		reshard()
+	}
+	return nil
+}
+
+// handleReload reloads the configuration on POST, answering 500 with the
+// error when the new configuration is invalid
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		log.Printf("level=error msg=\"reloading the configuration failed, keeping the current one\" err=%q", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Reloaded the configuration from %s", configPath)
+}
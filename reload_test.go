@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadSharding(t *testing.T) {
+	defer func(c *config, path string) { setConfig(c); configPath = path }(currentConfig(), configPath)
+	configPath = filepath.Join(t.TempDir(), "config.yml")
+	var all []*target
+	for i := 0; i < 20; i++ {
+		all = append(all, newTarget(fmt.Sprintf("10.0.0.%d:9851", i), nil))
+	}
+	useTargets(t, all...)
+
+	for _, tc := range []struct {
+		file         string
+		index, count int
+	}{
+		{"sharding: {index: 1, count: 3}", 1, 3},
+		{"sharding: {index: 0, count: 2}", 0, 2},
+		// Back to the flags
+		{"", 0, 1},
+	} {
+		if err := ioutil.WriteFile(configPath, []byte(tc.file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := reloadConfig(); err != nil {
+			t.Fatalf("%q: %v", tc.file, err)
+		}
+		owned := make(map[*target]bool)
+		for _, tg := range currentTargets() {
+			owned[tg] = true
+		}
+		if n := len(owned) + len(skippedTargets()); n != len(all) {
+			t.Errorf("%q: %d targets owned or skipped, want %d", tc.file, n, len(all))
+		}
+		for _, tg := range all {
+			if want := shardOf(tg.Addr, tc.count) == tc.index; owned[tg] != want || tg.owned() != want {
+				t.Errorf("%q: %s owned %t, want %t", tc.file, tg.Addr, owned[tg], want)
+			}
+		}
+		if v := selfValue(shardInfo, fmt.Sprint(tc.index), fmt.Sprint(tc.count)); v != 1 {
+			t.Errorf("%q: shard info %v, want 1", tc.file, v)
+		}
+	}
+
+	// An invalid assignment keeps the current one
+	if err := ioutil.WriteFile(configPath, []byte("sharding: {index: 2, count: 2}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(); err == nil {
+		t.Error("reloaded shard 2 of 2")
+	}
+	if n := len(currentTargets()); n != len(all) {
+		t.Errorf("%d targets owned after a failed reload, want %d", n, len(all))
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// family is a metric family: a single HELP/TYPE header shared by all of its
+// samples.
+type family struct {
+	Name, Type, Help string
+	Samples          []sample
+}
+
+// sample is a single value of a family, identified by its label set. The
+// suffix is appended to the family name, as used by the _bucket, _sum and
+// _count samples of histograms.
+type sample struct {
+	Suffix    string
+	Labels    []label
+	Value     float64
+	Timestamp int64 // milliseconds since the epoch, or 0 for none
+}
+
+// label is a name/value pair attached to a sample.
+type label struct{ Name, Value string }
+
+// section is the output of a single collector.
+type section struct {
+	Name     string
+	Families []*family
+}
+
+// render produces a prometheus text document from the passed sections. The
+// order is stable: sections keep the order they are passed in, families are
+// sorted alphabetically within a section and samples are sorted by their label
+// sets within a family. Each name has a single family, see groupFamilies.
+func render(sections []section, n string) string {
+	var sb strings.Builder
+	for _, s := range groupFamilies(sections, n) {
+		if len(s.Families) == 0 {
+			continue
+		}
+		fams := append([]*family(nil), s.Families...)
+		sort.SliceStable(fams, func(i, j int) bool {
+			return fams[i].Name < fams[j].Name
+		})
+		fmt.Fprintf(&sb, "# Collector: %s\n", s.Name)
+		for _, f := range fams {
+			sb.WriteString(f.promString(n))
+		}
+	}
+	return sb.String()
+}
+
+// groupFamilies merges the families exported under the same name into the
+// first of them, so that each name gets a single HELP/TYPE header. That
+// happens when a relabel rule renames series into a family of another
+// section, or when a name collapses with the namespace. Samples of another
+// type, or of a series already present, are left out. The type overrides of
+// the configuration apply first. The passed families are not modified.
+func groupFamilies(sections []section, n string) []section {
+	type slot struct{ sec, idx int }
+	byName := make(map[string]slot)
+	merged := make(map[string]map[string]bool) // series of the copied families
+	types := currentConfig().Types
+	out := make([]section, 0, len(sections))
+	for _, s := range sections {
+		out = append(out, section{Name: s.Name})
+		cur := len(out) - 1
+		for _, f := range s.Families {
+			if typ, ok := types[f.Name]; ok && typ != f.Type {
+				f = &family{Name: f.Name, Type: typ, Help: f.Help, Samples: f.Samples}
+			}
+			name := finalName(n, f.Name)
+			at, ok := byName[name]
+			if !ok {
+				byName[name] = slot{cur, len(out[cur].Families)}
+				out[cur].Families = append(out[cur].Families, f)
+				continue
+			}
+			g := out[at.sec].Families[at.idx]
+			if g.Type != f.Type {
+				if _, warned := conflictingNames.LoadOrStore(name, true); !warned {
+					log.Printf("level=warn msg=\"metric exported with conflicting types, leaving out all but the first\" name=%q types=%s,%s",
+						name, g.Type, f.Type)
+				}
+				continue
+			}
+			series, ok := merged[name]
+			if !ok {
+				g = &family{Name: g.Name, Type: g.Type, Help: g.Help,
+					Samples: append([]sample(nil), g.Samples...)}
+				out[at.sec].Families[at.idx] = g
+				series = make(map[string]bool, len(g.Samples))
+				for _, smp := range g.Samples {
+					series[smp.Suffix+labelsString(smp.Labels)] = true
+				}
+				merged[name] = series
+			}
+			for _, smp := range f.Samples {
+				if key := smp.Suffix + labelsString(smp.Labels); !series[key] {
+					series[key] = true
+					g.Samples = append(g.Samples, smp)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// conflictingNames are the names exported with conflicting types already
+// warned about
+var conflictingNames sync.Map
+
+// namespaced returns the name of a metric in the namespace. Names already
+// starting with the namespace, such as those of the Tile38 stats in the
+// tile38 namespace, are not prefixed twice.
+func namespaced(n, name string) string {
+	if n == "" || strings.HasPrefix(name, n+"_") {
+		return name
+	}
+	return n + "_" + name
+}
+
+// finalName returns the exported name of a metric: its rename in the
+// configuration, which the namespace doesn't apply to, or its name in the
+// namespace
+func finalName(n, name string) string {
+	if renamed, ok := currentConfig().Rename[name]; ok {
+		return renamed
+	}
+	return namespaced(n, name)
+}
+
+// promString returns the prometheus string representation of the family,
+// including all of its samples. A family with an invalid name is left out,
+// as it would make the whole document unparseable.
+func (f *family) promString(n string) string {
+	name := finalName(n, f.Name)
+	if !checkMetricName(name) {
+		return ""
+	}
+	samples := append([]sample(nil), f.Samples...)
+	sort.SliceStable(samples, func(i, j int) bool {
+		return sampleLess(samples[i], samples[j])
+	})
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# HELP %s %s\n", name, helpEscaper.Replace(f.Help))
+	fmt.Fprintf(&sb, "# TYPE %s %s\n", name, f.Type)
+	for _, s := range samples {
+		fmt.Fprintf(&sb, "%s%s%s %s", name, s.Suffix, labelsString(s.Labels),
+			strconv.FormatFloat(s.Value, 'f', -1, 64))
+		if s.Timestamp != 0 {
+			fmt.Fprintf(&sb, " %d", s.Timestamp)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// suffixOrder is the order of the samples of a histogram
+var suffixOrder = map[string]int{"": 0, "_bucket": 1, "_sum": 2, "_count": 3}
+
+// sampleLess orders samples by label set. The samples of a histogram are
+// grouped by label set, with buckets in increasing order followed by the sum
+// and count.
+func sampleLess(a, b sample) bool {
+	la, lea := withoutLabel(a.Labels, "le")
+	lb, leb := withoutLabel(b.Labels, "le")
+	if ka, kb := labelsString(la), labelsString(lb); ka != kb {
+		return ka < kb
+	}
+	if a.Suffix != b.Suffix {
+		return suffixOrder[a.Suffix] < suffixOrder[b.Suffix]
+	}
+	va, _ := strconv.ParseFloat(lea, 64)
+	vb, _ := strconv.ParseFloat(leb, 64)
+	return va < vb
+}
+
+// withoutLabel returns the labels without the named label, along with the
+// value of the removed label
+func withoutLabel(labels []label, name string) ([]label, string) {
+	var out []label
+	var val string
+	for _, l := range labels {
+		if l.Name == name {
+			val = l.Value
+			continue
+		}
+		out = append(out, l)
+	}
+	return out, val
+}
+
+// labelsString returns the label set in its exposition form, e.g.
+// {addr="10.0.0.1:9851"}. Labels are sorted by name and an empty set returns
+// an empty string.
+func labelsString(labels []label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	sorted := append([]label(nil), labels...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, l := range sorted {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(l.Name)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabelValue(l.Value))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// invalidNames are the invalid metric names already warned about
+var invalidNames sync.Map
+
+// checkMetricName reports whether the metric name is valid, warning once
+// about each invalid name
+func checkMetricName(name string) bool {
+	if metricNameRE.MatchString(name) {
+		return true
+	}
+	if _, warned := invalidNames.LoadOrStore(name, true); !warned {
+		log.Printf("level=warn msg=\"invalid metric name, leaving it out\" name=%q", name)
+	}
+	return false
+}
+
+// helpEscaper escapes backslashes and newlines in HELP text
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes backslashes, double-quotes and newlines in a label
+// value as required by the exposition format.
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// update rewrites the golden files with the current output
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares got with the golden file testdata/name, rewriting it
+// instead with -update. The golden files are text documents, which must pass
+// the validation of --validate-output.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	if err := checkOutput(got, expositionContentType); err != nil {
+		t.Errorf("%s: invalid output: %v", name, err)
+	}
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run go test -update to create it", err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s, run go test -update to accept it\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// renderSections are sections in no particular order, as the collectors
+// produce them
+func renderSections() []section {
+	return []section{
+		{Name: "go", Families: []*family{
+			{Name: "heap_alloc_bytes", Type: "gauge", Help: "Bytes allocated and still in use", Samples: []sample{
+				{Labels: []label{{"addr", "10.0.0.2:9851"}}, Value: 2048},
+				{Labels: []label{{"addr", "10.0.0.1:9851"}}, Value: 1024},
+			}},
+			{Name: "go_goroutines", Type: "gauge", Help: "Number of goroutines that currently exist", Samples: []sample{
+				{Labels: []label{{"addr", "10.0.0.1:9851"}}, Value: 12},
+			}},
+		}},
+		{Name: "tile38", Families: []*family{
+			{Name: "tile38_up", Type: "gauge", Help: "Whether or not the Tile38 server is reachable", Samples: []sample{
+				{Labels: []label{{"addr", "10.0.0.2:9851"}}, Value: 1},
+				{Labels: []label{{"addr", "10.0.0.1:9851"}}, Value: 1},
+			}},
+			{Name: "tile38_num_points", Type: "gauge", Help: "Number of points in the database", Samples: []sample{
+				{Labels: []label{{"addr", "10.0.0.1:9851"}}, Value: 3},
+			}},
+		}},
+		{Name: "exporter", Families: []*family{
+			{Name: "tile38_exporter_phase_seconds", Type: "histogram", Help: "Time spent in a phase", Samples: []sample{
+				{Suffix: "_count", Labels: []label{{"phase", "render"}}, Value: 2},
+				{Suffix: "_bucket", Labels: []label{{"phase", "render"}, {"le", "+Inf"}}, Value: 2},
+				{Suffix: "_bucket", Labels: []label{{"phase", "render"}, {"le", "0.5"}}, Value: 2},
+				{Suffix: "_bucket", Labels: []label{{"phase", "render"}, {"le", "0.1"}}, Value: 1},
+				{Suffix: "_sum", Labels: []label{{"phase", "render"}}, Value: 0.3},
+			}},
+			{Name: "tile38_exporter_collector_success", Type: "gauge", Help: "Whether or not a collector succeeded", Samples: []sample{
+				{Labels: []label{{"collector", "tile38"}}, Value: 1},
+				{Labels: []label{{"collector", "go"}}, Value: 1},
+			}},
+		}},
+	}
+}
+
+func TestRenderGolden(t *testing.T) {
+	got := render(renderSections(), "")
+	checkGolden(t, "render.golden", got)
+
+	// The order doesn't depend on the order of families and samples
+	sections := renderSections()
+	for _, s := range sections {
+		for i, j := 0, len(s.Families)-1; i < j; i, j = i+1, j-1 {
+			s.Families[i], s.Families[j] = s.Families[j], s.Families[i]
+		}
+		for _, f := range s.Families {
+			for i, j := 0, len(f.Samples)-1; i < j; i, j = i+1, j-1 {
+				f.Samples[i], f.Samples[j] = f.Samples[j], f.Samples[i]
+			}
+		}
+	}
+	if again := render(sections, ""); again != got {
+		t.Errorf("output depends on the input order\nfirst:\n%s\nreordered:\n%s", got, again)
+	}
+}
+
+func TestNamespaced(t *testing.T) {
+	for _, tc := range []struct {
+		n, name, want string
+	}{
+		{"", "heap_alloc_bytes", "heap_alloc_bytes"},
+		{"", "tile38_num_points", "tile38_num_points"},
+		{"tile38", "heap_alloc_bytes", "tile38_heap_alloc_bytes"},
+		{"tile38", "tile38_num_points", "tile38_num_points"},
+		// Only the namespace followed by an underscore is a prefix
+		{"tile38", "tile38", "tile38_tile38"},
+		{"tile38", "tile38num_points", "tile38_tile38num_points"},
+		{"tile", "tile38_num_points", "tile_tile38_num_points"},
+	} {
+		if got := namespaced(tc.n, tc.name); got != tc.want {
+			t.Errorf("namespaced(%q, %q) = %q, want %q", tc.n, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRenderPathologicalText(t *testing.T) {
+	help := "Line one\nline two \\n not a newline, a \"quote\", C:\\path\\ and a trailing backslash \\"
+	value := "a \"quoted\"\nvalue \\ with { braces }"
+	sections := []section{{Name: "tile38", Families: []*family{
+		{Name: "tile38_weird_help", Type: "gauge", Help: help, Samples: []sample{
+			{Labels: []label{{"key", value}}, Value: 1}}},
+		{Name: "tile38-invalid.name", Type: "gauge", Help: help, Samples: []sample{{Value: 1}}},
+		{Name: "tile38_after", Type: "gauge", Help: "\n", Samples: []sample{{Value: 2}}},
+	}}}
+	out := render(sections, "")
+
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("parsing the output: %v\n%s", err, out)
+	}
+	mf, ok := mfs["tile38_weird_help"]
+	if !ok {
+		t.Fatalf("tile38_weird_help is missing:\n%s", out)
+	}
+	if got := mf.GetHelp(); got != help {
+		t.Errorf("HELP parsed as %q, want %q", got, help)
+	}
+	if got := mf.GetMetric()[0].GetLabel()[0].GetValue(); got != value {
+		t.Errorf("label value parsed as %q, want %q", got, value)
+	}
+	if got := mfs["tile38_after"].GetHelp(); got != "\n" {
+		t.Errorf("HELP parsed as %q, want a backslash and n", got)
+	}
+	if len(mfs) != 2 {
+		t.Errorf("parsed %d families, want the invalid name left out:\n%s", len(mfs), out)
+	}
+}
+
+func TestRenderGroupsFamilies(t *testing.T) {
+	sections := func() []section {
+		return []section{
+			{Name: "tile38", Families: []*family{
+				{Name: "tile38_num_points", Type: "gauge", Help: "Number of points", Samples: []sample{
+					{Labels: []label{{"addr", "10.0.0.1:9851"}}, Value: 3}}},
+			}},
+			{Name: "collections", Families: []*family{
+				// Named alike once in the namespace
+				{Name: "num_points", Type: "gauge", Help: "Points of the collection", Samples: []sample{
+					{Labels: []label{{"addr", "10.0.0.2:9851"}}, Value: 5},
+					{Labels: []label{{"addr", "10.0.0.1:9851"}}, Value: 4}}},
+				// Of another type
+				{Name: "tile38_num_points", Type: "counter", Samples: []sample{
+					{Labels: []label{{"addr", "10.0.0.3:9851"}}, Value: 6}}},
+			}},
+		}
+	}
+	in := sections()
+	out := render(in, "tile38")
+	if n := strings.Count(out, "# HELP tile38_num_points "); n != 1 {
+		t.Errorf("%d HELP lines, want 1:\n%s", n, out)
+	}
+	if n := strings.Count(out, "# TYPE tile38_num_points "); n != 1 {
+		t.Errorf("%d TYPE lines, want 1:\n%s", n, out)
+	}
+	mf := parseOutput(t, out)["tile38_num_points"]
+	if mf.GetType() != dto.MetricType_GAUGE || mf.GetHelp() != "Number of points" {
+		t.Errorf("got a %s with HELP %q, want the type and help of the first family", mf.GetType(), mf.GetHelp())
+	}
+	// The series already present and those of another type are left out
+	values := make(map[string]float64)
+	for _, m := range mf.GetMetric() {
+		values[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+	}
+	want := map[string]float64{"10.0.0.1:9851": 3, "10.0.0.2:9851": 5}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("got series %v, want %v", values, want)
+	}
+	if !strings.HasPrefix(out, "# Collector: tile38\n") || strings.Contains(out, "# Collector: collections") {
+		t.Errorf("the family isn't in the section where it's first seen:\n%s", out)
+	}
+	if !reflect.DeepEqual(in, sections()) {
+		t.Errorf("the passed sections were modified")
+	}
+}
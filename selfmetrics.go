@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// selfMetric is an operational metric of the exporter itself, such as a
+// count of rejected requests. Values are kept per set of label values.
+type selfMetric struct {
+	Name, Type, Help string
+	LabelNames       []string
+
+	fn      func() float64 // optional, computes the unlabeled value on render
+	buckets []float64      // upper bounds of the buckets of a histogram
+	mu      sync.Mutex
+	values  map[string]float64
+	labels  map[string][]string
+	hists   map[string]*histogram
+}
+
+// histogram holds the observations of a histogram for a set of label values
+type histogram struct {
+	counts     []float64 // cumulative count of each bucket
+	sum, count float64
+}
+
+// selfMetrics are all registered exporter metrics
+var selfMetrics struct {
+	sync.Mutex
+	list []*selfMetric
+}
+
+// newSelfMetric registers a new exporter metric
+func newSelfMetric(typ, name, help string, labelNames ...string) *selfMetric {
+	m := &selfMetric{Name: name, Type: typ, Help: help, LabelNames: labelNames,
+		values: make(map[string]float64), labels: make(map[string][]string)}
+	selfMetrics.Lock()
+	selfMetrics.list = append(selfMetrics.list, m)
+	selfMetrics.Unlock()
+	return m
+}
+
+// newSelfGaugeFunc registers a new unlabeled exporter gauge whose value is
+// computed by fn on every render
+func newSelfGaugeFunc(name, help string, fn func() float64) *selfMetric {
+	m := newSelfMetric("gauge", name, help)
+	m.fn = fn
+	return m
+}
+
+// newSelfHistogram registers a new exporter histogram with the passed bucket
+// upper bounds, which must be sorted
+func newSelfHistogram(name, help string, buckets []float64, labelNames ...string) *selfMetric {
+	m := newSelfMetric("histogram", name, help, labelNames...)
+	m.buckets = buckets
+	m.hists = make(map[string]*histogram)
+	return m
+}
+
+// Observe adds an observation to the histogram for the passed label values
+func (m *selfMetric) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.hists[key]
+	if !ok {
+		h = &histogram{counts: make([]float64, len(m.buckets))}
+		m.hists[key] = h
+		m.labels[key] = labelValues
+	}
+	for i, le := range m.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Add adds v to the value for the passed label values
+func (m *selfMetric) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	m.mu.Lock()
+	if _, ok := m.labels[key]; !ok {
+		m.labels[key] = labelValues
+	}
+	m.values[key] += v
+	m.mu.Unlock()
+}
+
+// Inc increments the value for the passed label values
+func (m *selfMetric) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+// Set sets the value for the passed label values
+func (m *selfMetric) Set(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	m.mu.Lock()
+	m.labels[key] = labelValues
+	m.values[key] = v
+	m.mu.Unlock()
+}
+
+// Reset removes the values of all label values
+func (m *selfMetric) Reset() {
+	m.mu.Lock()
+	m.values = make(map[string]float64)
+	m.labels = make(map[string][]string)
+	if m.hists != nil {
+		m.hists = make(map[string]*histogram)
+	}
+	m.mu.Unlock()
+}
+
+// family returns the metric as a family, or nil when it has no values yet
+func (m *selfMetric) family() *family {
+	f := &family{Name: m.Name, Type: m.Type, Help: m.Help}
+	if m.fn != nil {
+		f.Samples = append(f.Samples, sample{Value: m.fn()})
+		return f
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, h := range m.hists {
+		var labels []label
+		for i, lv := range m.labels[key] {
+			labels = append(labels, label{m.LabelNames[i], lv})
+		}
+		for i, le := range m.buckets {
+			f.Samples = append(f.Samples, sample{Suffix: "_bucket",
+				Labels: append(labels[:len(labels):len(labels)],
+					label{"le", strconv.FormatFloat(le, 'f', -1, 64)}),
+				Value: h.counts[i]})
+		}
+		f.Samples = append(f.Samples,
+			sample{Suffix: "_bucket", Labels: append(labels[:len(labels):len(labels)],
+				label{"le", "+Inf"}), Value: h.count},
+			sample{Suffix: "_sum", Labels: labels, Value: h.sum},
+			sample{Suffix: "_count", Labels: labels, Value: h.count})
+	}
+	for key, v := range m.values {
+		var labels []label
+		for i, lv := range m.labels[key] {
+			labels = append(labels, label{m.LabelNames[i], lv})
+		}
+		f.Samples = append(f.Samples, sample{Labels: labels, Value: v})
+	}
+	if len(f.Samples) == 0 && len(m.LabelNames) == 0 && m.hists == nil {
+		f.Samples = append(f.Samples, sample{})
+	}
+	if len(f.Samples) == 0 {
+		return nil
+	}
+	return f
+}
+
+// selfFamilies returns the families of all registered exporter metrics
+func selfFamilies() []*family {
+	selfMetrics.Lock()
+	list := append([]*selfMetric(nil), selfMetrics.list...)
+	selfMetrics.Unlock()
+	var fams []*family
+	for _, m := range list {
+		if f := m.family(); f != nil {
+			fams = append(fams, f)
+		}
+	}
+	return fams
+}
+
+// withSelfMetrics adds the exporter metrics to the "exporter" section
+func withSelfMetrics(sections []section) []section {
+	fams := selfFamilies()
+	for i := range sections {
+		if sections[i].Name == "exporter" {
+			sections[i].Families = append(sections[i].Families, fams...)
+			return sections
+		}
+	}
+	return append(sections, section{Name: "exporter", Families: fams})
+}
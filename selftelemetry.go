@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// selfTelemetryAddr is the address serving the metrics of the exporter
+// itself, apart from those of the Tile38 servers, or empty to serve them all
+// on --http-addr
+var selfTelemetryAddr string
+
+// lastSnapshot is the snapshot of the last scrape, whose exporter metrics are
+// served on selfTelemetryAddr when there is no background collection
+var lastSnapshot struct {
+	sync.Mutex
+	snap *snapshot
+}
+
+// servedSections returns the sections of the metrics endpoint: all of them,
+// or those of the Tile38 servers when the exporter's are served on
+// selfTelemetryAddr
+func servedSections(snap *snapshot) []section {
+	if selfTelemetryAddr == "" {
+		return withSelfMetrics(snap.sections())
+	}
+	lastSnapshot.Lock()
+	lastSnapshot.snap = snap
+	lastSnapshot.Unlock()
+	var sections []section
+	for _, s := range snap.sections() {
+		if s.Name != "exporter" {
+			sections = append(sections, s)
+		}
+	}
+	return sections
+}
+
+// selfSections returns the sections served on selfTelemetryAddr: the
+// exporter metrics of the latest snapshot, if any, along with the runtime of
+// the exporter process. Tile38 is never scraped for them.
+func selfSections() []section {
+	var snap *snapshot
+	if collectInterval > 0 {
+		latest.RLock()
+		snap = latest.snap
+		latest.RUnlock()
+	}
+	if snap == nil {
+		lastSnapshot.Lock()
+		snap = lastSnapshot.snap
+		lastSnapshot.Unlock()
+	}
+	var sections []section
+	if snap != nil {
+		for _, s := range snap.sections() {
+			if s.Name == "exporter" {
+				sections = append(sections, s)
+			}
+		}
+	}
+	return append(withSelfMetrics(sections), runtimeSection())
+}
+
+// runtimeSection returns the Go runtime metrics of the exporter process
+func runtimeSection() section {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return section{Name: "runtime", Families: []*family{
+		metric{"gauge", "go_goroutines", "Number of goroutines of the exporter"}.family(float64(runtime.NumGoroutine())),
+		metric{"gauge", "go_memstats_heap_alloc_bytes", "Heap bytes allocated and in use by the exporter"}.family(float64(ms.HeapAlloc)),
+		metric{"gauge", "go_memstats_sys_bytes", "Bytes obtained from the system by the exporter"}.family(float64(ms.Sys)),
+		metric{"counter", "go_gc_cycles_total", "Total number of garbage collections of the exporter"}.family(float64(ms.NumGC)),
+	}}
+}
+
+// handleSelfTelemetry serves the metrics of the exporter itself
+func handleSelfTelemetry(w http.ResponseWriter, r *http.Request, n string) {
+	sections, _ := relabel(selfSections())
+	out, contentType := renderNegotiated(r, sections, n, time.Now())
+	if err := checkRendered(out, contentType); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
+	writeBody(w, r, []byte(out))
+}
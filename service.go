@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/kardianos/service"
+)
+
+// program runs the exporter's http server under the platform's service
+// manager, or in the foreground when started interactively.
+type program struct {
+	server *http.Server
+	lns    []net.Listener
+
+	self   *http.Server // serves --web-self-telemetry-addr, if set
+	selfLn net.Listener
+}
+
+// Start starts serving in the background, as required by service managers
+func (p *program) Start(s service.Service) error {
+	// Serve sets a TLS config of its own for HTTP/2, so whether to serve
+	// TLS is decided before serving the first listener
+	useTLS := p.server.TLSConfig != nil
+	for _, ln := range p.lns {
+		go serve(p.server, ln, useTLS)
+	}
+	if p.self != nil {
+		go serve(p.self, p.selfLn, useTLS)
+	}
+	return nil
+}
+
+// serve serves the listener until the server is shut down
+func serve(server *http.Server, ln net.Listener, useTLS bool) {
+	var err error
+	if useTLS {
+		// The certificate is already in the TLS config
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		err = server.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		log.Fatalf("%s", err)
+	}
+}
+
+// Stop gracefully shuts down the http server, then closes the connections to
+// Tile38
+func (p *program) Stop(s service.Service) error {
+	if p.self != nil {
+		// Nothing there waits on Tile38
+		p.self.Close()
+	}
+	err := shutdown(p.server)
+	releaseLeaderLocks()
+	for _, t := range currentTargets() {
+		t.close()
+	}
+	if pidFile != "" {
+		removePidFile(pidFile)
+	}
+	return err
+}
+
+// shutdownTimeout is the time in-flight requests have to complete on
+// shutdown
+var shutdownTimeout time.Duration
+
+// webTimeouts bound the time of the connections of the http server, so that
+// slow or stuck clients can't hold them forever. Zero disables a timeout.
+var webTimeouts struct {
+	ReadHeader, Read, Write, Idle time.Duration
+}
+
+// connKey is the context key of the network connection of a request
+type connKey struct{}
+
+// withConn stores the network connection in the context of its requests
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// clearDeadlines lifts the read and write timeouts of the connection of a
+// long-lived response, such as /stream
+func clearDeadlines(r *http.Request) {
+	if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+		c.SetDeadline(time.Time{})
+	}
+}
+
+// checkWebTimeouts validates the timeouts of the http server. Scrapes must
+// be able to time out and answer before the write timeout cuts them off.
+func checkWebTimeouts() error {
+	t := webTimeouts
+	if t.ReadHeader < 0 || t.Read < 0 || t.Write < 0 || t.Idle < 0 {
+		return errors.New("--web-read-header-timeout, --web-read-timeout, --web-write-timeout and --web-idle-timeout must not be negative")
+	}
+	if t.Write > 0 && scrapeTimeout > 0 && t.Write < scrapeTimeout+scrapeTimeoutOffset {
+		return fmt.Errorf("--web-write-timeout must be at least --scrape-timeout plus %s", scrapeTimeoutOffset)
+	}
+	return nil
+}
+
+// inFlight is the number of requests being served
+var inFlight int64
+
+// countInFlight counts the requests being served by h in inFlight
+func countInFlight(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// shutdown stops the http server, waiting for in-flight requests to complete
+func shutdown(server *http.Server) error {
+	log.Printf("Shutting down, in_flight=%d timeout=%s", atomic.LoadInt64(&inFlight), shutdownTimeout)
+	sdNotify("STOPPING=1")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("%v with %d requests in flight", err, atomic.LoadInt64(&inFlight))
+	}
+	log.Printf("All requests completed")
+	return nil
+}
+
+// newService returns the system service of the exporter. The passed args are
+// the options the service manager starts the exporter with.
+func newService(prg service.Interface, args []string) (service.Service, error) {
+	return service.New(prg, &service.Config{
+		Name:        "tile38-prometheus",
+		DisplayName: "Tile38 Prometheus Exporter",
+		Description: "Exposes Tile38 statistics as Prometheus metrics.",
+		Arguments:   args,
+	})
+}
+
+// serviceManaged is set, through the hidden --service-managed flag, when the
+// exporter is started by the service manager
+var serviceManaged bool
+
+// runAs holds the user and group to switch to once the listener is bound
+var runAs struct{ User, Group string }
+
+// runService runs the server until it's stopped by the service manager, or
+// by an interrupt or terminate signal when running in the foreground. The
+// server listens on each of the comma separated addresses of server.Addr, and
+// self, if not nil, on its address.
+func runService(server, self *http.Server) {
+	// Bind the listeners before dropping privileges, so that privileged
+	// ports can be served by an unprivileged user.
+	var lns []net.Listener
+	for _, addr := range parseAddrs(server.Addr) {
+		ln, err := listen(addr)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			log.Fatalf("--http-addr %s: %v", addr, err)
+		}
+		if webProxyProtocol {
+			ln = proxyListener{ln}
+		}
+		lns = append(lns, ln)
+	}
+	if len(lns) == 0 {
+		log.Fatalf("no http address provided")
+	}
+	var selfLn net.Listener
+	if self != nil {
+		var err error
+		if selfLn, err = listen(self.Addr); err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			log.Fatalf("--web-self-telemetry-addr %s: %v", self.Addr, err)
+		}
+		if webProxyProtocol {
+			selfLn = proxyListener{selfLn}
+		}
+	}
+	// The pid file is written once the listeners are bound, so that it's
+	// never left behind by an exporter that failed to start, and before
+	// dropping privileges, as it usually lives in a directory of root
+	if pidFile != "" {
+		if err := writePidFile(pidFile, pidFileForce); err != nil {
+			log.Fatalf("pid file: %v", err)
+		}
+	}
+	if runAs.User != "" || runAs.Group != "" {
+		if err := dropPrivileges(runAs.User, runAs.Group); err != nil {
+			if pidFile != "" {
+				removePidFile(pidFile)
+			}
+			log.Fatalf("dropping privileges: %v", err)
+		}
+	}
+	prg := &program{server: server, lns: lns, self: self, selfLn: selfLn}
+	go notifyReady()
+	if !serviceManaged {
+		prg.Start(nil)
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+		<-sigc
+		if err := prg.Stop(nil); err != nil {
+			log.Fatalf("shutdown: %v", err)
+		}
+		return
+	}
+	svc, err := newService(prg, nil)
+	if err != nil {
+		log.Fatalf("service: %v", err)
+	}
+	// Route all logging to the platform's service log
+	logger, err := svc.Logger(nil)
+	if err != nil {
+		log.Fatalf("service: %v", err)
+	}
+	log.SetFlags(0)
+	log.SetOutput(serviceLog{logger})
+	if err := svc.Run(); err != nil {
+		log.Fatalf("service: %v", err)
+	}
+}
+
+// serviceCommand handles "tile38-prometheus service <action> [options]".
+// The options are validated and then passed to the service manager, which
+// starts the exporter with them.
+func serviceCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Printf("Usage: ./tile38-prometheus service <%s> [options]\n",
+			strings.Join(service.ControlAction[:], "|"))
+		os.Exit(1)
+	}
+	action, opts := args[0], args[1:]
+	if err := flag.CommandLine.Parse(opts); err != nil {
+		os.Exit(1)
+	}
+	if err := checkRunAs(runAs.User, runAs.Group); err != nil {
+		log.Fatalf("--user/--group: %v", err)
+	}
+	svc, err := newService(&program{}, append([]string{"--service-managed"}, opts...))
+	if err != nil {
+		log.Fatalf("service: %v", err)
+	}
+	if err := service.Control(svc, action); err != nil {
+		log.Fatalf("service %s: %v", action, err)
+	}
+	fmt.Printf("service %s: ok\n", action)
+}
+
+// serviceLog is an io.Writer that writes to the platform's service log
+type serviceLog struct{ logger service.Logger }
+
+func (l serviceLog) Write(p []byte) (int, error) {
+	if err := l.logger.Info(strings.TrimRight(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+)
+
+// shadow is the Tile38 server compared against the primary target, or nil
+// when shadow comparison is disabled
+var shadow *target
+
+var (
+	shadowUpMetric          = metric{"gauge", "tile38_shadow_up", "Whether or not the shadow Tile38 server could be scraped"}
+	shadowDiffMetric        = metric{"gauge", "tile38_shadow_diff", "Difference of a stat between the primary and shadow Tile38 servers"}
+	shadowObjectsDiffMetric = metric{"gauge", "tile38_shadow_collection_objects_diff", "Difference of the number of objects in a collection between the primary and shadow Tile38 servers"}
+)
+
+// shadowSection returns the families comparing the primary result with the
+// shadow result. Differences are primary minus shadow, and are omitted while
+// either server is down.
+func shadowSection(primary, sh targetResult) section {
+	up := shadowUpMetric.family(1)
+	s := section{Name: "shadow", Families: []*family{up}}
+	if sh.Err != nil {
+		up.Samples[0].Value = 0
+		return s
+	}
+	if primary.Err != nil {
+		return s
+	}
+
+	diff := shadowDiffMetric.emptyFamily()
+	for _, m := range tile38Metrics {
+		v := get(primary.Stats, m.Key) - get(sh.Stats, m.Key)
+		if math.IsNaN(v) {
+			continue
+		}
+		diff.Samples = append(diff.Samples, sample{
+			Labels: []label{{"metric", exportName(m.Key)}}, Value: v})
+	}
+	s.Families = append(s.Families, diff)
+
+	if collectionsOpts.Enabled {
+		p := collectionObjects(primary)
+		q := collectionObjects(sh)
+		for key := range q {
+			if _, ok := p[key]; !ok {
+				p[key] = 0
+			}
+		}
+		f := shadowObjectsDiffMetric.emptyFamily()
+		for key, n := range p {
+			f.Samples = append(f.Samples, sample{
+				Labels: []label{{"collection", key}}, Value: n - q[key]})
+		}
+		s.Families = append(s.Families, f)
+	}
+	return s
+}
+
+// collectionObjects returns the number of objects of every collection in the
+// result of the per-collection collector
+func collectionObjects(res targetResult) map[string]float64 {
+	objects := make(map[string]float64)
+	for _, s := range res.Sections {
+		for _, f := range s.Families {
+			if f.Name != "tile38_collection_num_objects" {
+				continue
+			}
+			for _, smp := range f.Samples {
+				for _, l := range smp.Labels {
+					if l.Name == "collection" && !math.IsNaN(smp.Value) {
+						objects[l.Value] = smp.Value
+					}
+				}
+			}
+		}
+	}
+	return objects
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+// shardAssignment is the shard of the targets collected by an exporter
+// replica, out of the number of replicas
+type shardAssignment struct {
+	Index int `yaml:"index"`
+	Count int `yaml:"count"`
+}
+
+// shardOpts splits the targets between exporter replicas. Each replica only
+// collects the targets that hash to its shard. The sharding of the
+// configuration file overrides it.
+var shardOpts shardAssignment
+
+// currentShard returns the shard assignment of this replica, from the
+// configuration file when it has one, or else from the flags
+func currentShard() shardAssignment {
+	if sa := currentConfig().Sharding; sa != nil {
+		return *sa
+	}
+	return shardOpts
+}
+
+var shardInfo = newSelfMetric("gauge", "tile38_exporter_shard_info",
+	"Shard assignment of this exporter replica", "shard_index", "shard_count")
+
+// validateShard checks the shard options
+func validateShard(index, count int) error {
+	if count < 1 {
+		return fmt.Errorf("--shard-count must be at least 1")
+	}
+	if index < 0 || index >= count {
+		return fmt.Errorf("--shard-index must be between 0 and %d", count-1)
+	}
+	return nil
+}
+
+// validate checks the sharding of the configuration file
+func (sa shardAssignment) validate() error {
+	if sa.Count < 1 {
+		return fmt.Errorf("count must be at least 1")
+	}
+	if sa.Index < 0 || sa.Index >= sa.Count {
+		return fmt.Errorf("index must be between 0 and %d", sa.Count-1)
+	}
+	return nil
+}
+
+// shardTargets splits the targets into the ones owned by the shard and the
+// ones skipped
+func shardTargets(all []*target, index, count int) (owned, skip []*target) {
+	for _, t := range all {
+		if shardOf(t.Addr, count) == index {
+			owned = append(owned, t)
+		} else {
+			skip = append(skip, t)
+		}
+	}
+	shardInfo.Reset()
+	shardInfo.Set(1, strconv.Itoa(index), strconv.Itoa(count))
+	return owned, skip
+}
+
+// shardOf returns the shard of a target address using jump consistent
+// hashing, so that changing the number of shards only moves the targets
+// that have to move.
+func shardOf(addr string, count int) int {
+	h := fnv.New64a()
+	h.Write([]byte(addr))
+	key := h.Sum64()
+	var b, j int64 = -1, 0
+	for j < int64(count) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// slowScrapeThreshold is the duration above which the collection of a target
+// is logged as slow. Zero disables the warnings.
+var slowScrapeThreshold time.Duration
+
+// slowScrapeLogInterval is the minimum interval between two slow scrape
+// warnings of the same target
+const slowScrapeLogInterval = time.Minute
+
+// warnIfSlow logs a warning when the collection of the target took longer
+// than the slow scrape threshold. Warnings are rate limited per target; the
+// number of slow collections not logged is included in the next warning.
+func (t *target) warnIfSlow(res targetResult, d time.Duration) {
+	if slowScrapeThreshold <= 0 || d < slowScrapeThreshold {
+		return
+	}
+	t.mu.Lock()
+	if time.Since(t.slowWarned) < slowScrapeLogInterval {
+		t.slowSuppressed++
+		t.mu.Unlock()
+		return
+	}
+	suppressed := t.slowSuppressed
+	t.slowWarned = time.Now()
+	t.slowSuppressed = 0
+	t.mu.Unlock()
+
+	log.Printf("level=warn msg=\"slow scrape\" target=%s duration=%s threshold=%s "+
+		"pool_get=%s command=%s parse=%s bytes=%d suppressed=%d",
+		t.Addr, d, slowScrapeThreshold, res.Phases["pool_get"],
+		res.Phases["command"], res.Phases["parse"], res.Bytes, suppressed)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// statChangesOpts configures the debug logging of stat changes between
+// background collections
+var statChangesOpts struct {
+	Enabled   bool
+	Threshold float64 // minimum relative change of numeric stats
+	Max       int     // maximum number of changes logged per collection
+}
+
+// statLogger remembers the stats of the previous collection of each target
+type statLogger struct {
+	prev map[*target]statsAt
+}
+
+// statsAt are the stats of a target collected at a time
+type statsAt struct {
+	Time  time.Time
+	Stats map[string]gjson.Result
+}
+
+// newStatLogger returns a stat logger to be called with every snapshot
+func newStatLogger() *statLogger {
+	return &statLogger{prev: make(map[*target]statsAt)}
+}
+
+// logChanges logs the SERVER stats of every target that changed since its
+// previous collection. Targets that weren't collected again are skipped.
+func (l *statLogger) logChanges(snap *snapshot) {
+	logged, suppressed := 0, 0
+	for _, res := range snap.Results {
+		if res.Err != nil {
+			continue
+		}
+		prev, ok := l.prev[res.Target]
+		if ok && !res.Time.After(prev.Time) {
+			continue
+		}
+		l.prev[res.Target] = statsAt{res.Time, res.Stats}
+		if !ok {
+			continue
+		}
+		keys := make([]string, 0, len(res.Stats))
+		for key := range res.Stats {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			old, cur := prev.Stats[key], res.Stats[key]
+			if !statChanged(old, cur, statChangesOpts.Threshold) {
+				continue
+			}
+			if logged >= statChangesOpts.Max {
+				suppressed++
+				continue
+			}
+			logged++
+			log.Printf("level=debug msg=\"stat changed\" target=%s key=%s old=%s new=%s",
+				res.Target.Addr, key, old.Raw, cur.Raw)
+		}
+	}
+	if suppressed > 0 {
+		log.Printf("level=debug msg=\"stat changes suppressed\" count=%d", suppressed)
+	}
+	// Forget the targets that are gone
+	for t := range l.prev {
+		if t.isClosed() {
+			delete(l.prev, t)
+		}
+	}
+}
+
+// statChanged returns true when a stat changed. Numbers must change by more
+// than the threshold relative to the old value.
+func statChanged(old, cur gjson.Result, threshold float64) bool {
+	if old.Type != gjson.Number || cur.Type != gjson.Number {
+		return old.Raw != cur.Raw
+	}
+	if old.Num == cur.Num {
+		return false
+	}
+	if old.Num == 0 {
+		return true
+	}
+	return math.Abs(cur.Num-old.Num)/math.Abs(old.Num) > threshold
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+var statusTmpl = template.Must(template.ParseFS(templatesFS, "templates/status.html"))
+
+// statusPage is the data rendered by the /status template
+type statusPage struct {
+	Refresh       int
+	LastCollect   string
+	Duration      string
+	SnapshotAge   string
+	LastError     string
+	LastErrorTime string
+	Shard         string
+	Targets       []statusTarget
+	Skipped       []string
+}
+
+// statusTarget is a row of the Tile38 table of the /status page
+type statusTarget struct {
+	Addr, Err              string
+	Up                     bool
+	Version, Role          string
+	Objects, Heap, MaxHeap string
+	AOFSize, Clients       string
+}
+
+// handleStatus renders a human readable overview of the targets and the
+// exporter's own health, from the same snapshot served by /metrics.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	snap := getSnapshot(r.Context())
+	page := statusPage{
+		Refresh:     10,
+		LastCollect: snap.Time.Format(time.RFC3339),
+		Duration:    snap.Duration.Round(time.Millisecond).String(),
+		SnapshotAge: time.Since(snap.Time).Round(time.Second).String(),
+	}
+	if sa := currentShard(); sa.Count > 1 {
+		page.Shard = fmt.Sprintf("%d of %d", sa.Index, sa.Count)
+	}
+	for _, t := range skippedTargets() {
+		page.Skipped = append(page.Skipped, t.Addr)
+	}
+	if collectInterval > 0 {
+		page.Refresh = int(math.Ceil(collectInterval.Seconds()))
+	}
+	health.Lock()
+	page.LastError = health.LastError
+	if !health.LastErrorTime.IsZero() {
+		page.LastErrorTime = health.LastErrorTime.Format(time.RFC3339)
+	}
+	health.Unlock()
+
+	for _, res := range snap.Results {
+		row := statusTarget{Addr: res.Target.Addr, Up: res.Err == nil}
+		if res.Err != nil {
+			row.Err = res.Err.Error()
+			page.Targets = append(page.Targets, row)
+			continue
+		}
+		stats := res.Stats
+		row.Version = stats["tile38_version"].String()
+		row.Role = role(stats)
+		row.Objects = formatNum(get(stats, "tile38_num_objects"))
+		row.Heap = formatBytes(get(stats, "heap_alloc_bytes"))
+		row.MaxHeap = formatBytes(get(stats, "tile38_max_heap_size"))
+		row.AOFSize = formatBytes(get(stats, "tile38_aof_size"))
+		row.Clients = formatNum(get(stats, "tile38_connected_clients"))
+		page.Targets = append(page.Targets, row)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTmpl.Execute(w, page); err != nil {
+		log.Printf("status: %v", err)
+	}
+}
+
+// role returns the replication role of a Tile38 server, from the replication
+// fields of plain SERVER merged into the stats of a scrape
+func role(stats map[string]gjson.Result) string {
+	following := stats["following"].String()
+	if following == "" {
+		return "leader"
+	}
+	if caught, ok := stats["caught_up"]; ok && !caught.Bool() {
+		return "follower of " + following + ", catching up"
+	}
+	return "follower of " + following
+}
+
+// formatNum formats a stat value for display
+func formatNum(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatBytes formats a size in bytes for display, e.g. 1.5 MB
+func formatBytes(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", v, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
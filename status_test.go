@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"html"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFollowerRole(t *testing.T) {
+	leader, follower := newFakeTile38(t), newFakeTile38(t)
+	follower.follow(leader.Addr, true)
+	useSnapshot(t, newTestTarget(t, leader), newTestTarget(t, follower))
+
+	rec := httptest.NewRecorder()
+	handleStatus(rec, httptest.NewRequest("GET", "/status", nil))
+	page := html.UnescapeString(rec.Body.String())
+	if want := "follower of " + leader.Addr; !strings.Contains(page, want) {
+		t.Errorf("status page without %q:\n%s", want, page)
+	}
+
+	want := map[string]string{leader.Addr: "leader", follower.Addr: "follower of " + leader.Addr}
+	for _, res := range scrape(context.Background(), currentTargets()) {
+		row := newTopRow(res, statsAt{})
+		if row.Role != want[res.Target.Addr] {
+			t.Errorf("top shows %s as %q, want %q", res.Target.Addr, row.Role, want[res.Target.Addr])
+		}
+	}
+}
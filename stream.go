@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+)
+
+// streamOpts configures the /stream endpoint
+var streamOpts struct {
+	Enabled    bool
+	MaxClients int
+}
+
+// streamHub fans out the metric values that changed after each background
+// collection to all connected /stream clients.
+type streamHub struct {
+	namespace string
+
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+	values  map[string]float64 // latest value of every series
+}
+
+// stream is the hub of the /stream endpoint, or nil when it is disabled
+var stream *streamHub
+
+var streamClients = newSelfMetric("gauge", "tile38_exporter_stream_clients",
+	"Number of connected /stream clients")
+
+// newStreamHub returns a hub publishing series names in the passed namespace
+func newStreamHub(namespace string) *streamHub {
+	return &streamHub{namespace: namespace, clients: make(map[chan []byte]bool),
+		values: make(map[string]float64)}
+}
+
+// seriesValues returns the value of every series of the sections, keyed by
+// the series name with its labels
+func seriesValues(sections []section, n string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, s := range sections {
+		for _, f := range s.Families {
+			name := finalName(n, f.Name)
+			for _, smp := range f.Samples {
+				values[name+smp.Suffix+labelsString(smp.Labels)] = smp.Value
+			}
+		}
+	}
+	return values
+}
+
+// publish sends the series that changed since the previous snapshot to all
+// clients
+func (h *streamHub) publish(snap *snapshot) {
+	sections, _ := relabel(snap.sections())
+	values := seriesValues(sections, h.namespace)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	changed := make(map[string]float64)
+	for k, v := range values {
+		if old, ok := h.values[k]; !ok || !sameValue(old, v) {
+			changed[k] = v
+		}
+	}
+	h.values = values
+	if len(changed) == 0 {
+		return
+	}
+	event := streamEvent(changed)
+	for ch := range h.clients {
+		select {
+		case ch <- event:
+		default:
+			// the client is too slow, drop the event
+		}
+	}
+}
+
+// sameValue returns true when a and b are equal, treating NaNs as equal
+func sameValue(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+// streamEvent encodes values as a JSON object. NaN values are encoded as null.
+func streamEvent(values map[string]float64) []byte {
+	obj := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			obj[k] = nil
+		} else {
+			obj[k] = v
+		}
+	}
+	data, _ := json.Marshal(obj)
+	return data
+}
+
+// subscribe registers a new client, returning its event channel along with
+// the current values. It returns false when the maximum number of clients is
+// already connected.
+func (h *streamHub) subscribe() (chan []byte, []byte, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if streamOpts.MaxClients > 0 && len(h.clients) >= streamOpts.MaxClients {
+		return nil, nil, false
+	}
+	ch := make(chan []byte, 16)
+	h.clients[ch] = true
+	streamClients.Set(float64(len(h.clients)))
+	return ch, streamEvent(h.values), true
+}
+
+// unsubscribe removes a client
+func (h *streamHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	streamClients.Set(float64(len(h.clients)))
+	h.mu.Unlock()
+}
+
+// handleStream holds a Server-Sent Events connection, sending the current
+// values once and then the changed values after every background collection.
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", 500)
+		return
+	}
+	ch, initial, ok := stream.subscribe()
+	if !ok {
+		http.Error(w, "too many stream clients", 503)
+		return
+	}
+	defer stream.unsubscribe(ch)
+	clearDeadlines(r)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "data: %s\n\n", initial)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// strictMode fails scrapes for which a stat of the SERVER collectors is
+// missing from the reply of a reachable Tile38 server
+var strictMode bool
+
+var strictFailures = newSelfMetric("counter", "tile38_exporter_strict_failures_total",
+	"Total number of scrapes failed with --strict due to stats missing from the replies of Tile38")
+
+// checkStrict returns an error listing the stats missing from the SERVER
+// replies of the snapshot, per target. The stats of disabled collectors and
+// those dropped by relabel rules don't count, nor do unreachable targets,
+// which are reported through tile38_up.
+func checkStrict(snap *snapshot) error {
+	if !strictMode {
+		return nil
+	}
+	var expected []metric
+	for _, c := range collectors {
+		switch c.Name {
+		case "go":
+			expected = append(expected, goMetrics...)
+		case "tile38":
+			expected = append(expected, tile38Metrics...)
+		}
+	}
+	rules := nameRules(currentConfig().Relabel)
+	var lines []string
+	for _, res := range snap.Results {
+		if res.Err != nil {
+			continue
+		}
+		var missing []string
+		for _, m := range expected {
+			if _, ok := lookup(res.Stats, m.Key); ok {
+				continue
+			}
+			for _, name := range exportNames(m.Key) {
+				if _, ok := relabelSeries(rules, name, nil); ok {
+					missing = append(missing, m.Key)
+					break
+				}
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			lines = append(lines, fmt.Sprintf("%s: missing %s", res.Target.Addr,
+				strings.Join(missing, ", ")))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	strictFailures.Inc()
+	return fmt.Errorf("stats missing from the replies of Tile38:\n%s", strings.Join(lines, "\n"))
+}
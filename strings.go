@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+// metricNameRE matches valid prometheus metric names
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// stringConfig maps a Tile38 string object to a gauge. The value of the
+// string is parsed as a float, or when Path is set, the value is treated as a
+// JSON document and the number at Path is used.
+type stringConfig struct {
+	Metric string `yaml:"metric"`
+	Help   string `yaml:"help"`
+	Key    string `yaml:"key"`
+	ID     string `yaml:"id"`
+	Path   string `yaml:"path"`
+}
+
+// validate checks the string entry for errors
+func (sc stringConfig) validate() error {
+	if !metricNameRE.MatchString(sc.Metric) {
+		return fmt.Errorf("invalid metric name %q", sc.Metric)
+	}
+	if sc.Key == "" || sc.ID == "" {
+		return fmt.Errorf("metric %s: key and id are required", sc.Metric)
+	}
+	return nil
+}
+
+// help returns the help text of the metric of the string entry
+func (sc stringConfig) help() string {
+	if sc.Help == "" {
+		return fmt.Sprintf("Value of the %s/%s string", sc.Key, sc.ID)
+	}
+	return sc.Help
+}
+
+// stringParseFailuresMetric counts the values of each string entry that are
+// not numbers
+var stringParseFailuresMetric = metric{"counter", "tile38_string_parse_failures_total", "Total number of string values that could not be parsed as a number"}
+
+// collectStrings GETs every configured string object and exports its value.
+// Missing objects and values that cannot be parsed are left out, unless
+// missingAsNaN is set, and counted as missing stats; parse failures are also
+// counted per entry.
+func collectStrings(t *target, conn redis.Conn, _ map[string]gjson.Result) ([]*family, error) {
+	failures := stringParseFailuresMetric.emptyFamily()
+	strs := currentConfig().Strings
+	if len(strs) == 0 {
+		return nil, nil
+	}
+	cmds := make([]command, len(strs))
+	for i, sc := range strs {
+		cmds[i] = command{"GET", []interface{}{sc.Key, sc.ID}}
+	}
+	replies := doAll(conn, cmds)
+	var fams []*family
+	missing := 0
+	for i, sc := range strs {
+		val, ok := math.NaN(), false
+		out, err := replies[i].Out, replies[i].Err
+		if err == nil {
+			v, err := parseString(gjson.Get(out, "object").String(), sc.Path)
+			if err != nil {
+				t.inc("string_parse_failures:" + sc.Metric)
+			} else {
+				val, ok = v, true
+			}
+		} else if !isNotFound(err) {
+			return nil, err
+		}
+		if ok || missingAsNaN {
+			fams = append(fams, &family{Name: sc.Metric, Type: "gauge", Help: sc.help(),
+				Samples: []sample{{Value: val}}})
+		} else {
+			missing++
+		}
+		failures.Samples = append(failures.Samples, sample{
+			Labels: []label{{"metric", sc.Metric}},
+			Value:  t.counter("string_parse_failures:" + sc.Metric),
+		})
+	}
+	return append(fams, failures, missingFamily(missing)), nil
+}
+
+// parseString parses a string value as a number, or extracts the number at
+// path when the value is a JSON document
+func parseString(s, path string) (float64, error) {
+	if path == "" {
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	}
+	v := gjson.Get(s, path)
+	switch v.Type {
+	case gjson.Number:
+		return v.Num, nil
+	case gjson.String:
+		return strconv.ParseFloat(strings.TrimSpace(v.Str), 64)
+	default:
+		return 0, fmt.Errorf("no number at path %q", path)
+	}
+}
+
+// isNotFound returns true when err is a Tile38 "key not found" or
+// "id not found" error
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return msg == "key not found" || msg == "id not found"
+}
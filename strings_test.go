@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCollectStringsMissing(t *testing.T) {
+	defer func(c *config, nan bool) { setConfig(c); missingAsNaN = nan }(currentConfig(), missingAsNaN)
+	setConfig(&config{Strings: []stringConfig{
+		{Metric: "app_zero", Key: "settings", ID: "zero"},
+		{Metric: "app_missing", Key: "settings", ID: "missing"},
+		{Metric: "app_invalid", Key: "settings", ID: "invalid"},
+	}})
+	f := newFakeTile38(t)
+	f.setString("settings", "zero", "0")
+	f.setString("settings", "invalid", "not a number")
+	tg := newTestTarget(t, f)
+
+	for _, nan := range []bool{false, true} {
+		missingAsNaN = nan
+		conn := tg.Pool.Get()
+		fams, err := collectStrings(tg, conn, nil)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fams, missing := takeMissing(fams)
+		values := make(map[string]float64)
+		for _, f := range fams {
+			if f.Name != stringParseFailuresMetric.Key {
+				values[f.Name] = f.Samples[0].Value
+			}
+		}
+		if v, ok := values["app_zero"]; !ok || v != 0 {
+			t.Errorf("missing as NaN %t: the present zero is %v, exported %t", nan, v, ok)
+		}
+		for _, name := range []string{"app_missing", "app_invalid"} {
+			v, ok := values[name]
+			switch {
+			case nan && (!ok || !math.IsNaN(v)):
+				t.Errorf("%s is %v, exported %t, want NaN", name, v, ok)
+			case !nan && ok:
+				t.Errorf("%s is exported as %v, want it left out", name, v)
+			}
+		}
+		if want := map[bool]int{false: 2, true: 0}[nan]; missing != want {
+			t.Errorf("missing as NaN %t: %d missing stats, want %d", nan, missing, want)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+// targets are the Tile38 servers being scraped, and skipped are the ones
+// owned by other exporter replicas. Both change when targets are discovered.
+var (
+	targetsMu  sync.RWMutex
+	targets    []*target
+	skipped    []*target
+	allTargets []*target // both of the above, as passed to setTargets
+)
+
+// targetsChanged is signaled when the targets change
+var targetsChanged = make(chan struct{}, 1)
+
+// currentTargets returns the targets being scraped
+func currentTargets() []*target {
+	targetsMu.RLock()
+	defer targetsMu.RUnlock()
+	return append([]*target(nil), targets...)
+}
+
+// skippedTargets returns the targets owned by other exporter replicas
+func skippedTargets() []*target {
+	targetsMu.RLock()
+	defer targetsMu.RUnlock()
+	return append([]*target(nil), skipped...)
+}
+
+// setTargets replaces all targets, keeping the ones in this exporter's shard
+func setTargets(all []*target) {
+	sa := currentShard()
+	owned, skip := shardTargets(all, sa.Index, sa.Count)
+	if len(owned) == 0 && len(all) > 0 {
+		log.Printf("no targets in shard %d of %d", sa.Index, sa.Count)
+	}
+	targetsMu.Lock()
+	targets, skipped, allTargets = owned, skip, all
+	targetsMu.Unlock()
+	select {
+	case targetsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// reshard splits the targets again after the shard assignment changed
+func reshard() {
+	targetsMu.RLock()
+	all := allTargets
+	targetsMu.RUnlock()
+	setTargets(all)
+}
+
+// owned returns whether the target is collected by this exporter replica
+func (t *target) owned() bool {
+	targetsMu.RLock()
+	defer targetsMu.RUnlock()
+	for _, o := range targets {
+		if o == t {
+			return true
+		}
+	}
+	return false
+}
+
+// target is a single Tile38 server that is scraped by the exporter
+type target struct {
+	Addr   string
+	Labels []label // labels added to all samples, from discovery
+	Pool   *redis.Pool
+	creds  *credentials
+	tls    *tls.Config // nil for plain connections
+	dials  backoff
+	cb     breaker
+
+	mu             sync.Mutex
+	closed         bool
+	counters       map[string]float64 // counters kept across scrapes, by name
+	slowWarned     time.Time          // time of the last slow scrape warning
+	slowSuppressed int                // slow scrapes not warned about since
+	protected      bool               // rejected by protected mode
+	protectedSeen  bool               // protected mode rejection was logged
+	leader         bool               // holds the expensive collectors lock
+}
+
+// timeouts bound the time spent connecting to Tile38 and waiting on its
+// replies, so that an unreachable server fails the scrape instead of hanging
+// it. KeepAlive is the period of TCP keepalive probes, 0 for none.
+var timeouts struct {
+	Connect, ReadWrite, KeepAlive time.Duration
+}
+
+// poolOpts configures the connection pool of every target
+var poolOpts struct {
+	MaxIdle, MaxActive int
+	IdleTimeout        time.Duration
+	Wait               bool
+	TestIdle           time.Duration // PING connections idle for longer on reuse
+	MaxConnLifetime    time.Duration // retire connections older than this on reuse
+}
+
+// newTarget creates a target and its connection pooler, which is responsible
+// for maintaining stable connections to the Tile38 server.
+func newTarget(addr string, creds *credentials) *target {
+	t := &target{Addr: addr, creds: creds, tls: tile38TLS,
+		counters: make(map[string]float64)}
+	t.Pool = &redis.Pool{Dial: t.dial, MaxIdle: poolOpts.MaxIdle,
+		MaxActive: poolOpts.MaxActive, IdleTimeout: poolOpts.IdleTimeout,
+		Wait: poolOpts.Wait, MaxConnLifetime: poolOpts.MaxConnLifetime}
+	t.Pool.TestOnBorrow = t.testOnBorrow
+	return t
+}
+
+// dial opens a new connection to the target. The network connection is kept
+// to abort the commands of cancelled scrapes.
+func (t *target) dial() (redis.Conn, error) {
+	if isHTTPAddr(t.Addr) {
+		return t.dialHTTP()
+	}
+	if isWebSocketAddr(t.Addr) {
+		return t.dialWS()
+	}
+	network, address := splitAddr(t.Addr)
+	var nc net.Conn
+	opts := []redis.DialOption{
+		redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+			var err error
+			nc, err = dialNet(newDialer(), network, addr)
+			return nc, err
+		}),
+		redis.DialReadTimeout(timeouts.ReadWrite),
+		redis.DialWriteTimeout(timeouts.ReadWrite),
+	}
+	useTLS := t.tls != nil && network == "tcp"
+	if useTLS {
+		opts = append(opts, redis.DialUseTLS(true), redis.DialTLSConfig(t.tls))
+	}
+	if err := t.dials.check(); err != nil {
+		return nil, err
+	}
+	conn, err := redis.Dial(network, address, opts...)
+	if err != nil {
+		err = dialError(err, useTLS)
+	}
+	t.dials.record(err)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.setup(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return abortConn{conn, nc, time.Now()}, nil
+}
+
+// setup authenticates a new connection and switches it to JSON replies
+func (t *target) setup(conn redis.Conn) error {
+	// AUTH goes first, as servers with a password reject all other
+	// commands, OUTPUT included, until authenticated
+	err := t.creds.authenticate(conn, t.Addr)
+	if err == nil {
+		_, err = do(conn, "OUTPUT", "json")
+	}
+	t.checkProtectedMode(err)
+	return err
+}
+
+// newDialer returns the dialer of the connections to Tile38
+func newDialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeouts.Connect, KeepAlive: timeouts.KeepAlive}
+	if timeouts.KeepAlive == 0 {
+		dialer.KeepAlive = -1 // zero would use the Go default
+	}
+	return dialer
+}
+
+// dialNet connects to Tile38 with the dialer, through the proxy when one is
+// configured
+func dialNet(dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	if tile38Proxy != nil && network == "tcp" {
+		return dialProxy(dialer, network, addr)
+	}
+	return dialer.Dial(network, addr)
+}
+
+// errConnRetired rejects the reuse of connections past their lifetime
+var errConnRetired = errors.New("connection reached its maximum lifetime")
+
+// testOnBorrow checks that a connection idle since idle is still alive before
+// it is reused, as idle connections die unnoticed when Tile38 restarts.
+// Connections past their lifetime are retired here rather than by the pool,
+// which would do so silently.
+func (t *target) testOnBorrow(conn redis.Conn, idle time.Time) error {
+	if ac, ok := conn.(abortConn); ok && poolOpts.MaxConnLifetime > 0 {
+		if age := time.Since(ac.created); age >= poolOpts.MaxConnLifetime {
+			debugf("msg=\"retiring connection\" target=%s age=%s", describeAddr(t.Addr), age.Round(time.Second))
+			return errConnRetired
+		}
+	}
+	if poolOpts.TestIdle <= 0 || time.Since(idle) < poolOpts.TestIdle {
+		return nil
+	}
+	_, err := do(conn, "PING")
+	return err
+}
+
+// isBrokenConn reports whether err is a failure of the connection itself,
+// such as a connection closed by a restarted server, rather than an error
+// replied by Tile38, a timeout or a failure to connect
+func isBrokenConn(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var op *net.OpError
+	var pe proxyError
+	if (errors.As(err, &op) && op.Op == "dial") || errors.As(err, &pe) {
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && !ne.Timeout()
+}
+
+// close closes the connection pool of a target that is no longer scraped
+func (t *target) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.Pool.Close()
+}
+
+// isClosed returns true when the target is no longer scraped
+func (t *target) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// labels returns the labels identifying the target's samples. The shard
+// label follows the active configuration.
+func (t *target) labels(addrLabel bool) []label {
+	var labels []label
+	if addrLabel {
+		labels = append(labels, label{"addr", t.Addr})
+	}
+	if sc, ok := currentConfig().shardOf(t.Addr); ok {
+		labels = append(labels, label{"shard", sc.Name})
+	}
+	return append(labels, t.Labels...)
+}
+
+// inc increments a counter of the target
+func (t *target) inc(name string) {
+	t.mu.Lock()
+	t.counters[name]++
+	t.mu.Unlock()
+}
+
+// counter returns the value of a counter of the target
+func (t *target) counter(name string) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.counters[name]
+}
+
+// parseAddrs splits a comma separated list of Tile38 addresses
+func parseAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// splitAddr returns the network and address to dial for a target address,
+// which is either host:port or unix:///path/to/socket
+func splitAddr(addr string) (network, address string) {
+	if strings.HasPrefix(addr, "unix://") {
+		return "unix", strings.TrimPrefix(addr, "unix://")
+	}
+	return "tcp", addr
+}
+
+// describeAddr returns a target address for logging, with socket paths
+// shown as such
+func describeAddr(addr string) string {
+	if network, address := splitAddr(addr); network == "unix" {
+		return address + " (unix socket)"
+	}
+	return addr
+}
+
+// targetResult is the outcome of scraping a single target
+type targetResult struct {
+	Target   *target
+	Stats    map[string]gjson.Result
+	Sections []section
+	Phases   phases
+	Bytes    int       // size of the SERVER reply
+	Time     time.Time // start of the scrape
+	Err      error
+}
+
+// scrape collects all targets concurrently. The results are returned in the
+// same order as the passed targets.
+func scrape(ctx context.Context, targets []*target) []targetResult {
+	results := make([]targetResult, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *target) {
+			defer wg.Done()
+			results[i] = t.scrape(ctx)
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+var (
+	collectorSuccessMetric = metric{"gauge", "tile38_exporter_collector_success", "Whether or not a collector succeeded on the last scrape"}
+	collectorSkippedMetric = metric{"gauge", "tile38_exporter_collector_skipped", "Whether or not a collector was skipped on the last scrape, by reason"}
+)
+
+// scrape retrieves the SERVER stats from the target and runs all collectors
+// against them. A section is returned for every collector, even on failure,
+// so that merged output keeps the collector order.
+func (t *target) scrape(ctx context.Context) targetResult {
+	start := time.Now()
+	res := targetResult{Target: t, Phases: make(phases), Time: start}
+	var conn redis.Conn
+	var out, plain string
+	err := t.cb.allow()
+	if err == nil {
+		conn, out, plain, err = t.serverStats(ctx, res.Phases)
+		defer conn.Close()
+		t.cb.record(err)
+	}
+	res.Bytes = len(out)
+
+	var stats map[string]gjson.Result
+	if err != nil {
+		res.Err = err
+	} else {
+		start := time.Now()
+		stats = parseStats(out, plain)
+		res.Stats = stats
+		res.Phases.add("parse", time.Since(start))
+	}
+	success := collectorSuccessMetric.emptyFamily()
+	skipped := collectorSkippedMetric.emptyFamily()
+	missing := 0
+	rewriting := reduceLoadDuringRewrite && res.Err == nil &&
+		stats["tile38_aof_rewrite_in_progress"].Bool()
+	for _, c := range collectors {
+		s := section{Name: c.Name}
+		ok := 0.0
+		if reason := skipReason(t, c, rewriting); reason != "" {
+			skipped.Samples = append(skipped.Samples, sample{
+				Labels: []label{{"collector", c.Name}, {"reason", reason}},
+				Value:  1})
+		} else if res.Err == nil {
+			// Collectors mostly wait on Tile38, so their time counts
+			// towards the command phase.
+			start := time.Now()
+			fams, err := safeCollect(c, t, conn, stats)
+			res.Phases.add("command", time.Since(start))
+			if err != nil {
+				log.Printf("collector %s on %s: %v", c.Name, t.Addr, err)
+			} else {
+				var n int
+				s.Families, n = takeMissing(fams)
+				s.Families = renameFamilies(s.Families)
+				missing += n
+				ok = 1
+			}
+		}
+		success.Samples = append(success.Samples, sample{
+			Labels: []label{{"collector", c.Name}}, Value: ok})
+		res.Sections = append(res.Sections, s)
+	}
+	up := upMetric.family(1)
+	if res.Err != nil {
+		up.Samples[0].Value = 0
+	}
+	for i := range res.Sections {
+		if res.Sections[i].Name == "tile38" {
+			res.Sections[i].Families = append(res.Sections[i].Families, up)
+		}
+	}
+	exporter := section{Name: "exporter", Families: append([]*family{success,
+		t.protectedModeFamily(), readyFamily(res), missingFamily(missing)}, t.poolFamilies()...)}
+	exporter.Families = append(exporter.Families, t.dials.families()...)
+	if breakerOpts.Failures > 0 {
+		exporter.Families = append(exporter.Families, t.cb.families()...)
+	}
+	if len(skipped.Samples) > 0 {
+		exporter.Families = append(exporter.Families, skipped)
+	}
+	if leaderOpts.Enabled {
+		exporter.Families = append(exporter.Families, t.leaderFamily())
+	}
+	res.Sections = append(res.Sections, exporter)
+	t.warnIfSlow(res, time.Since(start))
+	return res
+}
+
+// skipReason returns why a collector is skipped on this scrape of the
+// target, or an empty string when it runs
+func skipReason(t *target, c collector, rewriting bool) string {
+	if !expensiveCollectors[c.Name] {
+		return ""
+	}
+	if rewriting {
+		return "aof_rewrite"
+	}
+	if leaderOpts.Enabled && !t.isLeader() {
+		return "not_leader"
+	}
+	return ""
+}
+
+// omitZeroDynamic drops the labeled series of the data collectors whose
+// value is zero
+var omitZeroDynamic bool
+
+// zeroDynamicSections are the collectors whose labeled series are data about
+// objects of the database, such as collections. The labeled series of the
+// other collectors, such as collector_success or query_success, are markers
+// whose zero is meaningful.
+var zeroDynamicSections = map[string]bool{"collections": true}
+
+// isZeroDynamic returns true when the sample is a labeled series of a data
+// collector with a zero value. Histograms are never dropped.
+func isZeroDynamic(s section, f *family, smp sample) bool {
+	return smp.Value == 0 && len(smp.Labels) > 0 &&
+		zeroDynamicSections[s.Name] && f.Type != "histogram"
+}
+
+// mergeSections combines the sections of all target results so that every
+// family appears exactly once, holding the samples of all targets. A family
+// is placed in the section where it is first seen. Samples are labeled with
+// the labels of the target that produced them, and with its address when
+// addrLabel is set. When stamp is set, samples carry the time their target
+// was collected.
+func mergeSections(results []targetResult, addrLabel, stamp bool) []section {
+	var merged []section
+	sectionIdx := make(map[string]int)
+	families := make(map[string]*family)
+	for _, res := range results {
+		tl := res.Target.labels(addrLabel)
+		for _, s := range res.Sections {
+			i, ok := sectionIdx[s.Name]
+			if !ok {
+				i = len(merged)
+				sectionIdx[s.Name] = i
+				merged = append(merged, section{Name: s.Name})
+			}
+			for _, f := range s.Families {
+				g, ok := families[f.Name]
+				if !ok {
+					g = &family{Name: f.Name, Type: f.Type, Help: f.Help}
+					families[f.Name] = g
+					merged[i].Families = append(merged[i].Families, g)
+				}
+				for _, smp := range f.Samples {
+					if omitZeroDynamic && isZeroDynamic(s, f, smp) {
+						continue
+					}
+					if len(tl) > 0 {
+						smp.Labels = append(tl[:len(tl):len(tl)], smp.Labels...)
+					}
+					if stamp {
+						smp.Timestamp = res.Time.UnixNano() / int64(time.Millisecond)
+					}
+					g.Samples = append(g.Samples, smp)
+				}
+			}
+		}
+	}
+	return merged
+}
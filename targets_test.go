@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// useTargets makes the targets the ones being scraped, until the end of the
+// test
+func useTargets(t *testing.T, targets ...*target) {
+	count := shardOpts.Count
+	shardOpts.Count = 1
+	setTargets(targets)
+	t.Cleanup(func() {
+		setTargets(nil)
+		shardOpts.Count = count
+	})
+}
+
+func TestScrapeMultipleTargets(t *testing.T) {
+	var targets []*target
+	for i := 0; i < 3; i++ {
+		f := newFakeTile38(t)
+		f.setStat("tile38_connected_clients", i+1)
+		targets = append(targets, newTestTarget(t, f))
+	}
+	results := scrape(context.Background(), targets)
+	for _, res := range results {
+		if res.Err != nil {
+			t.Fatalf("scraping %s: %v", res.Target.Addr, res.Err)
+		}
+	}
+	out := render(mergeSections(results, true, false), "")
+
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("parsing the output: %v\n%s", err, out)
+	}
+	for _, name := range []string{"tile38_connected_clients", "tile38_up", "heap_alloc_bytes"} {
+		mf, ok := mfs[name]
+		if !ok {
+			t.Errorf("%s is missing", name)
+			continue
+		}
+		if n := len(mf.GetMetric()); n != 3 {
+			t.Errorf("%s has %d series, want one per target", name, n)
+		}
+		if n := strings.Count(out, "# TYPE "+name+" "); n != 1 {
+			t.Errorf("%s has %d TYPE lines, want 1", name, n)
+		}
+	}
+	addrs := make(map[string]bool)
+	for _, m := range mfs["tile38_connected_clients"].GetMetric() {
+		for _, lp := range m.GetLabel() {
+			if lp.GetName() == "addr" {
+				addrs[lp.GetValue()] = true
+			}
+		}
+	}
+	for _, tg := range targets {
+		if !addrs[tg.Addr] {
+			t.Errorf("no series labeled with addr %s", tg.Addr)
+		}
+	}
+}
+
+func TestOmitZeroDynamic(t *testing.T) {
+	defer func(v bool) { omitZeroDynamic = v }(omitZeroDynamic)
+	omitZeroDynamic = true
+
+	tg := &target{Addr: "10.0.0.1:9851"}
+	// The objects of a collection go from 1 to 0 and back, while the
+	// markers stay at zero
+	for _, objects := range []float64{1, 0, 1} {
+		res := targetResult{Target: tg, Sections: []section{
+			{Name: "collections", Families: []*family{
+				{Name: "tile38_collection_num_objects", Type: "gauge", Samples: []sample{
+					{Labels: []label{{"collection", "fleet"}}, Value: objects}}},
+				{Name: "tile38_collections_dropped", Type: "gauge", Samples: []sample{{}}},
+			}},
+			{Name: "queries", Families: []*family{
+				{Name: "tile38_query_success", Type: "gauge", Samples: []sample{
+					{Labels: []label{{"query", "nearby"}}}}},
+			}},
+			{Name: "exporter", Families: []*family{
+				{Name: "tile38_exporter_collector_success", Type: "gauge", Samples: []sample{
+					{Labels: []label{{"collector", "collections"}}}}},
+			}},
+		}}
+		got := make(map[string]int)
+		for _, s := range mergeSections([]targetResult{res}, true, false) {
+			for _, f := range s.Families {
+				got[f.Name] = len(f.Samples)
+			}
+		}
+		want := map[string]int{
+			"tile38_collection_num_objects":     1,
+			"tile38_collections_dropped":        1,
+			"tile38_query_success":              1,
+			"tile38_exporter_collector_success": 1,
+		}
+		if objects == 0 {
+			want["tile38_collection_num_objects"] = 0
+		}
+		for name, n := range want {
+			if got[name] != n {
+				t.Errorf("objects %v: %s has %d series, want %d", objects, name, got[name], n)
+			}
+		}
+	}
+}
+
+func TestScrapeAfterDroppedConns(t *testing.T) {
+	defer func(n int, d time.Duration) { poolOpts.MaxIdle, poolOpts.TestIdle = n, d }(poolOpts.MaxIdle, poolOpts.TestIdle)
+	poolOpts.MaxIdle = 1
+	// Without testing, the dead connection is only found by the scrape,
+	// which retries on a new one
+	for _, testIdle := range []time.Duration{0, time.Nanosecond} {
+		poolOpts.TestIdle = testIdle
+		f := newFakeTile38(t)
+		tg := newTestTarget(t, f)
+		for i := 0; i < 3; i++ {
+			// Tile38 restarts between scrapes
+			f.dropConns()
+			if res := tg.scrape(context.Background()); res.Err != nil {
+				t.Fatalf("test idle %s, scrape %d: %v", testIdle, i, res.Err)
+			}
+		}
+		if n := f.connections(); n != 3 {
+			t.Errorf("test idle %s: %d connections, want one per scrape", testIdle, n)
+		}
+	}
+}
+
+func TestScrapeFollower(t *testing.T) {
+	defer func(p bool) { pipelineCommands = p }(pipelineCommands)
+	for _, pipeline := range []bool{true, false} {
+		pipelineCommands = pipeline
+		f := newFakeTile38(t)
+		f.follow("10.0.0.1:9851", false)
+		res := newTestTarget(t, f).scrape(context.Background())
+		if res.Err != nil {
+			t.Fatalf("pipeline %t: %v", pipeline, res.Err)
+		}
+		if got, want := role(res.Stats), "follower of 10.0.0.1:9851, catching up"; got != want {
+			t.Errorf("pipeline %t: got role %q, want %q", pipeline, got, want)
+		}
+		values := make(map[string]float64)
+		for _, s := range res.Sections {
+			for _, f := range s.Families {
+				if len(f.Samples) == 1 {
+					values[f.Name] = f.Samples[0].Value
+				}
+			}
+		}
+		for _, m := range []metric{caughtUpMetric, readyMetric} {
+			if v, ok := values[m.Key]; !ok || v != 0 {
+				t.Errorf("pipeline %t: got %s %v (exported %t), want 0", pipeline, m.Key, v, ok)
+			}
+		}
+	}
+}
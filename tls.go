@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// tlsOpts configures TLS connections to the Tile38 servers
+var tlsOpts struct {
+	Enabled    bool
+	CAFile     string
+	SkipVerify bool
+	CertFile   string
+	KeyFile    string
+}
+
+// clientCert is the certificate presented to Tile38. It is read at startup
+// and again when a reload is requested, but not on every dial.
+type clientCert struct {
+	certFile, keyFile string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+// tile38ClientCert is the client certificate, or nil when none is configured
+var tile38ClientCert *clientCert
+
+// tile38TLS is the TLS configuration of new targets, or nil when TLS is
+// disabled
+var tile38TLS *tls.Config
+
+// newTLSConfig returns the TLS configuration of the options, or nil when TLS
+// is disabled. The CA file is read once, here.
+func newTLSConfig() (*tls.Config, error) {
+	if !tlsOpts.Enabled && tlsOpts.CAFile == "" && !tlsOpts.SkipVerify &&
+		tlsOpts.CertFile == "" && tlsOpts.KeyFile == "" {
+		return nil, nil
+	}
+	c := &tls.Config{InsecureSkipVerify: tlsOpts.SkipVerify}
+	if tlsOpts.CertFile != "" || tlsOpts.KeyFile != "" {
+		if tlsOpts.CertFile == "" || tlsOpts.KeyFile == "" {
+			return nil, errors.New("--tile38-tls-cert-file and --tile38-tls-key-file must be set together")
+		}
+		cc := &clientCert{certFile: tlsOpts.CertFile, keyFile: tlsOpts.KeyFile}
+		if err := cc.load(); err != nil {
+			return nil, err
+		}
+		tile38ClientCert = cc
+		c.GetClientCertificate = cc.get
+	}
+	if tlsOpts.CAFile != "" {
+		pem, err := ioutil.ReadFile(tlsOpts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", tlsOpts.CAFile)
+		}
+	}
+	return c, nil
+}
+
+// load reads the certificate and key files, checking that they parse and
+// belong together
+func (c *clientCert) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("client certificate: %v", err)
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+// get returns the certificate for a handshake
+func (c *clientCert) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
+
+// reloadOnHangup reads the client certificate, the certificate of the HTTPS
+// server and the bearer token file again on SIGHUP. The current ones are kept
+// when the files fail to load.
+func reloadOnHangup() {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP)
+	for range sigc {
+		if tile38ClientCert != nil {
+			if err := tile38ClientCert.load(); err != nil {
+				log.Printf("level=warn msg=\"reload failed, keeping the current certificate\" err=%q", err)
+			} else {
+				log.Printf("Reloaded the client certificate")
+			}
+		}
+		if webServerCert != nil {
+			webServerCert.reload()
+		}
+		if bearerToken != nil {
+			bearerToken.reload()
+		}
+	}
+}
+
+// dialError distinguishes the failures of the TLS handshake from the
+// failures to connect, such as refused connections
+func dialError(err error, useTLS bool) error {
+	var op *net.OpError
+	var pe proxyError
+	if !useTLS || (errors.As(err, &op) && op.Op == "dial") || errors.As(err, &pe) {
+		return err
+	}
+	return fmt.Errorf("tls handshake: %w", err)
+}
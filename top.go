@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// topOpts configures the terminal dashboard
+var topOpts struct {
+	Enabled  bool
+	Interval time.Duration
+}
+
+// topRow is a line of the terminal dashboard
+type topRow struct {
+	Addr, Up, Role, Objects, Heap, Clients, AOF, CmdRate string
+}
+
+// runTop shows a refreshing overview of the targets in the terminal until q
+// or Ctrl-C is pressed. The targets are collected like they are for
+// /metrics.
+func runTop() {
+	quit := make(chan struct{})
+	nl := "\n"
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		// Raw mode reads single key presses, and delivers Ctrl-C as a
+		// key instead of a signal.
+		if state, err := term.MakeRaw(fd); err == nil {
+			defer term.Restore(fd, state)
+			nl = "\r\n"
+		}
+		go func() {
+			r := bufio.NewReader(os.Stdin)
+			for {
+				b, err := r.ReadByte()
+				if err != nil || b == 'q' || b == 'Q' || b == 3 {
+					close(quit)
+					return
+				}
+			}
+		}()
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+
+	prev := make(map[*target]statsAt)
+	for {
+		snap := collect(context.Background())
+		var rows []topRow
+		for _, res := range snap.Results {
+			rows = append(rows, newTopRow(res, prev[res.Target]))
+			if res.Err == nil {
+				prev[res.Target] = statsAt{res.Time, res.Stats}
+			}
+		}
+		fmt.Print(topScreen(snap.Time, rows, nl))
+		select {
+		case <-quit:
+			fmt.Print(nl)
+			return
+		case <-sigc:
+			fmt.Print(nl)
+			return
+		case <-time.After(topOpts.Interval - time.Since(snap.Time)):
+		}
+	}
+}
+
+// newTopRow returns the dashboard line of a target result. The command rate
+// is computed from the previous stats of the target, if any.
+func newTopRow(res targetResult, prev statsAt) topRow {
+	row := topRow{Addr: res.Target.Addr, Up: "up"}
+	if res.Err != nil {
+		row.Up = "DOWN"
+		row.Role = res.Err.Error()
+		return row
+	}
+	stats := res.Stats
+	row.Role = role(stats)
+	row.Objects = formatNum(get(stats, "tile38_num_objects"))
+	row.Heap = formatBytes(get(stats, "heap_alloc_bytes"))
+	if max := get(stats, "tile38_max_heap_size"); max > 0 {
+		row.Heap += " / " + formatBytes(max)
+	}
+	row.Clients = formatNum(get(stats, "tile38_connected_clients"))
+	row.AOF = formatBytes(get(stats, "tile38_aof_size"))
+	row.CmdRate = "-"
+	if prev.Stats != nil {
+		d := res.Time.Sub(prev.Time).Seconds()
+		n := get(stats, "tile38_total_commands_processed") -
+			get(prev.Stats, "tile38_total_commands_processed")
+		if d > 0 && n >= 0 {
+			row.CmdRate = fmt.Sprintf("%.1f", n/d)
+		}
+	}
+	return row
+}
+
+// topScreen renders the dashboard, clearing the terminal first
+func topScreen(at time.Time, rows []topRow, nl string) string {
+	header := topRow{"ADDRESS", "UP", "ROLE", "OBJECTS", "HEAP / MAX", "CLIENTS",
+		"AOF", "CMD/S"}
+	all := append([]topRow{header}, rows...)
+	cols := make([][]string, len(all))
+	widths := make([]int, 8)
+	for i, r := range all {
+		cols[i] = []string{r.Addr, r.Up, r.Objects, r.Heap, r.Clients, r.AOF,
+			r.CmdRate, r.Role}
+		for j, c := range cols[i] {
+			if len(c) > widths[j] {
+				widths[j] = len(c)
+			}
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&sb, "tile38-prometheus  %s  every %s, q to quit%s%s",
+		at.Format("15:04:05"), topOpts.Interval, nl, nl)
+	for i, c := range cols {
+		if i == 0 {
+			sb.WriteString("\x1b[1m")
+		}
+		for j, v := range c {
+			if j == len(c)-1 {
+				sb.WriteString(v)
+			} else {
+				fmt.Fprintf(&sb, "%-*s  ", widths[j], v)
+			}
+		}
+		if i == 0 {
+			sb.WriteString("\x1b[0m")
+		}
+		sb.WriteString(nl)
+	}
+	return sb.String()
+}
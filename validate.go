@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// validateOutput parses every rendered metrics document back before it's
+// written, answering 500 when Prometheus would refuse it
+var validateOutput bool
+
+var outputValidationFailures = newSelfMetric("counter", "tile38_exporter_output_validation_failures_total",
+	"Total number of rendered metrics documents refused by the parsers of Prometheus")
+
+// checkOutput parses a rendered document of the passed content type with the
+// parsers of Prometheus, returning their error. The text and protobuf formats
+// are checked; the other formats have no parser to check them with.
+func checkOutput(out, contentType string) error {
+	switch contentType {
+	case expositionContentType:
+		var parser expfmt.TextParser
+		_, err := parser.TextToMetricFamilies(strings.NewReader(out))
+		return err
+	case protobufContentType:
+		dec := expfmt.NewDecoder(strings.NewReader(out), expfmt.FmtProtoDelim)
+		for {
+			var mf dto.MetricFamily
+			if err := dec.Decode(&mf); errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRendered checks a rendered document under --validate-output, counting
+// the failures
+func checkRendered(out, contentType string) error {
+	if !validateOutput {
+		return nil
+	}
+	if err := checkOutput(out, contentType); err != nil {
+		outputValidationFailures.Inc()
+		log.Printf("level=warn msg=\"rendered metrics refused by the parser\" err=%q", err)
+		return fmt.Errorf("invalid output: %v", err)
+	}
+	return nil
+}
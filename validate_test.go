@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestCheckOutput(t *testing.T) {
+	sections := renderSections()
+	for _, tc := range []struct {
+		name, out, contentType string
+		ok                     bool
+	}{
+		{"text", render(sections, ""), expositionContentType, true},
+		{"protobuf", renderProtobuf(sections, ""), protobufContentType, true},
+		{"empty", "", expositionContentType, true},
+		{"duplicate TYPE", "# TYPE a gauge\na 1\n# TYPE a gauge\na{b=\"c\"} 2\n", expositionContentType, false},
+		{"invalid value", "a one\n", expositionContentType, false},
+		{"unescaped label", "a{b=\"c\nd\"} 1\n", expositionContentType, false},
+		{"truncated protobuf", renderProtobuf(sections, "")[:20], protobufContentType, false},
+		// Formats without a parser aren't checked
+		{"openmetrics", "not checked", openMetricsContentType, true},
+	} {
+		if err := checkOutput(tc.out, tc.contentType); (err == nil) != tc.ok {
+			t.Errorf("%s: got error %v, want valid %t", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestCheckRendered(t *testing.T) {
+	defer func(v bool) { validateOutput = v }(validateOutput)
+	const invalid = "a one\n"
+	validateOutput = false
+	if err := checkRendered(invalid, expositionContentType); err != nil {
+		t.Errorf("checked without --validate-output: %v", err)
+	}
+	validateOutput = true
+	before := selfValue(outputValidationFailures)
+	if err := checkRendered(invalid, expositionContentType); err == nil {
+		t.Errorf("invalid output accepted")
+	}
+	if err := checkRendered(render(renderSections(), ""), expositionContentType); err != nil {
+		t.Errorf("valid output refused: %v", err)
+	}
+	if got := selfValue(outputValidationFailures) - before; got != 1 {
+		t.Errorf("counted %v failures, want 1", got)
+	}
+}
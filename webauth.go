@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// webAuthOpts configures the basic auth or bearer token required from
+// scrapers
+var webAuthOpts struct {
+	UsersFile    string
+	Token        string
+	TokenFile    string
+	ExemptHealth bool // serve the health endpoints without credentials
+
+	users map[string]string // of the web configuration file
+}
+
+// basicAuthUsers are the bcrypt hashed passwords of the users allowed to
+// scrape, by user name, or nil when basic auth is disabled
+var basicAuthUsers map[string][]byte
+
+// basicAuthDummy is compared against the passwords of unknown users, so that
+// they take as long to reject as wrong passwords
+var basicAuthDummy []byte
+
+// bearerToken is the token of scrapers, or nil when no token is configured
+var bearerToken *webToken
+
+// authLogInterval is the minimum interval between two warnings of failed
+// authentication
+const authLogInterval = time.Minute
+
+// authFailures counts the failed requests not logged yet
+var authFailures struct {
+	mu         sync.Mutex
+	warned     time.Time
+	suppressed int
+}
+
+// loadBasicAuthUsers reads the users file, in the format of the Prometheus
+// exporter toolkit:
+//
+//	basic_auth_users:
+//	  prometheus: $2y$10$...
+func loadBasicAuthUsers(path string) (map[string][]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Users map[string]string `yaml:"basic_auth_users"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(file.Users) == 0 {
+		return nil, fmt.Errorf("%s: no basic_auth_users", path)
+	}
+	users, err := parseBasicAuthUsers(file.Users)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return users, nil
+}
+
+// parseBasicAuthUsers checks the bcrypt hashes of the users
+func parseBasicAuthUsers(hashes map[string]string) (map[string][]byte, error) {
+	users := make(map[string][]byte, len(hashes))
+	for user, hash := range hashes {
+		// Only the user is reported, the hash is a secret too
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("user %q: invalid bcrypt hash", user)
+		}
+		users[user] = []byte(hash)
+	}
+	return users, nil
+}
+
+// setupWebAuth loads the users file and the bearer token, when configured
+func setupWebAuth() error {
+	if webAuthOpts.Token != "" && webAuthOpts.TokenFile != "" {
+		return errors.New("--web-bearer-token and --web-bearer-token-file are mutually exclusive")
+	}
+	if webAuthOpts.Token != "" || webAuthOpts.TokenFile != "" {
+		wt := &webToken{file: webAuthOpts.TokenFile, token: []byte(webAuthOpts.Token)}
+		if err := wt.load(); err != nil {
+			return err
+		}
+		bearerToken = wt
+	}
+	var users map[string][]byte
+	var err error
+	switch {
+	case webAuthOpts.UsersFile != "":
+		users, err = loadBasicAuthUsers(webAuthOpts.UsersFile)
+	case len(webAuthOpts.users) > 0:
+		users, err = parseBasicAuthUsers(webAuthOpts.users)
+	case webAuthOpts.ExemptHealth && bearerToken == nil:
+		return errors.New("--web-auth-exempt-health requires basic auth or a bearer token")
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dummy, err := bcrypt.GenerateFromPassword([]byte("tile38-prometheus"), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	basicAuthUsers, basicAuthDummy = users, dummy
+	return nil
+}
+
+// webToken is the bearer token of scrapers. A token read from a file is read
+// again on SIGHUP, and the current token is kept when the file fails to load.
+type webToken struct {
+	file string
+
+	mu    sync.Mutex
+	token []byte
+}
+
+// load reads the token file, if any. Errors never include the token.
+func (t *webToken) load() error {
+	if t.file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(t.file)
+	if err != nil {
+		return err
+	}
+	token := bytes.TrimSpace(data)
+	if len(token) == 0 {
+		return fmt.Errorf("%s: empty bearer token", t.file)
+	}
+	t.mu.Lock()
+	t.token = token
+	t.mu.Unlock()
+	return nil
+}
+
+// reload reads the token file again, logging the outcome
+func (t *webToken) reload() {
+	if t.file == "" {
+		return
+	}
+	if err := t.load(); err != nil {
+		log.Printf("level=error msg=\"reloading the bearer token failed, keeping the current one\" err=%q", err)
+	} else {
+		log.Printf("Reloaded the bearer token")
+	}
+}
+
+// check reports whether the token matches, in constant time
+func (t *webToken) check(token string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return subtle.ConstantTimeCompare(t.token, []byte(token)) == 1
+}
+
+// checkBasicAuth reports whether the request carries the credentials of a
+// user. Every user name is compared, in constant time, and a password is
+// always checked, so that the time taken does not tell which users exist.
+func checkBasicAuth(r *http.Request) (string, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	hash := basicAuthDummy
+	found := 0
+	for name, h := range basicAuthUsers {
+		if subtle.ConstantTimeCompare([]byte(name), []byte(user)) == 1 {
+			hash = h
+			found = 1
+		}
+	}
+	err := bcrypt.CompareHashAndPassword(hash, []byte(pass))
+	return user, err == nil && found == 1
+}
+
+// warnAuthFailed logs a failed request. Warnings are rate limited; the
+// number of failures not logged is included in the next warning.
+func warnAuthFailed(r *http.Request, scheme, user string) {
+	authFailures.mu.Lock()
+	if time.Since(authFailures.warned) < authLogInterval {
+		authFailures.suppressed++
+		authFailures.mu.Unlock()
+		return
+	}
+	suppressed := authFailures.suppressed
+	authFailures.warned = time.Now()
+	authFailures.suppressed = 0
+	authFailures.mu.Unlock()
+
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	log.Printf("level=warn msg=\"authentication failed\" remote=%s scheme=%s user=%q path=%s suppressed=%d",
+		remote, scheme, user, r.URL.Path, suppressed)
+}
+
+// checkAuth reports whether the request carries a valid bearer token or the
+// credentials of a user, returning the scheme and user tried for logging
+func checkAuth(r *http.Request) (scheme, user string, ok bool) {
+	h := r.Header.Get("Authorization")
+	if bearerToken != nil && len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return "bearer", "", bearerToken.check(h[7:])
+	}
+	if basicAuthUsers != nil {
+		user, ok := checkBasicAuth(r)
+		return "basic", user, ok
+	}
+	return "bearer", "", false
+}
+
+// requireAuth rejects the requests without a valid bearer token or the
+// credentials of a user, other than those of the health endpoints, when they
+// are exempted
+func requireAuth(h http.Handler) http.Handler {
+	if basicAuthUsers == nil && bearerToken == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if webAuthOpts.ExemptHealth && isHealthPath(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if scheme, user, ok := checkAuth(r); !ok {
+			// Browsers ask without credentials first
+			if r.Header.Get("Authorization") != "" {
+				warnAuthFailed(r, scheme, user)
+			}
+			if basicAuthUsers != nil {
+				w.Header().Add("WWW-Authenticate", `Basic realm="tile38-prometheus", charset="UTF-8"`)
+			}
+			if bearerToken != nil {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="tile38-prometheus"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// webConfigFile is the path of the web configuration file, in the format of
+// the Prometheus exporter toolkit, or empty for none
+var webConfigFile string
+
+// webConfig is the web configuration file. Unknown fields are rejected, so
+// that typos are caught at startup.
+type webConfig struct {
+	TLSServerConfig  *tlsServerConfig  `yaml:"tls_server_config"`
+	HTTPServerConfig httpServerConfig  `yaml:"http_server_config"`
+	BasicAuthUsers   map[string]string `yaml:"basic_auth_users"`
+}
+
+// tlsServerConfig is the tls_server_config of the web configuration file
+type tlsServerConfig struct {
+	CertFile                 string   `yaml:"cert_file"`
+	KeyFile                  string   `yaml:"key_file"`
+	ClientAuthType           string   `yaml:"client_auth_type"`
+	ClientCAFile             string   `yaml:"client_ca_file"`
+	MinVersion               string   `yaml:"min_version"`
+	MaxVersion               string   `yaml:"max_version"`
+	CipherSuites             []string `yaml:"cipher_suites"`
+	PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"` // ignored, as by Go
+}
+
+// httpServerConfig is the http_server_config of the web configuration file
+type httpServerConfig struct {
+	HTTP2   *bool             `yaml:"http2"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// webHTTPServer holds the http_server_config of the web configuration file
+var webHTTPServer struct {
+	DisableHTTP2 bool
+	Headers      map[string]string
+}
+
+// webTLSVersions are the TLS versions of the web configuration file, as
+// accepted by --web-tls-min-version
+var webTLSVersions = map[string]string{"TLS12": "1.2", "TLS13": "1.3"}
+
+// webClientAuthTypes are the client_auth_type values of the web
+// configuration file
+var webClientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// loadWebConfig reads and validates the web configuration file at path.
+// Relative file names in it are relative to its directory.
+func loadWebConfig(path string) (*webConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &webConfig{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := c.validate(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// validate checks the configuration for errors, resolving the file names
+// relative to dir
+func (c *webConfig) validate(dir string) error {
+	if tc := c.TLSServerConfig; tc != nil {
+		if tc.CertFile == "" || tc.KeyFile == "" {
+			return errors.New("tls_server_config: cert_file and key_file are required")
+		}
+		for _, name := range []*string{&tc.CertFile, &tc.KeyFile, &tc.ClientCAFile} {
+			if *name != "" && !filepath.IsAbs(*name) {
+				*name = filepath.Join(dir, *name)
+			}
+		}
+		for _, v := range []string{tc.MinVersion, tc.MaxVersion} {
+			if _, ok := webTLSVersions[v]; v != "" && !ok {
+				return fmt.Errorf("tls_server_config: unsupported tls version %q, expected TLS12 or TLS13", v)
+			}
+		}
+		if tc.ClientAuthType != "" {
+			if _, ok := webClientAuthTypes[tc.ClientAuthType]; !ok {
+				return fmt.Errorf("tls_server_config: invalid client_auth_type %q", tc.ClientAuthType)
+			}
+		}
+		for _, name := range tc.CipherSuites {
+			if cipherSuiteID(name) == 0 {
+				return fmt.Errorf("tls_server_config: unknown or insecure cipher suite %q", name)
+			}
+		}
+	}
+	if _, err := parseBasicAuthUsers(c.BasicAuthUsers); err != nil {
+		return fmt.Errorf("basic_auth_users: %v", err)
+	}
+	return nil
+}
+
+// cipherSuiteID returns the id of the secure cipher suite of the name, or 0
+func cipherSuiteID(name string) uint16 {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == name {
+			return cs.ID
+		}
+	}
+	return 0
+}
+
+// applyWebConfig loads the web configuration file, when one is given, in
+// place of the --web-tls-* flags and --web-basic-auth-users
+func applyWebConfig() error {
+	if webConfigFile == "" {
+		return nil
+	}
+	if webTLSEnabled() || webTLSOpts.ClientCA != "" || webTLSOpts.AllowedCN != "" ||
+		webTLSOpts.MinVersion != "1.2" || webAuthOpts.UsersFile != "" {
+		return errors.New("the --web-tls-* flags and --web-basic-auth-users can't be combined with --web.config.file")
+	}
+	c, err := loadWebConfig(webConfigFile)
+	if err != nil {
+		return err
+	}
+	if tc := c.TLSServerConfig; tc != nil {
+		webTLSOpts.CertFile, webTLSOpts.KeyFile = tc.CertFile, tc.KeyFile
+		webTLSOpts.ClientCA = tc.ClientCAFile
+		if tc.MinVersion != "" {
+			webTLSOpts.MinVersion = webTLSVersions[tc.MinVersion]
+		}
+		webTLSOpts.MaxVersion = webTLSVersions[tc.MaxVersion]
+		webTLSOpts.ClientAuthType = tc.ClientAuthType
+		for _, name := range tc.CipherSuites {
+			webTLSOpts.CipherSuites = append(webTLSOpts.CipherSuites, cipherSuiteID(name))
+		}
+	}
+	webAuthOpts.users = c.BasicAuthUsers
+	if c.HTTPServerConfig.HTTP2 != nil {
+		webHTTPServer.DisableHTTP2 = !*c.HTTPServerConfig.HTTP2
+	}
+	webHTTPServer.Headers = c.HTTPServerConfig.Headers
+	return nil
+}
+
+// newWebServer returns the http server of the exporter, with the timeouts of
+// the flags and the http_server_config of the web configuration file
+func newWebServer(addr string, tlsConfig *tls.Config, h http.Handler) *http.Server {
+	s := &http.Server{Addr: addr, TLSConfig: tlsConfig, Handler: countInFlight(logAccess(recoverPanics(withHeaders(h)))),
+		ReadHeaderTimeout: webTimeouts.ReadHeader, ReadTimeout: webTimeouts.Read,
+		WriteTimeout: webTimeouts.Write, IdleTimeout: webTimeouts.Idle, ConnContext: withConn}
+	if webHTTPServer.DisableHTTP2 {
+		// A non-nil map disables the automatic HTTP/2 support
+		s.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return s
+}
+
+// withHeaders sets the headers of the web configuration file on every
+// response
+func withHeaders(h http.Handler) http.Handler {
+	if len(webHTTPServer.Headers) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range webHTTPServer.Headers {
+			w.Header().Set(k, v)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webhookOpts configures the notifications of targets becoming reachable or
+// unreachable
+var webhookOpts struct {
+	URL      string
+	Debounce time.Duration // time a new state must last to be notified
+	Retries  int
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	webhookSent = newSelfMetric("counter", "tile38_exporter_webhook_notifications_total",
+		"Number of state transitions notified to the webhook")
+	webhookErrors = newSelfMetric("counter", "tile38_exporter_webhook_errors_total",
+		"Number of failed webhook deliveries, including retries")
+)
+
+// upState tracks the reachability of a target for notifications
+type upState struct {
+	notified string    // last notified, or first seen, state
+	pending  string    // state waiting for the debounce window, if any
+	since    time.Time // time the pending state was first seen
+}
+
+// upStates are the states of all targets
+var upStates struct {
+	sync.Mutex
+	m map[*target]*upState
+}
+
+// webhookEvent is the payload posted to the webhook
+type webhookEvent struct {
+	Target        string `json:"target"`
+	PreviousState string `json:"previous_state"`
+	State         string `json:"state"`
+	Error         string `json:"error,omitempty"`
+	Timestamp     string `json:"timestamp"`
+}
+
+// notifyTransitions posts an event to the webhook for every target whose
+// reachability changed and stayed changed for the debounce window. The first
+// state of a target is never notified.
+func notifyTransitions(results []targetResult) {
+	if webhookOpts.URL == "" {
+		return
+	}
+	upStates.Lock()
+	defer upStates.Unlock()
+	if upStates.m == nil {
+		upStates.m = make(map[*target]*upState)
+	}
+	for _, res := range results {
+		state := "up"
+		if res.Err != nil {
+			state = "down"
+		}
+		st, ok := upStates.m[res.Target]
+		if !ok {
+			upStates.m[res.Target] = &upState{notified: state}
+			continue
+		}
+		if state == st.notified {
+			st.pending = ""
+			continue
+		}
+		if state != st.pending {
+			st.pending, st.since = state, res.Time
+		}
+		if res.Time.Sub(st.since) < webhookOpts.Debounce {
+			continue
+		}
+		event := webhookEvent{Target: res.Target.Addr, PreviousState: st.notified,
+			State: state, Timestamp: res.Time.UTC().Format(time.RFC3339)}
+		if res.Err != nil {
+			event.Error = res.Err.Error()
+		}
+		st.notified, st.pending = state, ""
+		go deliver(event)
+	}
+	for t := range upStates.m {
+		if t.isClosed() {
+			delete(upStates.m, t)
+		}
+	}
+}
+
+// deliver posts an event to the webhook, retrying with a growing delay
+func deliver(event webhookEvent) {
+	data, _ := json.Marshal(event)
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		err := post(data)
+		if err == nil {
+			webhookSent.Inc()
+			return
+		}
+		webhookErrors.Inc()
+		if attempt >= webhookOpts.Retries {
+			log.Printf("webhook: giving up on %s %s: %v", event.Target, event.State, err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends a payload to the webhook
+func post(data []byte) error {
+	resp, err := webhookClient.Post(webhookOpts.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
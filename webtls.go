@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webTLSOpts configures HTTPS on the listener of the exporter, and the
+// client certificates required from scrapers
+var webTLSOpts struct {
+	CertFile     string
+	KeyFile      string
+	MinVersion   string
+	ClientCA     string
+	AllowedCN    string // comma separated, empty for any
+	ExemptHealth bool   // serve the health endpoints without a client certificate
+
+	// Set by the web configuration file only
+	MaxVersion     string
+	CipherSuites   []uint16
+	ClientAuthType string // empty for the default of the client CA
+}
+
+// webTLSEnabled reports whether the exporter serves over HTTPS
+func webTLSEnabled() bool {
+	return webTLSOpts.CertFile != "" || webTLSOpts.KeyFile != ""
+}
+
+// newWebTLSConfig returns the TLS configuration of the listener, or nil when
+// serving plain HTTP. The certificate is loaded once, here.
+func newWebTLSConfig() (*tls.Config, error) {
+	if !webTLSEnabled() {
+		return nil, nil
+	}
+	if webTLSOpts.CertFile == "" || webTLSOpts.KeyFile == "" {
+		return nil, errors.New("--web-tls-cert-file and --web-tls-key-file must be set together")
+	}
+	wc := &webCert{certFile: webTLSOpts.CertFile, keyFile: webTLSOpts.KeyFile}
+	if err := wc.load(); err != nil {
+		return nil, err
+	}
+	webServerCert = wc
+	c := &tls.Config{GetCertificate: wc.get}
+	switch webTLSOpts.MinVersion {
+	case "1.2":
+		c.MinVersion = tls.VersionTLS12
+	case "1.3":
+		c.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("--web-tls-min-version must be 1.2 or 1.3, not %q", webTLSOpts.MinVersion)
+	}
+	if webTLSOpts.MaxVersion == "1.2" {
+		c.MaxVersion = tls.VersionTLS12
+	}
+	c.CipherSuites = webTLSOpts.CipherSuites
+	clientAuth, custom := webClientAuthTypes[webTLSOpts.ClientAuthType]
+	if webTLSOpts.ClientCA == "" {
+		if webTLSOpts.AllowedCN != "" || webTLSOpts.ExemptHealth {
+			return nil, errors.New("--web-tls-allowed-cn and --web-tls-exempt-health require --web-tls-client-ca")
+		}
+		if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+			return nil, fmt.Errorf("client_auth_type %s requires client_ca_file", webTLSOpts.ClientAuthType)
+		}
+		c.ClientAuth = clientAuth
+		return c, nil
+	}
+	pem, err := ioutil.ReadFile(webTLSOpts.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	c.ClientCAs = x509.NewCertPool()
+	if !c.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", webTLSOpts.ClientCA)
+	}
+	c.ClientAuth = tls.RequireAndVerifyClientCert
+	if webTLSOpts.ExemptHealth {
+		// Certificates are still verified when given, and required
+		// by requireClientCert for everything but the health endpoints
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if custom {
+		if clientAuth == tls.NoClientCert {
+			return nil, errors.New("client_ca_file requires a client_auth_type other than NoClientCert")
+		}
+		c.ClientAuth = clientAuth
+	}
+	if webTLSOpts.AllowedCN != "" {
+		allowed := make(map[string]bool)
+		for _, cn := range strings.Split(webTLSOpts.AllowedCN, ",") {
+			allowed[strings.TrimSpace(cn)] = true
+		}
+		c.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+			if len(chains) == 0 {
+				return nil
+			}
+			if cn := chains[0][0].Subject.CommonName; !allowed[cn] {
+				return fmt.Errorf("client certificate %q is not allowed", cn)
+			}
+			return nil
+		}
+	}
+	return c, nil
+}
+
+// webServerCert is the certificate of the HTTPS server, or nil when serving
+// plain HTTP
+var webServerCert *webCert
+
+// webCertCheckInterval is the least time between checks of the certificate
+// files for changes
+const webCertCheckInterval = time.Second
+
+// webCert is the certificate of the HTTPS server. It is read again when the
+// files change, as when cert-manager rotates them, or on SIGHUP, and the
+// current certificate is kept when the new files fail to load.
+type webCert struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	mtimes  [2]time.Time // of the files when last read
+	checked time.Time
+}
+
+// load reads the certificate and key files
+func (c *webCert) load() error {
+	mtimes := c.modTimes()
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A failed read is not retried until the files change again
+	c.mtimes = mtimes
+	if err != nil {
+		return fmt.Errorf("certificate: %v", err)
+	}
+	c.cert = &cert
+	return nil
+}
+
+// reload reads the files again, logging the outcome
+func (c *webCert) reload() {
+	if err := c.load(); err != nil {
+		log.Printf("level=error msg=\"reloading the https certificate failed, keeping the current one\" err=%q", err)
+	} else {
+		log.Printf("Reloaded the https certificate")
+	}
+}
+
+// modTimes returns the modification times of the files
+func (c *webCert) modTimes() [2]time.Time {
+	var mtimes [2]time.Time
+	for i, name := range []string{c.certFile, c.keyFile} {
+		if fi, err := os.Stat(name); err == nil {
+			mtimes[i] = fi.ModTime()
+		}
+	}
+	return mtimes
+}
+
+// get returns the certificate for a handshake, reading the files again
+// when they changed
+func (c *webCert) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	changed := false
+	if time.Since(c.checked) >= webCertCheckInterval {
+		c.checked = time.Now()
+		changed = c.modTimes() != c.mtimes
+	}
+	c.mu.Unlock()
+	if changed {
+		c.reload()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
+
+// requireClientCert rejects the requests without a verified client
+// certificate, other than those of the health endpoints, when they are
+// exempted from client certificates
+func requireClientCert(h http.Handler) http.Handler {
+	if !webTLSOpts.ExemptHealth {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isHealthPath(r.URL.Path) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"golang.org/x/net/websocket"
+)
+
+// isWebSocketAddr reports whether the target address is the URL of the
+// WebSocket endpoint of Tile38
+func isWebSocketAddr(addr string) bool {
+	return strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://")
+}
+
+// wsConn runs the commands of a target over a WebSocket, one text message
+// per command and per JSON reply. Queued commands are sent at once and their
+// replies read back in order, as over RESP.
+type wsConn struct {
+	ws     *websocket.Conn
+	nc     net.Conn
+	queued []command
+	err    error // set once the connection fails, so the pool drops it
+}
+
+var _ redis.ConnWithTimeout = (*wsConn)(nil)
+
+// dialWS opens a WebSocket to the target, authenticated like connections
+// over RESP are
+func (t *target) dialWS() (redis.Conn, error) {
+	useTLS := strings.HasPrefix(t.Addr, "wss://")
+	host := t.Addr[strings.Index(t.Addr, "://")+3:]
+	if err := t.dials.check(); err != nil {
+		return nil, err
+	}
+	nc, err := t.dialWSNet(host, useTLS)
+	t.dials.record(err)
+	if err != nil {
+		return nil, err
+	}
+	origin := "http://" + host
+	if useTLS {
+		origin = "https://" + host
+	}
+	cfg, err := websocket.NewConfig(t.Addr+"/", origin)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Now().Add(timeouts.Connect))
+	ws, err := websocket.NewClient(cfg, nc)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("websocket handshake: %v", err)
+	}
+	nc.SetDeadline(time.Time{})
+	conn := &wsConn{ws: ws, nc: nc}
+	if err := t.setup(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialWSNet connects to the host of a WebSocket, over TLS for wss://
+func (t *target) dialWSNet(host string, useTLS bool) (net.Conn, error) {
+	nc, err := dialNet(newDialer(), "tcp", host)
+	if err != nil || !useTLS {
+		return nc, err
+	}
+	cfg := &tls.Config{}
+	if t.tls != nil {
+		cfg = t.tls.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(host)
+	}
+	tc := tls.Client(nc, cfg)
+	tc.SetDeadline(time.Now().Add(timeouts.Connect))
+	if err := tc.Handshake(); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+	tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+func (c *wsConn) Close() error { return c.ws.Close() }
+
+func (c *wsConn) Err() error { return c.err }
+
+func (c *wsConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.DoWithTimeout(timeouts.ReadWrite, cmd, args...)
+}
+
+// DoWithTimeout runs the command, or the queued commands when cmd is empty,
+// giving up after the timeout unless it is 0. The connection is closed when
+// the context passed by a ctxConn is cancelled.
+func (c *wsConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	var ctx context.Context
+	if len(args) > 0 {
+		if co, ok := args[0].(cancelOn); ok {
+			ctx, args = co.ctx, args[1:]
+		}
+	}
+	if ctx != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.nc.Close()
+			case <-done:
+			}
+		}()
+	}
+	if timeout > 0 {
+		c.nc.SetDeadline(time.Now().Add(timeout))
+	} else {
+		c.nc.SetDeadline(time.Time{})
+	}
+	if cmd != "" {
+		c.queued = append(c.queued, command{cmd, args})
+	}
+	queued := c.queued
+	c.queued = nil
+	replies := make([]interface{}, len(queued))
+	err := c.exchange(queued, replies)
+	if err != nil {
+		c.err = err
+		if ctx != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, err
+	}
+	if cmd != "" {
+		return replies[0], nil
+	}
+	if len(replies) == 0 {
+		return nil, nil
+	}
+	return replies, nil
+}
+
+// exchange sends the commands and reads their replies
+func (c *wsConn) exchange(cmds []command, replies []interface{}) error {
+	for _, q := range cmds {
+		if err := websocket.Message.Send(c.ws, commandLine(q)); err != nil {
+			return err
+		}
+	}
+	for i := range replies {
+		var msg []byte
+		if err := websocket.Message.Receive(c.ws, &msg); err != nil {
+			return err
+		}
+		replies[i] = msg
+	}
+	return nil
+}
+
+func (c *wsConn) Send(cmd string, args ...interface{}) error {
+	c.queued = append(c.queued, command{cmd, args})
+	return nil
+}
+
+func (c *wsConn) Flush() error { return nil }
+
+func (c *wsConn) Receive() (interface{}, error) {
+	return nil, errors.New("websocket transport: receive is not supported")
+}
+
+func (c *wsConn) ReceiveWithTimeout(time.Duration) (interface{}, error) {
+	return c.Receive()
+}
+
+// commandLine formats a command as a line, quoting the arguments holding
+// spaces or quotes
+func commandLine(c command) string {
+	parts := []string{c.Name}
+	for _, arg := range c.Args {
+		s := fmt.Sprint(arg)
+		if s == "" || strings.ContainsAny(s, " \t\r\n\"'\\") {
+			s = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}